@@ -22,6 +22,9 @@ const (
 	StateTexasGame
 	StateLiarGame
 	StateUndercoverGame
+	StateReplay
+	StateMatch
+	StateTournament
 )
 
 type SkillID int
@@ -48,15 +51,22 @@ const (
 	// MaxPlayers https://github.com/ratel-online/server/issues/14 小鄧修改
 	MaxPlayers = 3
 
+	// MaxNameLength 玩家名称的最大长度
+	MaxNameLength = 16
+	// MinPasswordLength 账号密码的最小长度
+	MinPasswordLength = 6
+	// ReplayListSize 回放列表中显示的对局数量
+	ReplayListSize = 20
+
 	RoomStateWaiting = 1
 	RoomStateRunning = 2
 
-	GameTypeClassic = 1
-	GameTypeLaiZi   = 2
-	GameTypeSkill   = 3
-	GameTypeRunFast = 4
-	GameTypeTexas   = 5
-	GameTypeMahjong = 6
+	GameTypeClassic    = 1
+	GameTypeLaiZi      = 2
+	GameTypeSkill      = 3
+	GameTypeRunFast    = 4
+	GameTypeTexas      = 5
+	GameTypeMahjong    = 6
 	GameTypeLiar       = 7
 	GameTypeUno        = 8
 	GameTypeUndercover = 9
@@ -65,22 +75,186 @@ const (
 	PlayTimeout        = 40 * time.Second
 	PlayMahjongTimeout = 30 * time.Second
 	BetTimeout         = 60 * time.Second
+
+	// SessionGracePeriod 断线后保留会话的时长，期间可凭令牌重连
+	SessionGracePeriod = 3 * time.Minute
+	// TournamentRetention 锦标赛结束后保留最终排名的时长，之后从锦标赛列表中移除
+	TournamentRetention = 10 * time.Minute
+	// RoomRestoreTimeout 重启后恢复的空房间等待玩家加入的时长
+	RoomRestoreTimeout = 10 * time.Minute
+	// RobotThinkTime 机器人每次作答前的思考时间
+	RobotThinkTime = time.Second
+	// GameStopInterval 解散进行中的房间时，反复唤醒对局协程的间隔
+	GameStopInterval = 100 * time.Millisecond
+
+	// LandlordBaseScore 斗地主的底分，实际输赢为底分乘以倍数
+	LandlordBaseScore = 10
+	// RunFastCardScore 跑得快每张剩余手牌的分数
+	RunFastCardScore = 10
+	// RunFastBombScore 跑得快每个炸弹从其他玩家处各得到的分数
+	RunFastBombScore = 50
+	// UnoTargetScore Uno 累计分数先达到该分数的玩家赢得整场比赛
+	UnoTargetScore = 500
+	// UnoCatchPenalty Uno 剩一张牌没有喊 UNO 被抓到时罚摸的张数
+	UnoCatchPenalty = 2
+	// UnoChallengePenalty 质疑 +4 失败时在罚摸张数之外多摸的张数
+	UnoChallengePenalty = 2
+	// MahjongBaseScore 麻将每番的分数
+	MahjongBaseScore = 10
+	// MahjongMinFan 广东麻将胡牌的最少番数
+	MahjongMinFan = 3
+	// TexasEquityEvaluations 德州扑克训练模式估算一次胜率最多评估的牌型数，模拟次数按对手人数折算
+	TexasEquityEvaluations = 2000
+	// TexasMaxBlindLevel 德州扑克盲注最多升级的次数
+	TexasMaxBlindLevel = 20
+	// TexasRaiseCap 德州扑克限注模式每轮最多的下注和加注次数，翻牌前的大盲注算作第一次
+	TexasRaiseCap = 4
+)
+
+// 德州扑克的下注限制
+const (
+	TexasNoLimit    = 0 // 无限注，最少加注上一次加注的大小，最多全下
+	TexasPotLimit   = 1 // 底池限注，最多加注到跟注后底池的大小
+	TexasFixedLimit = 2 // 固定限注，翻牌前和翻牌圈每次加注一个大盲注，转牌和河牌圈两个大盲注
+)
+
+var TexasLimits = map[int]string{
+	TexasNoLimit:    "nl",
+	TexasPotLimit:   "pl",
+	TexasFixedLimit: "fl",
+}
+
+var TexasLimitNames = map[int]string{
+	TexasNoLimit:    "no-limit",
+	TexasPotLimit:   "pot-limit",
+	TexasFixedLimit: "fixed-limit",
+}
+
+// 麻将的地方玩法
+const (
+	MahjongVariantDefault   = 0 // 经典玩法，136 张牌，可以吃、碰、杠，一人胡牌即结束
+	MahjongVariantSichuan   = 1 // 四川麻将，108 张牌，不能吃，定缺，血战到底
+	MahjongVariantGuangdong = 2 // 广东麻将，144 张牌，花牌加番，胡牌有最少番数
 )
 
+var MahjongVariants = map[int]string{
+	MahjongVariantDefault:   "default",
+	MahjongVariantSichuan:   "sichuan",
+	MahjongVariantGuangdong: "guangdong",
+}
+
 // Room properties.
 const (
-	RoomPropsDotShuffle     = "ds"
-	RoomPropsLaiZi          = "lz"
-	RoomPropsSkill          = "sk"
-	RoomPropsPassword       = "pwd"
-	RoomPropsPlayerNum      = "pn"
-	RoomPropsChat           = "ct"
-	RoomPropsShowIP         = "ip"
-	RoomPropsJokerAsTarget  = "jt"
-	RoomPropsUndercoverNum  = "ucn" // 卧底数量
-	RoomPropsBlankWordMode  = "bwm" // 空白词模式
+	RoomPropsDotShuffle    = "ds"
+	RoomPropsLaiZi         = "lz"
+	RoomPropsSkill         = "sk"
+	RoomPropsPassword      = "pwd"
+	RoomPropsPlayerNum     = "pn"
+	RoomPropsChat          = "ct"
+	RoomPropsShowIP        = "ip"
+	RoomPropsJokerAsTarget = "jt"
+	RoomPropsUndercoverNum = "ucn"   // 卧底数量
+	RoomPropsBlankWordMode = "bwm"   // 空白词模式
+	RoomPropsSmallBlind    = "sb"    // 德州扑克小盲注
+	RoomPropsBigBlind      = "bb"    // 德州扑克大盲注
+	RoomPropsAnte          = "ante"  // 德州扑克前注
+	RoomPropsStack         = "stack" // 德州扑克起始筹码，off 时使用玩家余额
+	RoomPropsBlindLevel    = "lvl"   // 德州扑克盲注升级间隔，如 5h 表示每 5 手，10m 表示每 10 分钟
+	RoomPropsSeries        = "sr"    // 系列赛，bo3 表示三局两胜，ft5 表示先赢 5 局，off 关闭
+	RoomPropsSpectatorChat = "sc"    // 观众在对局中的聊天是否发给玩家
+	RoomPropsReveal        = "rv"    // 延迟多少秒向观众公开所有玩家的手牌，off 关闭
+	RoomPropsDrawUntil     = "du"    // Uno 没有能出的牌时一直摸到能出为止，off 时只摸一张
+	RoomPropsStackDraws    = "sd"    // Uno 可以在 +2/+4 上叠加 +2/+4，由最后不能叠加的玩家摸全部的牌
+	RoomPropsMahjongRule   = "mv"    // 麻将的地方玩法，default、sichuan 或 guangdong
+	RoomPropsTraining      = "tm"    // 德州扑克训练模式，下注时向玩家显示当前的牌型和胜率
+	RoomPropsBettingLimit  = "bl"    // 德州扑克的下注限制，nl 无限注、pl 底池限注或 fl 固定限注
 )
 
+// 玩家连接的协议模式，登录时协商
+const (
+	ModeText   = 0 // 纯文本，适用于命令行客户端
+	ModeObject = 1 // 结构化 JSON 消息，适用于图形客户端
+)
+
+// 结构化消息的类型，编号与 core 中的 Code 区分
+const (
+	CodeMessage     = 2000 // 普通文本消息
+	CodeHandDealt   = 2001 // 发牌
+	CodeTurnStarted = 2002 // 轮到某位玩家行动
+	CodePlayMade    = 2003 // 出牌或不出
+	CodeBetPlaced   = 2004 // 下注
+	CodeVoteCast    = 2005 // 投票
+	CodeGameOver    = 2006 // 游戏结束
+	CodeRoomInfo    = 2007 // 房间详情
+	CodeStakes      = 2008 // 快速匹配的盲注级别选项
+	CodeTournaments = 2009 // 锦标赛列表
+	CodeStandings   = 2010 // 锦标赛排名
+	CodeStats       = 2011 // 玩家统计
+	CodeLeaderboard = 2012 // 排行榜
+	CodeSeries      = 2013 // 系列赛比分
+	CodeSpectate    = 2014 // 观众看到的对局
+	CodeError       = 2099 // 错误提示
+)
+
+// Texas defaults.
+const (
+	TexasSmallBlind = 10
+	TexasBigBlind   = 20
+)
+
+// MatchPlayers 快速匹配时每种游戏凑齐多少名玩家开局
+var MatchPlayers = map[int]int{
+	GameTypeClassic:    3,
+	GameTypeLaiZi:      3,
+	GameTypeSkill:      3,
+	GameTypeRunFast:    3,
+	GameTypeTexas:      2,
+	GameTypeMahjong:    3,
+	GameTypeLiar:       2,
+	GameTypeUno:        2,
+	GameTypeUndercover: 3,
+}
+
+// 锦标赛状态
+const (
+	TournamentStateRegistering = 1
+	TournamentStateRunning     = 2
+	TournamentStateFinished    = 3
+)
+
+var TournamentStates = map[int]string{
+	TournamentStateRegistering: "registering",
+	TournamentStateRunning:     "running",
+	TournamentStateFinished:    "finished",
+}
+
+// TournamentTables 支持锦标赛的游戏及每张牌桌的人数
+var TournamentTables = map[int]int{
+	GameTypeClassic: 3,
+	GameTypeRunFast: 3,
+	GameTypeTexas:   6,
+	GameTypeLiar:    4,
+}
+
+const (
+	// TournamentDelay 锦标赛每轮开始前的等待时间
+	TournamentDelay = 5 * time.Second
+	// TournamentStack 德州扑克锦标赛的起始筹码
+	TournamentStack = 1500
+	// TournamentBlindLevelHands 德州扑克锦标赛每隔多少手升级盲注
+	TournamentBlindLevelHands = 10
+)
+
+// LeaderboardSize 排行榜显示的玩家数量
+const LeaderboardSize = 10
+
+// TexasStakes 快速匹配中德州扑克可选的盲注级别（小盲、大盲），第一档为房间默认值
+var TexasStakes = [][2]uint{
+	{TexasSmallBlind, TexasBigBlind},
+	{50, 100},
+	{200, 400},
+}
+
 var MnemonicSorted = []int{15, 14, 2, 1, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3}
 
 var RunFastMnemonicSorted = []int{2, 1, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3}
@@ -122,7 +296,27 @@ var (
 	ErrorsGamePlayersInsufficient = NewErr(1, false, "Game players insufficient. ")
 	ErrorsCannotKickYourself      = NewErr(1, false, "Cannot kick yourself. ")
 	ErrorsPlayerNotInRoom         = NewErr(1, true, "Player not in room. ")
-	GameTypes = map[int]string{
+	ErrorsNameInvalid             = NewErr(1, true, "Name invalid. ")
+	ErrorsNameRegistered          = NewErr(1, true, "Name has been registered, please login with password. ")
+	ErrorsPasswordTooShort        = NewErr(1, true, "Password is too short. ")
+	ErrorsAccountInvalid          = NewErr(1, true, "Name or password incorrect. ")
+	ErrorsGuestDisabled           = NewErr(1, true, "Guest login is disabled, please register. ")
+	ErrorsRobotUnsupported        = NewErr(1, false, "Robots are not supported in this game. ")
+	ErrorsRobotNotFound           = NewErr(1, false, "There is no robot in this room. ")
+	ErrorsGameNotFound            = NewErr(1, false, "Game not found. ")
+	ErrorsPlayerNotFound          = NewErr(1, false, "Player not found. ")
+	ErrorsServerDraining          = NewErr(1, false, "Server is restarting, new games are disabled. ")
+	ErrorsStakeInvalid            = NewErr(1, false, "Stake level invalid. ")
+	ErrorsTournamentNotFound      = NewErr(1, false, "Tournament not found. ")
+	ErrorsTournamentStarted       = NewErr(1, false, "Tournament has already started. ")
+	ErrorsTournamentOrganizer     = NewErr(1, false, "Only the organizer can start the tournament. ")
+	ErrorsTournamentPlayers       = NewErr(1, false, "Tournament needs at least 2 players. ")
+	ErrorsUndercoverPlayers       = NewErr(1, false, "谁是卧底游戏至少需要3名玩家！")
+	ErrorsSwapUnavailable         = NewErr(1, false, "Seat swap is only available to players in a running game. ")
+	ErrorsSubstituteNotFound      = NewErr(1, false, "No spectator or robot can take over your seat. ")
+	ErrorsUnoUnavailable          = NewErr(1, false, "You can only say UNO with two or fewer cards. ")
+	ErrorsUnoNobodyToCatch        = NewErr(1, false, "Nobody forgot to say UNO. ")
+	GameTypes                     = map[int]string{
 		GameTypeClassic:    "斗地主",
 		GameTypeLaiZi:      "斗地主-癞子版",
 		GameTypeSkill:      "斗地主-大招版",
@@ -130,6 +324,7 @@ var (
 		GameTypeTexas:      "德州扑克",
 		GameTypeMahjong:    "Mahjong",
 		GameTypeLiar:       "liar's bar",
+		GameTypeUno:        "Uno",
 		GameTypeUndercover: "谁是卧底",
 	}
 	GameTypesIds = []int{
@@ -140,6 +335,7 @@ var (
 		GameTypeTexas,
 		GameTypeMahjong,
 		GameTypeLiar,
+		GameTypeUno,
 		GameTypeUndercover,
 	}
 	RoomStates = map[int]string{
@@ -0,0 +1,201 @@
+package database
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	stringx "strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/awesome-cap/hashmap"
+	"github.com/ratel-online/core/log"
+	"github.com/ratel-online/core/util/json"
+	"github.com/ratel-online/core/util/strings"
+	"github.com/ratel-online/server/consts"
+)
+
+const (
+	bucketAccounts = "accounts"
+
+	passwordIterations = 100000
+	passwordKeyLength  = 32
+)
+
+// 玩家 ID 分配器，账号与游客共用，保证 ID 不重复
+var playerIds int64 = 0
+
+// 注册账号和分配游客名称时加锁，保证名称唯一
+var accountLock sync.Mutex
+
+// 已分配给游客的名称（小写），游客会话注销时释放
+var guestNames = map[string]bool{}
+
+// GuestEnabled 是否允许游客不注册直接登录
+var GuestEnabled = true
+
+// account 注册账号
+type account struct {
+	ID         int64     `json:"id"`
+	Name       string    `json:"name"`
+	Salt       string    `json:"salt"`
+	Hash       string    `json:"hash"`
+	CreateTime time.Time `json:"createTime"`
+}
+
+// accountKey 账号按名称存储，名称不区分大小写
+func accountKey(name string) string {
+	return stringx.ToLower(name)
+}
+
+func loadAccount(name string) *account {
+	data, ok, err := store.Get(bucketAccounts, accountKey(name))
+	if err != nil {
+		log.Error(err)
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+	a := &account{}
+	if err = json.Unmarshal(data, a); err != nil {
+		log.Error(err)
+		return nil
+	}
+	return a
+}
+
+// restorePlayerIds 根据已注册账号恢复 ID 分配器，避免新账号与老账号 ID 重复
+func restorePlayerIds() {
+	err := store.Foreach(bucketAccounts, func(key string, value []byte) error {
+		a := account{}
+		if err := json.Unmarshal(value, &a); err != nil {
+			return err
+		}
+		for {
+			last := atomic.LoadInt64(&playerIds)
+			if last >= a.ID || atomic.CompareAndSwapInt64(&playerIds, last, a.ID) {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+func hashPassword(password string, salt []byte) string {
+	key, _ := pbkdf2.Key(sha256.New, password, salt, passwordIterations, passwordKeyLength)
+	return hex.EncodeToString(key)
+}
+
+func checkName(name string) error {
+	name = stringx.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > consts.MaxNameLength || name != strings.Desensitize(name) {
+		return consts.ErrorsNameInvalid
+	}
+	return nil
+}
+
+// Register 注册账号，名称已被注册时返回错误
+func Register(name, password string) (int64, error) {
+	name = stringx.TrimSpace(name)
+	if err := checkName(name); err != nil {
+		return 0, err
+	}
+	if len(password) < consts.MinPasswordLength {
+		return 0, consts.ErrorsPasswordTooShort
+	}
+	accountLock.Lock()
+	defer accountLock.Unlock()
+	if loadAccount(name) != nil {
+		return 0, consts.ErrorsNameRegistered
+	}
+	salt := make([]byte, 16)
+	_, _ = rand.Read(salt)
+	a := account{
+		ID:         atomic.AddInt64(&playerIds, 1),
+		Name:       name,
+		Salt:       hex.EncodeToString(salt),
+		Hash:       hashPassword(password, salt),
+		CreateTime: time.Now(),
+	}
+	if err := store.Put(bucketAccounts, accountKey(name), json.Marshal(a)); err != nil {
+		log.Error(err)
+		return 0, consts.ErrorsAuthFail
+	}
+	log.Infof("account %s[%d] registered.\n", a.Name, a.ID)
+	return a.ID, nil
+}
+
+// Login 校验账号密码，返回账号 ID 和注册时的名称
+func Login(name, password string) (int64, string, error) {
+	a := loadAccount(stringx.TrimSpace(name))
+	if a == nil {
+		return 0, "", consts.ErrorsAccountInvalid
+	}
+	salt, err := hex.DecodeString(a.Salt)
+	if err != nil {
+		log.Error(err)
+		return 0, "", consts.ErrorsAccountInvalid
+	}
+	if subtle.ConstantTimeCompare([]byte(hashPassword(password, salt)), []byte(a.Hash)) != 1 {
+		return 0, "", consts.ErrorsAccountInvalid
+	}
+	return a.ID, a.Name, nil
+}
+
+// Guest 为游客分配 ID 和不重复的名称，游客不能使用已注册的名称
+func Guest(name string) (int64, string, error) {
+	if !GuestEnabled {
+		return 0, "", consts.ErrorsGuestDisabled
+	}
+	name = stringx.TrimSpace(strings.Desensitize(name))
+	if err := checkName(name); err != nil {
+		return 0, "", err
+	}
+	accountLock.Lock()
+	defer accountLock.Unlock()
+	if loadAccount(name) != nil {
+		return 0, "", consts.ErrorsNameRegistered
+	}
+	unique := name
+	for i := 2; guestNames[accountKey(unique)] || nameInUse(unique); i++ {
+		unique = fmt.Sprintf("%s_%d", name, i)
+	}
+	guestNames[accountKey(unique)] = true
+	return atomic.AddInt64(&playerIds, 1), unique, nil
+}
+
+// releaseGuest 游客会话注销后释放占用的名称
+func releaseGuest(name string) {
+	accountLock.Lock()
+	defer accountLock.Unlock()
+	delete(guestNames, accountKey(name))
+}
+
+// nameInUse 名称是否被当前会话中的玩家占用
+func nameInUse(name string) bool {
+	inUse := false
+	sessions.Foreach(func(e *hashmap.Entry) {
+		if stringx.EqualFold(e.Value().(*Player).Name, name) {
+			inUse = true
+		}
+	})
+	return inUse
+}
+
+// profileKey 账号的档案按 ID 保存，游客的档案按会话令牌保存，同名的游客不会继承之前的档案
+func profileKey(id int64, guest bool, token string) string {
+	if guest {
+		return "guest:" + token
+	}
+	return "account:" + strconv.FormatInt(id, 10)
+}
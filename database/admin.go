@@ -0,0 +1,90 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/awesome-cap/hashmap"
+	"github.com/ratel-online/core/log"
+	"github.com/ratel-online/server/consts"
+)
+
+// OnlinePlayers 当前持有会话的全部玩家（包括断线宽限期内的玩家），按 ID 排序
+func OnlinePlayers() []*Player {
+	list := make([]*Player, 0)
+	sessions.Foreach(func(e *hashmap.Entry) {
+		list = append(list, e.Value().(*Player))
+	})
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// CloseRoom 强制解散房间，房间中的玩家和观众回到大厅
+func CloseRoom(roomId int64) error {
+	if err := DissolveRoom(roomId, "This room has been closed by the administrator.\n"); err != nil {
+		return err
+	}
+	log.Infof("room %d closed by the administrator.\n", roomId)
+	return nil
+}
+
+// DissolveRoom 向房间广播原因后解散房间，房间中的玩家和观众回到大厅
+func DissolveRoom(roomId int64, reason string) error {
+	room := getRoom(roomId)
+	if room == nil {
+		return consts.ErrorsRoomInvalid
+	}
+	room.Lock()
+	defer room.Unlock()
+	broadcast(room, reason)
+	for id := range getRoomPlayers(room.ID) {
+		if p := getPlayer(id); p != nil {
+			p.RoomID = 0
+			p.Role = ""
+		}
+	}
+	for id := range getRoomSpectators(room.ID) {
+		if p := getPlayer(id); p != nil {
+			p.RoomID = 0
+			p.Role = ""
+		}
+	}
+	deleteRoom(room)
+	return nil
+}
+
+// KickPlayer 强制注销玩家会话并断开连接，被踢出的玩家不能再恢复会话
+func KickPlayer(playerId int64) error {
+	player := getPlayer(playerId)
+	if player == nil || player.robot {
+		return consts.ErrorsPlayerNotFound
+	}
+	if _, ok := sessions.Get(player.token); !ok {
+		return consts.ErrorsPlayerNotFound
+	}
+	_ = player.WriteString("You have been kicked by the administrator.\n")
+	Broadcast(player.RoomID, fmt.Sprintf("%s has been kicked by the administrator!\n", player.Name), player.ID)
+	sessions.Del(player.token)
+	player.lock.Lock()
+	player.kicked = true
+	online := player.online
+	player.lock.Unlock()
+	if !online {
+		// 已经断线的玩家没有在读取连接，可以直接注销
+		player.Offline()
+	} else {
+		// 只关闭连接，由读取连接的协程在退出时注销会话，避免向已关闭的通道发送数据
+		_ = player.conn.Close()
+	}
+	log.Infof("player %s kicked by the administrator.\n", player)
+	return nil
+}
+
+// Notice 向全部在线玩家发送系统公告
+func Notice(msg string) {
+	for _, player := range OnlinePlayers() {
+		_ = player.WriteString(fmt.Sprintf("[Notice] %s\n", msg))
+	}
+}
@@ -12,9 +12,9 @@ import (
 	modelx "github.com/ratel-online/core/model"
 	"github.com/ratel-online/core/network"
 	"github.com/ratel-online/core/util/async"
-	"github.com/ratel-online/core/util/json"
 	"github.com/ratel-online/core/util/strings"
 	"github.com/ratel-online/server/consts"
+	"github.com/spf13/cast"
 )
 
 var roomIds int64 = 0
@@ -71,6 +71,79 @@ var roomPropsSetter = map[string]func(r *Room, v string){
 	consts.RoomPropsBlankWordMode: func(r *Room, v string) {
 		r.BlankWordMode = v == "on"
 	},
+	consts.RoomPropsSmallBlind: func(r *Room, v string) {
+		n := cast.ToUint(v)
+		if n == 0 {
+			return
+		}
+		r.SmallBlind = n
+		if r.BigBlind < n {
+			r.BigBlind = n * 2
+		}
+	},
+	consts.RoomPropsBigBlind: func(r *Room, v string) {
+		n := cast.ToUint(v)
+		if n == 0 {
+			return
+		}
+		r.BigBlind = n
+		if r.SmallBlind > n {
+			r.SmallBlind = max(n/2, 1)
+		}
+	},
+	consts.RoomPropsAnte: func(r *Room, v string) {
+		r.Ante = cast.ToUint(v)
+	},
+	consts.RoomPropsStack: func(r *Room, v string) {
+		r.Stack = cast.ToUint(v)
+	},
+	consts.RoomPropsSeries: setSeriesMode,
+	consts.RoomPropsSpectatorChat: func(r *Room, v string) {
+		r.EnableSpectatorChat = v == "on"
+	},
+	consts.RoomPropsReveal: func(r *Room, v string) {
+		n, _ := strconv.Atoi(v)
+		r.RevealDelay = time.Duration(max(n, 0)) * time.Second
+	},
+	consts.RoomPropsDrawUntil: func(r *Room, v string) {
+		r.EnableDrawUntil = v == "on"
+	},
+	consts.RoomPropsStackDraws: func(r *Room, v string) {
+		r.EnableStackDraws = v == "on"
+	},
+	consts.RoomPropsTraining: func(r *Room, v string) {
+		r.EnableTraining = v == "on"
+	},
+	consts.RoomPropsBettingLimit: func(r *Room, v string) {
+		for limit, name := range consts.TexasLimits {
+			if stringx.EqualFold(v, name) {
+				r.BettingLimit = limit
+			}
+		}
+	},
+	consts.RoomPropsMahjongRule: func(r *Room, v string) {
+		for variant, name := range consts.MahjongVariants {
+			if stringx.EqualFold(v, name) {
+				r.MahjongVariant = variant
+			}
+		}
+	},
+	consts.RoomPropsBlindLevel: func(r *Room, v string) {
+		r.BlindLevelHands = 0
+		r.BlindLevelTime = 0
+		switch {
+		case stringx.HasSuffix(v, "m"):
+			r.BlindLevelTime = time.Duration(cast.ToInt(stringx.TrimSuffix(v, "m"))) * time.Minute
+		case stringx.HasSuffix(v, "h"):
+			r.BlindLevelHands = cast.ToInt(stringx.TrimSuffix(v, "h"))
+		default:
+			r.BlindLevelHands = cast.ToInt(v)
+		}
+		if r.BlindLevelHands < 0 || r.BlindLevelTime < 0 {
+			r.BlindLevelHands = 0
+			r.BlindLevelTime = 0
+		}
+	},
 }
 
 func init() {
@@ -85,19 +158,28 @@ func init() {
 			rooms.Foreach(func(e *hashmap.Entry) {
 				roomCancel(e.Value().(*Room))
 			})
+			Flush()
 		}
 	})
 }
 
-func Connected(conn *network.Conn, info *modelx.AuthInfo) *Player {
+// Connected 登录成功后创建玩家，info 中的 ID 和名称由账号或游客登录分配
+func Connected(conn *network.Conn, info *modelx.AuthInfo, guest bool) *Player {
 	player := &Player{
-		ID:     conn.ID(),
+		ID:     info.ID,
 		IP:     conn.IP(),
-		Name:   strings.Desensitize(info.Name),
+		Name:   info.Name,
 		Amount: 2000,
+		Guest:  guest,
+		token:  newSessionToken(),
+	}
+	player.key = profileKey(player.ID, guest, player.token)
+	if p := loadProfile(player.key); p != nil {
+		player.Amount = p.Amount
 	}
 	player.Conn(conn)                  // 初始化play对象
-	players.Set(conn.ID(), player)     // 写入用户池
+	sessions.Set(player.token, player) // 写入会话池
+	players.Set(player.ID, player)     // 写入用户池
 	connPlayers.Set(conn.ID(), player) // 写入连接用户池
 	return player
 }
@@ -129,6 +211,8 @@ func CreateRoom(creator int64, t int) *Room {
 		room.EnableDontShuffle = true
 	case consts.GameTypeTexas:
 		room.MaxPlayers = 10
+		room.SmallBlind = consts.TexasSmallBlind
+		room.BigBlind = consts.TexasBigBlind
 	case consts.GameTypeLiar:
 		room.MaxPlayers = 4
 		room.EnableJokerAsTarget = true
@@ -140,17 +224,62 @@ func CreateRoom(creator int64, t int) *Room {
 	roomPlayers.Set(room.ID, map[int64]bool{})
 	roomSpectators.Set(room.ID, map[int64]int{})
 	rooms.Set(room.ID, room)
+	saveRoom(room)
 	return room
 }
 
 func deleteRoom(room *Room) {
 	if room != nil {
+		robots := make([]int64, 0)
+		for id := range getRoomPlayers(room.ID) {
+			if p := getPlayer(id); p != nil && p.robot {
+				robots = append(robots, id)
+			}
+		}
 		rooms.Del(room.ID)
 		roomPlayers.Del(room.ID)
 		roomSpectators.Del(room.ID)
+		removeRoom(room)
+		// 对局中途解散的房间也保留已发生的记录
+		FinishJournal(room)
 		if room.Game != nil {
-			room.Game.Clean()
+			room.State = consts.RoomStateWaiting
+		}
+		go stopGame(room, room.Game, robots)
+		// 调用方持有房间锁，锦标赛的处理放到协程中避免与锦标赛的锁互相等待
+		go tableClosed(room.ID)
+	}
+}
+
+// stopGame 结束已解散房间中的对局：反复唤醒对局协程，等它们全部退出后再关闭对局的通道并移除机器人，
+// 避免协程向已关闭的通道发送信号或者读到已经移除的机器人
+func stopGame(room *Room, game RoomGame, robots []int64) {
+	loopCount := 0
+	for game != nil && atomic.LoadInt32(&room.playing) > 0 {
+		loopCount++
+		if loopCount%100 == 0 {
+			log.Infof("[stopGame] Room %d loop count: %d, playing: %d\n", room.ID, loopCount, atomic.LoadInt32(&room.playing))
 		}
+		game.Stop()
+		time.Sleep(consts.GameStopInterval)
+	}
+	if game != nil {
+		game.Clean()
+	}
+	for _, id := range robots {
+		players.Del(id)
+	}
+}
+
+// wake 取出通道中积压的信号再放入一个新的信号，阻塞在通道上的接收方和发送方都能继续执行
+func wake(state chan int) {
+	select {
+	case <-state:
+	default:
+	}
+	select {
+	case state <- 0:
+	default:
 	}
 }
 
@@ -194,11 +323,22 @@ func SetRoomProps(room *Room, k, v string) {
 
 	if setter, ok := roomPropsSetter[k]; ok {
 		setter(room, v)
+		saveRoom(room)
 	}
 }
 
 // 根据游戏类型返回允许设置的属性列表
 func getAllowedPropsByGameType(gameType int) map[string]bool {
+	props := gameProps(gameType)
+	// 所有游戏都可以开启系列赛
+	props[consts.RoomPropsSeries] = true
+	// 观众相关的配置对所有游戏可用
+	props[consts.RoomPropsSpectatorChat] = true
+	props[consts.RoomPropsReveal] = true
+	return props
+}
+
+func gameProps(gameType int) map[string]bool {
 	switch gameType {
 	case consts.GameTypeLiar:
 		// 对于骗子酒馆，只允许设置指示牌规则和显示IP
@@ -216,19 +356,36 @@ func getAllowedPropsByGameType(gameType int) map[string]bool {
 			consts.RoomPropsShowIP:        true,
 			consts.RoomPropsPassword:      true,
 		}
-	case consts.GameTypeUno, consts.GameTypeMahjong:
-		// 对于Uno和麻将，允许设置玩家数量和显示IP
+	case consts.GameTypeUno:
+		// 对于Uno，允许设置玩家数量、显示IP、摸牌规则和叠加规则
 		return map[string]bool{
-			consts.RoomPropsPlayerNum: true,
-			consts.RoomPropsShowIP:    true,
-			consts.RoomPropsPassword:  true,
+			consts.RoomPropsPlayerNum:  true,
+			consts.RoomPropsShowIP:     true,
+			consts.RoomPropsPassword:   true,
+			consts.RoomPropsDrawUntil:  true,
+			consts.RoomPropsStackDraws: true,
+		}
+	case consts.GameTypeMahjong:
+		// 对于麻将，允许设置玩家数量、显示IP和地方玩法
+		return map[string]bool{
+			consts.RoomPropsPlayerNum:   true,
+			consts.RoomPropsShowIP:      true,
+			consts.RoomPropsPassword:    true,
+			consts.RoomPropsMahjongRule: true,
 		}
 	case consts.GameTypeTexas:
-		// 对于德州扑克，允许设置玩家数量和显示IP
+		// 对于德州扑克，允许设置玩家数量、显示IP、盲注结构、下注限制和训练模式
 		return map[string]bool{
-			consts.RoomPropsPlayerNum: true,
-			consts.RoomPropsShowIP:    true,
-			consts.RoomPropsPassword:  true,
+			consts.RoomPropsPlayerNum:    true,
+			consts.RoomPropsShowIP:       true,
+			consts.RoomPropsPassword:     true,
+			consts.RoomPropsSmallBlind:   true,
+			consts.RoomPropsBigBlind:     true,
+			consts.RoomPropsAnte:         true,
+			consts.RoomPropsStack:        true,
+			consts.RoomPropsBlindLevel:   true,
+			consts.RoomPropsTraining:     true,
+			consts.RoomPropsBettingLimit: true,
 		}
 	default:
 		// 其他游戏类型允许所有常规属性
@@ -312,6 +469,10 @@ func JoinRoom(roomId, playerId int64) error {
 		player.Role = RoleSpectator
 	} else {
 		playersIds := getRoomPlayers(roomId)
+		// 房主不在房间中时（如重启后恢复的房间），加入的玩家成为房主
+		if _, ok := playersIds[room.Creator]; !ok {
+			room.Creator = playerId
+		}
 		playersIds[playerId] = true
 		room.Players++
 		player.RoomID = roomId
@@ -347,9 +508,33 @@ func backfill(room *Room) *Player {
 	if room.Players >= room.MaxPlayers {
 		return nil
 	}
+	playerId := firstSpectator(room)
+	if playerId == 0 {
+		return nil
+	}
+	spectatorsIds := getRoomSpectators(room.ID)
+	delete(spectatorsIds, playerId)
+	playersIds := getRoomPlayers(room.ID)
+	if _, ok := playersIds[room.Creator]; !ok {
+		room.Creator = playerId
+	}
+	playersIds[playerId] = true
+	room.Players++
+	player := getPlayer(playerId)
+	if player != nil {
+		player.Role = RolePlayer
+		if room.Creator == playerId {
+			player.Role = RoleOwner
+		}
+	}
+	return player
+}
+
+// firstSpectator 最早进入房间的观众，没有观众时返回 0
+func firstSpectator(room *Room) int64 {
 	spectatorsIds := getRoomSpectators(room.ID)
 	if len(spectatorsIds) == 0 {
-		return nil
+		return 0
 	}
 	spectators := make([]struct {
 		id    int64
@@ -365,18 +550,7 @@ func backfill(room *Room) *Player {
 	sort.Slice(spectators, func(i, j int) bool {
 		return spectators[i].index < spectators[j].index
 	})
-
-	playerId := spectators[0].id
-
-	delete(spectatorsIds, playerId)
-	playersIds := getRoomPlayers(room.ID)
-	playersIds[playerId] = true
-	room.Players++
-	player := getPlayer(playerId)
-	if player != nil {
-		player.Role = RolePlayer
-	}
-	return player
+	return spectators[0].id
 }
 
 func Kicking(roomId, playerId int64) {
@@ -415,13 +589,14 @@ func leaveRoom(room *Room, player *Player) {
 		player.RoomID = 0
 		player.Role = ""
 		delete(playersIds, player.ID)
-		if len(playersIds) > 0 && room.Creator == player.ID {
+		if room.Creator == player.ID {
+			// 房主只转让给真人玩家
 			for k := range playersIds {
-				room.Creator = k
-				if p := getPlayer(k); p != nil {
+				if p := getPlayer(k); p != nil && !p.robot {
+					room.Creator = k
 					p.Role = RoleOwner
+					break
 				}
-				break
 			}
 		}
 	}
@@ -431,7 +606,12 @@ func leaveRoom(room *Room, player *Player) {
 		player.Role = ""
 		delete(spectatorsIds, player.ID)
 	}
-	if len(playersIds) == 0 && len(spectatorsIds) == 0 {
+	if player.robot {
+		room.Robots--
+		players.Del(player.ID)
+	}
+	// 只剩机器人时房间没有存在的意义
+	if !hasHumans(room) {
 		deleteRoom(room)
 	}
 }
@@ -442,17 +622,21 @@ func roomCancel(room *Room) {
 		deleteRoom(room)
 		return
 	}
-	living := false
 	playerIds := getRoomPlayers(room.ID)
+	spectatorIds := getRoomSpectators(room.ID)
+	// 重启后恢复的空房间保留一段时间，等待玩家重新加入
+	if len(playerIds) == 0 && len(spectatorIds) == 0 && room.ActiveTime.Add(consts.RoomRestoreTimeout).After(time.Now()) {
+		return
+	}
+	living := false
 	for id := range playerIds {
-		if getPlayer(id).online {
+		if p := getPlayer(id); p != nil && !p.closed && !p.robot {
 			living = true
 			break
 		}
 	}
-	spectatorIds := getRoomSpectators(room.ID)
 	for id := range spectatorIds {
-		if getPlayer(id).online {
+		if !getPlayer(id).closed {
 			living = true
 			break
 		}
@@ -472,23 +656,7 @@ func RoomSpectators(roomId int64) map[int64]int {
 }
 
 func broadcast(room *Room, msg string, exclude ...int64) {
-	room.ActiveTime = time.Now()
-	excludeSet := map[int64]bool{}
-	for _, exc := range exclude {
-		excludeSet[exc] = true
-	}
-	// 使用 "[系统] " 前缀而不是 ">> "，避免与输入提示符冲突
-	formattedMsg := ">> " + msg
-	for playerId := range getRoomPlayers(room.ID) {
-		if player := getPlayer(playerId); player != nil && !excludeSet[playerId] {
-			_ = player.WriteString(formattedMsg)
-		}
-	}
-	for playerId := range getRoomSpectators(room.ID) {
-		if player := getPlayer(playerId); player != nil && !excludeSet[playerId] {
-			_ = player.WriteString(formattedMsg)
-		}
-	}
+	broadcastEvent(room, Event{Code: consts.CodeMessage, Msg: msg}, exclude...)
 }
 
 func Broadcast(roomId int64, msg string, exclude ...int64) {
@@ -504,22 +672,17 @@ func BroadcastChat(player *Player, msg string, exclude ...int64) {
 	Broadcast(player.RoomID, strings.Desensitize(msg), exclude...)
 }
 
-func BroadcastObject(roomId int64, object interface{}, exclude ...int64) {
-	room := getRoom(roomId)
+// BroadcastSpectatorChat 只向房间内的观众发送聊天
+func BroadcastSpectatorChat(player *Player, msg string) {
+	log.Infof("spectator chat msg, player %s[%d] %s say: %s\n", player.Name, player.ID, player.IP, stringx.TrimSpace(msg))
+	room := getRoom(player.RoomID)
 	if room == nil {
 		return
 	}
-	room.Lock()
-	defer room.Unlock()
-	excludeSet := map[int64]bool{}
-	for _, exc := range exclude {
-		excludeSet[exc] = true
-	}
-	msg := json.Marshal(object)
-	playerIds := getRoomPlayers(roomId)
-	for playerId := range playerIds {
-		if player := getPlayer(playerId); player != nil && !excludeSet[playerId] {
-			_ = player.WriteString(string(msg))
+	e := Event{Code: consts.CodeMessage, Msg: strings.Desensitize(msg)}
+	for id := range getRoomSpectators(room.ID) {
+		if p := getPlayer(id); p != nil {
+			_ = p.WriteData(e, ">> "+e.Msg)
 		}
 	}
 }
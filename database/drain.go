@@ -0,0 +1,55 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/awesome-cap/hashmap"
+	"github.com/ratel-online/core/log"
+	"github.com/ratel-online/server/consts"
+)
+
+// 服务器是否处于停机前的排空状态
+var draining int32 = 0
+
+// Draining 排空状态下不再创建房间和开始新的对局
+func Draining() bool {
+	return atomic.LoadInt32(&draining) == 1
+}
+
+// RunningGames 正在进行中的对局数量
+func RunningGames() int {
+	count := 0
+	rooms.Foreach(func(e *hashmap.Entry) {
+		if e.Value().(*Room).State == consts.RoomStateRunning {
+			count++
+		}
+	})
+	return count
+}
+
+// Drain 进入排空状态，等待进行中的对局结束，最多等待 timeout，期间每分钟提醒一次在线玩家
+func Drain(timeout time.Duration) {
+	atomic.StoreInt32(&draining, 1)
+	deadline := time.Now().Add(timeout)
+	lastNotice := time.Time{}
+	for {
+		running := RunningGames()
+		left := time.Until(deadline)
+		if running == 0 || left <= 0 {
+			log.Infof("drain finished, %d games still running.\n", running)
+			break
+		}
+		if time.Since(lastNotice) >= time.Minute {
+			lastNotice = time.Now()
+			minutes := int(math.Ceil(left.Minutes()))
+			Notice(fmt.Sprintf("Server restarting in %d minutes, new games are disabled.", minutes))
+			log.Infof("draining, %d games running, %d minutes left.\n", running, minutes)
+		}
+		time.Sleep(time.Second)
+	}
+	Notice("Server restarting now, see you soon!")
+	Flush()
+}
@@ -0,0 +1,134 @@
+package database
+
+import (
+	"time"
+
+	"github.com/ratel-online/core/model"
+	"github.com/ratel-online/server/consts"
+)
+
+// EventPlayer 事件中涉及的玩家
+type EventPlayer struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+// Event 结构化消息，object 模式的客户端收到 JSON，text 模式的客户端只收到 Msg
+type Event struct {
+	Code    int           `json:"code"`
+	Msg     string        `json:"msg"`
+	Game    int           `json:"game,omitempty"`
+	Player  *EventPlayer  `json:"player,omitempty"`
+	Next    *EventPlayer  `json:"next,omitempty"`   // 下一位行动的玩家
+	Target  *EventPlayer  `json:"target,omitempty"` // 投票等操作的对象
+	Action  string        `json:"action,omitempty"`
+	Cards   []string      `json:"cards,omitempty"`
+	Amount  uint          `json:"amount,omitempty"`
+	Timeout int           `json:"timeout,omitempty"` // 本回合剩余秒数
+	Winners []EventPlayer `json:"winners,omitempty"`
+}
+
+// EventPlayerOf 返回玩家在事件中的表示，玩家不存在时返回 nil
+func EventPlayerOf(playerId int64) *EventPlayer {
+	player := getPlayer(playerId)
+	if player == nil {
+		return nil
+	}
+	return &EventPlayer{ID: player.ID, Name: player.Name}
+}
+
+// Cards 扑克牌在事件中的表示
+func Cards(pokers model.Pokers) []string {
+	cards := make([]string, 0, len(pokers))
+	for _, poker := range pokers {
+		cards = append(cards, poker.Desc)
+	}
+	return cards
+}
+
+// TexasCards 带花色的扑克牌在事件中的表示
+func TexasCards(pokers model.Pokers) []string {
+	cards := make([]string, 0, len(pokers))
+	for _, poker := range pokers {
+		cards = append(cards, poker.Suit.String()+poker.Desc)
+	}
+	return cards
+}
+
+// Timeout 事件中的剩余秒数
+func Timeout(d time.Duration) int {
+	return int(d.Seconds())
+}
+
+// WriteData 按玩家的协议模式发送消息，object 模式发送 data，text 模式只发送 msg
+func (p *Player) WriteData(data interface{}, msg string) error {
+	if p.Mode == consts.ModeObject {
+		return p.WriteObject(data)
+	}
+	return p.WriteString(msg)
+}
+
+// WriteEvent 按玩家的协议模式发送事件
+func (p *Player) WriteEvent(e Event) error {
+	return p.WriteData(e, e.Msg)
+}
+
+func broadcastEvent(room *Room, e Event, exclude ...int64) {
+	broadcastObject(room, e, e.Msg, exclude...)
+}
+
+func broadcastObject(room *Room, object interface{}, msg string, exclude ...int64) {
+	room.ActiveTime = time.Now()
+	excludeSet := map[int64]bool{}
+	for _, exc := range exclude {
+		excludeSet[exc] = true
+	}
+	// text 模式使用 ">> " 前缀，与输入提示符区分
+	text := ">> " + msg
+	write := func(player *Player) {
+		if player.Mode == consts.ModeObject {
+			_ = player.WriteObject(object)
+		} else {
+			_ = player.WriteString(text)
+		}
+	}
+	for playerId := range getRoomPlayers(room.ID) {
+		if player := getPlayer(playerId); player != nil && !excludeSet[playerId] {
+			write(player)
+		}
+	}
+	for playerId := range getRoomSpectators(room.ID) {
+		if player := getPlayer(playerId); player != nil && !excludeSet[playerId] {
+			write(player)
+		}
+	}
+}
+
+// BroadcastEvent 向房间内的玩家和观众广播事件
+func BroadcastEvent(roomId int64, e Event, exclude ...int64) {
+	room := getRoom(roomId)
+	if room == nil {
+		return
+	}
+	broadcastEvent(room, e, exclude...)
+}
+
+// BroadcastObject 向房间内的玩家和观众广播消息，object 模式的客户端收到 object，text 模式的客户端收到 msg
+func BroadcastObject(roomId int64, object interface{}, msg string, exclude ...int64) {
+	room := getRoom(roomId)
+	if room == nil {
+		return
+	}
+	broadcastObject(room, object, msg, exclude...)
+}
+
+// EventPlayers 多个玩家在事件中的表示，忽略不存在的玩家
+func EventPlayers(playerIds ...int64) []EventPlayer {
+	list := make([]EventPlayer, 0, len(playerIds))
+	for _, playerId := range playerIds {
+		if p := EventPlayerOf(playerId); p != nil {
+			list = append(list, *p)
+		}
+	}
+	return list
+}
@@ -0,0 +1,112 @@
+package database
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// fileStore 文件存储，每个 bucket 对应一个目录，每条数据对应一个文件
+type fileStore struct {
+	sync.RWMutex
+	dir string
+}
+
+// NewFileStore 创建以 dir 为根目录的文件存储，目录不存在时自动创建
+func NewFileStore(dir string) (Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &fileStore{dir: dir}, nil
+}
+
+func (s *fileStore) path(bucket, key string) string {
+	return filepath.Join(s.dir, url.PathEscape(bucket), url.PathEscape(key)+".json")
+}
+
+func (s *fileStore) Get(bucket, key string) ([]byte, bool, error) {
+	s.RLock()
+	defer s.RUnlock()
+	data, err := os.ReadFile(s.path(bucket, key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+func (s *fileStore) Put(bucket, key string, value []byte) error {
+	s.Lock()
+	defer s.Unlock()
+	path := s.path(bucket, key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// 先写临时文件再重命名，避免进程中断时留下不完整的数据
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, value, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *fileStore) Delete(bucket, key string) error {
+	s.Lock()
+	defer s.Unlock()
+	err := os.Remove(s.path(bucket, key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *fileStore) Foreach(bucket string, fn func(key string, value []byte) error) error {
+	s.RLock()
+	dir := filepath.Join(s.dir, url.PathEscape(bucket))
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		s.RUnlock()
+		return nil
+	}
+	if err != nil {
+		s.RUnlock()
+		return err
+	}
+	keys := make([]string, 0, len(entries))
+	values := make(map[string][]byte, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		key, err := url.PathUnescape(strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			s.RUnlock()
+			return err
+		}
+		keys = append(keys, key)
+		values[key] = data
+	}
+	s.RUnlock()
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := fn(key, values[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fileStore) Close() error {
+	return nil
+}
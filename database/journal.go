@@ -0,0 +1,214 @@
+package database
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ratel-online/core/log"
+	"github.com/ratel-online/core/util/json"
+	"github.com/ratel-online/server/consts"
+	"github.com/ratel-online/server/metrics"
+)
+
+const bucketGames = "games"
+
+// 对局 ID 分配器
+var gameIds int64 = 0
+
+// recentJournals 最近结束的对局 ID，按结束顺序保存，最多 consts.ReplayListSize 个，
+// 打开回放列表时只读取这些对局
+var recentJournals = struct {
+	sync.Mutex
+	ids []int64
+}{}
+
+// indexJournal 把结束的对局加入最近的对局列表
+func indexJournal(id int64) {
+	recentJournals.Lock()
+	defer recentJournals.Unlock()
+	recentJournals.ids = append(recentJournals.ids, id)
+	if n := len(recentJournals.ids) - consts.ReplayListSize; n > 0 {
+		recentJournals.ids = slices.Clone(recentJournals.ids[n:])
+	}
+}
+
+// Journal 一局游戏的记录，按发生顺序保存发牌、出牌、下注、投票、技能和结算等事件
+type Journal struct {
+	sync.Mutex
+
+	ID        int64          `json:"id"`
+	RoomID    int64          `json:"roomId"`
+	Type      int            `json:"type"`
+	Players   []string       `json:"players"`
+	StartTime time.Time      `json:"startTime"`
+	EndTime   time.Time      `json:"endTime"`
+	Events    []JournalEvent `json:"events"`
+}
+
+// JournalEvent 对局中的一个事件，Player 为空时表示系统事件
+type JournalEvent struct {
+	Time   time.Time `json:"time"`
+	Player string    `json:"player,omitempty"`
+	Action string    `json:"action"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// 对局中的事件类型
+const (
+	ActionDeal       = "deal"
+	ActionRob        = "rob"
+	ActionPlay       = "play"
+	ActionPass       = "pass"
+	ActionBet        = "bet"
+	ActionVote       = "vote"
+	ActionSkill      = "skill"
+	ActionSettlement = "settlement"
+	ActionSubstitute = "substitute"
+	ActionDraw       = "draw"
+	ActionChallenge  = "challenge"
+	ActionUno        = "uno"
+	ActionDeclare    = "declare"
+)
+
+func (e JournalEvent) String() string {
+	buf := fmt.Sprintf("[%s] ", e.Time.Format("15:04:05"))
+	if e.Player != "" {
+		buf += e.Player + " "
+	}
+	buf += e.Action
+	if e.Detail != "" {
+		buf += ": " + e.Detail
+	}
+	return buf
+}
+
+func journalKey(id int64) string {
+	// 补零保证按字典序遍历时与 ID 顺序一致
+	return fmt.Sprintf("%012d", id)
+}
+
+// restoreGameIds 根据已保存的对局恢复 ID 分配器和最近的对局列表
+func restoreGameIds() {
+	recentJournals.Lock()
+	recentJournals.ids = nil
+	recentJournals.Unlock()
+	err := store.Foreach(bucketGames, func(key string, value []byte) error {
+		id, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			return nil
+		}
+		indexJournal(id)
+		for {
+			last := atomic.LoadInt64(&gameIds)
+			if last >= id || atomic.CompareAndSwapInt64(&gameIds, last, id) {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// StartJournal 开始记录房间中的新一局，需要在初始化游戏之前调用
+func StartJournal(room *Room) {
+	players := make([]string, 0)
+	for playerId := range RoomPlayers(room.ID) {
+		if player := GetPlayer(playerId); player != nil {
+			players = append(players, player.Name)
+		}
+	}
+	room.Journal = &Journal{
+		ID:        atomic.AddInt64(&gameIds, 1),
+		RoomID:    room.ID,
+		Type:      room.Type,
+		Players:   players,
+		StartTime: time.Now(),
+		Events:    make([]JournalEvent, 0),
+	}
+}
+
+// Record 记录房间当前对局中的事件，playerId 为 0 时表示系统事件。需要在对局的协程中调用，同时更新观众看到的画面
+func Record(room *Room, playerId int64, action, detail string) {
+	if room == nil {
+		return
+	}
+	Snapshot(room)
+	journal := room.Journal
+	if journal == nil {
+		return
+	}
+	name := ""
+	if playerId != 0 {
+		if player := GetPlayer(playerId); player != nil {
+			name = player.Name
+		}
+	}
+	journal.Lock()
+	defer journal.Unlock()
+	journal.Events = append(journal.Events, JournalEvent{
+		Time:   time.Now(),
+		Player: name,
+		Action: action,
+		Detail: detail,
+	})
+}
+
+// FinishJournal 结束房间当前对局的记录并写入存储
+func FinishJournal(room *Room) {
+	if room == nil {
+		return
+	}
+	journal := room.Journal
+	if journal == nil {
+		return
+	}
+	room.Journal = nil
+	journal.Lock()
+	defer journal.Unlock()
+	journal.EndTime = time.Now()
+	metrics.GamesFinished.Inc(consts.GameTypes[journal.Type])
+	metrics.GameDuration.Observe(journal.EndTime.Sub(journal.StartTime).Seconds(), consts.GameTypes[journal.Type])
+	if err := store.Put(bucketGames, journalKey(journal.ID), json.Marshal(journal)); err != nil {
+		log.Error(err)
+		return
+	}
+	indexJournal(journal.ID)
+}
+
+// GetJournal 读取已结束的对局记录
+func GetJournal(id int64) *Journal {
+	data, ok, err := store.Get(bucketGames, journalKey(id))
+	if err != nil {
+		log.Error(err)
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+	journal := &Journal{}
+	if err = json.Unmarshal(data, journal); err != nil {
+		log.Error(err)
+		return nil
+	}
+	return journal
+}
+
+// RecentJournals 最近结束的 limit 局对局记录，新的在前，最多 consts.ReplayListSize 局
+func RecentJournals(limit int) []*Journal {
+	recentJournals.Lock()
+	ids := recentJournals.ids[max(len(recentJournals.ids)-limit, 0):]
+	recentJournals.Unlock()
+	journals := make([]*Journal, 0, len(ids))
+	for i := len(ids) - 1; i >= 0; i-- {
+		if journal := GetJournal(ids[i]); journal != nil {
+			journals = append(journals, journal)
+		}
+	}
+	return journals
+}
@@ -23,5 +23,18 @@ type Liar struct {
 	AllowJokers  bool                   `json:"allowJokers"`
 }
 
+func (l *Liar) Stop() {
+	if l != nil {
+		for _, state := range l.States {
+			wake(state)
+		}
+	}
+}
+
 func (l *Liar) Clean() {
+	if l != nil {
+		for _, state := range l.States {
+			close(state)
+		}
+	}
 }
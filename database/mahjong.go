@@ -3,24 +3,342 @@ package database
 import (
 	"bytes"
 	"fmt"
+	"math/rand"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/feel-easy/mahjong/card"
 	"github.com/feel-easy/mahjong/consts"
 	"github.com/feel-easy/mahjong/event"
 	"github.com/feel-easy/mahjong/game"
 	"github.com/feel-easy/mahjong/tile"
+	"github.com/feel-easy/mahjong/win"
 	"github.com/ratel-online/core/log"
 	rconsts "github.com/ratel-online/server/consts"
 )
 
 type Mahjong struct {
+	sync.Mutex
 	Room      *Room            `json:"room"`
 	PlayerIDs []int            `json:"playerIds"`
-	States    map[int]chan int `json:"states"`
+	Seats     []*MahjongPlayer `json:"seats"`
+	States    map[int]chan int `json:"states"` // 按座位区分
 	Game      *game.Game       `json:"game"`
+	Variant   int              `json:"variant"`  // 地方玩法
+	Wall      []int            `json:"wall"`     // 按玩法生成的牌墙
+	Flowers   map[int][]int    `json:"flowers"`  // 按座位区分，补花时放到一边的花牌
+	Missing   map[int]int      `json:"missing"`  // 按座位区分，四川麻将定缺的花色
+	Finished  []int            `json:"finished"` // 已经胡牌的座位，按胡牌的先后
+	Turns     map[int]int      `json:"-"`        // 按座位区分，正在处理的回合信号，玩家中途离开时转给接替的玩家
+	Discarded bool             `json:"-"`        // 桌面上的牌是刚打出的，其他玩家还可以吃、碰、杠、胡
+}
+
+// NewMahjongWall 按玩法生成洗好的牌墙：四川麻将只有万、条、饼，广东麻将另有八张花牌
+func NewMahjongWall(variant int) []int {
+	wall := make([]int, 0, 144)
+	for i := 0; i < 4; i++ {
+		for _, suit := range []int{tile.WAN, tile.TIAO, tile.BING} {
+			for j := 1; j <= 9; j++ {
+				wall = append(wall, suit*10+j)
+			}
+		}
+		if variant == rconsts.MahjongVariantSichuan {
+			continue
+		}
+		for j := 1; j <= 4; j++ {
+			wall = append(wall, tile.FENG*10+j)
+		}
+		for j := 1; j <= 3; j++ {
+			wall = append(wall, tile.DRAGON*10+j)
+		}
+	}
+	if variant == rconsts.MahjongVariantGuangdong {
+		for j := 1; j <= 4; j++ {
+			wall = append(wall, tile.SEASON*10+j, tile.HUA*10+j)
+		}
+	}
+	rand.Shuffle(len(wall), func(i, j int) { wall[i], wall[j] = wall[j], wall[i] })
+	return wall
+}
+
+// Deal 每位玩家从牌墙摸 13 张起手牌
+func (game *Mahjong) Deal() {
+	for _, p := range game.Seats {
+		game.Draw(p.Seat, 13)
+	}
+}
+
+// Draw 座位上的玩家从牌墙摸 n 张牌，摸到的花牌放到一边，再从牌墙末尾补牌
+func (game *Mahjong) Draw(seat, n int) {
+	p := game.Game.Players().GetPlayerController(seat)
+	for i := 0; i < n && len(game.Wall) > 0; i++ {
+		t := game.Wall[0]
+		game.Wall = game.Wall[1:]
+		for t/10 >= tile.SEASON {
+			game.Flowers[seat] = append(game.Flowers[seat], t)
+			Broadcast(game.Room.ID, fmt.Sprintf("%s draws flower %s\n", playerName(game.PlayerOf(seat)), tile.Tile(t)))
+			if len(game.Wall) == 0 {
+				return
+			}
+			t = game.Wall[len(game.Wall)-1]
+			game.Wall = game.Wall[:len(game.Wall)-1]
+		}
+		p.AddTiles([]int{t})
+	}
+	game.Discarded = false
+}
+
+// NoTiles 牌墙已经摸完
+func (game *Mahjong) NoTiles() bool {
+	return len(game.Wall) == 0
+}
+
+// Finish 座位上的玩家胡牌，血战到底时不再参与这一局
+func (game *Mahjong) Finish(seat int) {
+	game.Finished = append(game.Finished, seat)
+}
+
+// Active 座位上的玩家还没有胡牌
+func (game *Mahjong) Active(seat int) bool {
+	return !slices.Contains(game.Finished, seat)
+}
+
+// Over 这一局是否结束：四川麻将血战到底，只剩一位玩家没有胡牌时结束，其他玩法一人胡牌即结束
+func (game *Mahjong) Over() bool {
+	if game.Variant == rconsts.MahjongVariantSichuan {
+		return len(game.Finished) >= len(game.Seats)-1
+	}
+	return len(game.Finished) > 0
+}
+
+// Winners 胡牌的玩家
+func (game *Mahjong) Winners() []int64 {
+	winners := make([]int64, 0, len(game.Finished))
+	for _, seat := range game.Finished {
+		winners = append(winners, game.PlayerOf(seat))
+	}
+	return winners
+}
+
+// Next 轮到下一位还没有胡牌的玩家，返回该玩家的座位
+func (game *Mahjong) Next() int {
+	for {
+		p := game.Game.Next()
+		if game.Active(p.ID()) {
+			return p.ID()
+		}
+	}
+}
+
+// MissingTiles 手牌中定缺花色的牌，没有定缺时返回空
+func (game *Mahjong) MissingTiles(seat int, tiles []int) []int {
+	game.Lock()
+	suit, ok := game.Missing[seat]
+	game.Unlock()
+	missing := make([]int, 0)
+	if !ok {
+		return missing
+	}
+	for _, t := range tiles {
+		if t/10 == suit {
+			missing = append(missing, t)
+		}
+	}
+	return missing
+}
+
+// claimable 按玩法判断座位上的玩家能否吃、碰、杠打出的牌：四川麻将不能吃，也不能碰、杠定缺的花色
+func (game *Mahjong) claimable(seat, op, t int) bool {
+	if !game.Active(seat) {
+		return false
+	}
+	if game.Variant == rconsts.MahjongVariantSichuan {
+		return op != consts.CHI && len(game.MissingTiles(seat, []int{t})) == 0
+	}
+	return true
+}
+
+// CanWin 按玩法判断座位上的玩家能否胡牌，hand 为手中的暗牌（包括胡的那张）：
+// 四川麻将手中还有定缺的花色时不能胡，广东麻将番数不够时不能胡
+func (game *Mahjong) CanWin(seat int, hand []int, selfDrawn bool) bool {
+	p := game.Game.Players().GetPlayerController(seat)
+	if !game.Active(seat) || !win.CanWin(hand, p.GetShowCardTiles()) {
+		return false
+	}
+	switch game.Variant {
+	case rconsts.MahjongVariantSichuan:
+		return len(game.MissingTiles(seat, hand)) == 0
+	case rconsts.MahjongVariantGuangdong:
+		return MahjongFanTotal(game.Fans(seat, hand, selfDrawn)) >= rconsts.MahjongMinFan
+	}
+	return true
+}
+
+// Fans 座位上的玩家胡牌的番种，广东麻将每张花牌另加 1 番
+func (game *Mahjong) Fans(seat int, hand []int, selfDrawn bool) []MahjongFan {
+	p := game.Game.Players().GetPlayerController(seat)
+	fans := MahjongFans(hand, p.GetShowCard(), selfDrawn)
+	if n := len(game.Flowers[seat]); n > 0 {
+		fans = append(fans, MahjongFan{Name: "花牌", Fan: n})
+	}
+	return fans
+}
+
+// ExtractState 牌局状态，按玩法过滤其中的吃、碰、杠和胡。桌面上的牌已经被摸牌或吃、碰、杠跳过时不能再要
+func (mj *Mahjong) ExtractState(seat int) game.State {
+	state := mj.Game.ExtractState(mj.Game.Players().GetPlayerController(seat))
+	privileges := map[int][]int{}
+	canWin := state.CanWin[:0:0]
+	if mj.Discarded {
+		for id, pvs := range state.SpecialPrivileges {
+			for _, pv := range pvs {
+				if mj.claimable(id, pv, state.LastPlayedTile) {
+					privileges[id] = append(privileges[id], pv)
+				}
+			}
+		}
+		for _, p := range state.CanWin {
+			if mj.CanWin(p.ID(), append(p.Hand(), state.LastPlayedTile), false) {
+				canWin = append(canWin, p)
+			}
+		}
+	}
+	state.SpecialPrivileges = privileges
+	state.CanWin = canWin
+	return state
+}
+
+// Declare 四川麻将开局时玩家选择定缺的花色，完成最后一个定缺的玩家返回 true。
+// 座位已经定缺时（例如中途接替座位的玩家）直接返回 false，定缺的花色在对局中不会改变
+func (game *Mahjong) Declare(player *Player) (bool, error) {
+	seat := game.Seat(player.ID)
+	game.Lock()
+	_, declared := game.Missing[seat]
+	game.Unlock()
+	if declared {
+		return false, nil
+	}
+	hand := game.Game.Players().GetPlayerController(seat).Hand()
+	counts := map[int]int{}
+	for _, t := range hand {
+		counts[t/10]++
+	}
+	suits := []int{tile.WAN, tile.TIAO, tile.BING}
+	// 超时时定缺手中最少的花色
+	fewest := slices.MinFunc(suits, func(a, b int) int { return counts[a] - counts[b] })
+	options := map[string]int{}
+	buf := bytes.Buffer{}
+	buf.WriteString("Declare the suit you will give up (定缺):\n")
+	for i, suit := range suits {
+		label := strconv.Itoa(i + 1)
+		options[label] = suit
+		buf.WriteString(fmt.Sprintf("%s. %s (%d)\n", circled(i+1), mahjongSuitNames[suit], counts[suit]))
+	}
+	loopCount := 0
+	for {
+		loopCount++
+		if loopCount%100 == 0 {
+			log.Infof("[Mahjong.Declare] Player %d loop count: %d\n", player.ID, loopCount)
+		}
+		_ = player.WriteString(buf.String())
+		selectedLabel, err := player.AskForString(rconsts.PlayMahjongTimeout)
+		if err != nil {
+			switch err {
+			case rconsts.ErrorsExist:
+				_ = player.WriteString("Don't quit a good game！\n")
+				continue
+			case rconsts.ErrorsTimeout:
+				selectedLabel = strconv.Itoa(slices.Index(suits, fewest) + 1)
+			default:
+				return false, err
+			}
+		}
+		suit, found := options[strings.TrimSpace(selectedLabel)]
+		if !found {
+			BroadcastChat(player, fmt.Sprintf("%s say: %s\n", player.Name, selectedLabel))
+			continue
+		}
+		game.Lock()
+		if _, declared = game.Missing[seat]; declared {
+			game.Unlock()
+			return false, nil
+		}
+		game.Missing[seat] = suit
+		completed := len(game.Missing) == len(game.Seats)
+		game.Unlock()
+		Broadcast(player.RoomID, fmt.Sprintf("%s gives up %s\n", player.Name, mahjongSuitNames[suit]))
+		Record(game.Room, player.ID, ActionDeclare, mahjongSuitNames[suit])
+		return completed, nil
+	}
+}
+
+var mahjongSuitNames = map[int]string{
+	tile.WAN:  "万",
+	tile.TIAO: "条",
+	tile.BING: "饼",
+}
+
+// mahjongGame 玩家所在房间的麻将牌局
+func (mp *MahjongPlayer) mahjongGame() *Mahjong {
+	p := getPlayer(mp.ID)
+	if p == nil {
+		return nil
+	}
+	room := getRoom(p.RoomID)
+	if room == nil {
+		return nil
+	}
+	game, _ := room.Game.(*Mahjong)
+	return game
+}
+
+// Seat 玩家所在的座位，牌局中按座位区分玩家，换人后座位不变
+func (game *Mahjong) Seat(playerId int64) int {
+	for _, p := range game.Seats {
+		if p.ID == playerId {
+			return p.Seat
+		}
+	}
+	return int(playerId)
+}
+
+// PlayerOf 座位上的玩家
+func (game *Mahjong) PlayerOf(seat int) int64 {
+	for _, p := range game.Seats {
+		if p.Seat == seat {
+			return p.ID
+		}
+	}
+	return int64(seat)
+}
+
+// SetTurn 记录座位正在处理的回合信号，处理完成后传 0 清除
+func (game *Mahjong) SetTurn(seat, state int) {
+	game.Lock()
+	defer game.Unlock()
+	if state == 0 {
+		delete(game.Turns, seat)
+		return
+	}
+	game.Turns[seat] = state
+}
+
+// Turn 座位正在处理的回合信号，没有时返回 0
+func (game *Mahjong) Turn(seat int) int {
+	game.Lock()
+	defer game.Unlock()
+	return game.Turns[seat]
+}
+
+func (game *Mahjong) Stop() {
+	if game != nil {
+		for _, state := range game.States {
+			wake(state)
+		}
+	}
 }
 
 func (game *Mahjong) Clean() {
@@ -46,27 +364,44 @@ func circled(n int) string {
 type MahjongPlayer struct {
 	ID   int64  `json:"id"`
 	Name string `json:"name"`
+	Seat int    `json:"seat"` // 开局时玩家的 ID
 }
 
 func NewPlayer(user *Player) *MahjongPlayer {
 	return &MahjongPlayer{
 		ID:   user.ID,
 		Name: user.Name,
+		Seat: int(user.ID),
 	}
 }
 
 func (p *MahjongPlayer) PlayerID() int {
-	return int(p.ID)
+	return p.Seat
 }
 
 func (p *MahjongPlayer) NickName() string {
 	return p.Name
 }
 
+// MahjongTiles 麻将牌在事件中的表示
+func MahjongTiles(tiles []int) []string {
+	list := make([]string, 0, len(tiles))
+	for _, t := range tiles {
+		list = append(list, tile.Tile(t).String())
+	}
+	return list
+}
+
 func (mp *MahjongPlayer) OnPlayTile(payload event.PlayTilePayload) {
 	p := GetPlayer(mp.ID)
 	p.WriteString(fmt.Sprintf("You play %s ! \n", tile.Tile(payload.Tile)))
-	Broadcast(p.RoomID, fmt.Sprintf("%s PlayTile %s !\n", payload.PlayerName, tile.Tile(payload.Tile)), p.ID)
+	BroadcastEvent(p.RoomID, Event{
+		Code:   rconsts.CodePlayMade,
+		Msg:    fmt.Sprintf("%s PlayTile %s !\n", payload.PlayerName, tile.Tile(payload.Tile)),
+		Player: &EventPlayer{Name: payload.PlayerName},
+		Action: ActionPlay,
+		Cards:  MahjongTiles([]int{payload.Tile}),
+	}, p.ID)
 }
 
 func (mp *MahjongPlayer) Take(tiles []int, gameState game.State) (int, []int, error) {
@@ -79,7 +414,7 @@ func (mp *MahjongPlayer) Take(tiles []int, gameState game.State) (int, []int, er
 	askBuf := bytes.Buffer{}
 	tileOptions := make(map[string]*OP)
 	labelCounter := 1
-	if pvs, ok := gameState.SpecialPrivileges[int(p.ID)]; ok {
+	if pvs, ok := gameState.SpecialPrivileges[mp.Seat]; ok {
 		for _, pv := range pvs {
 			switch pv {
 			case consts.GANG:
@@ -148,18 +483,43 @@ func (mp *MahjongPlayer) Take(tiles []int, gameState game.State) (int, []int, er
 			BroadcastChat(p, fmt.Sprintf("%s say: %s\n", p.Name, selectedLabel))
 			continue
 		}
+		switch selected.operation {
+		case consts.GANG:
+			Record(getRoom(p.RoomID), p.ID, ActionPlay, "杠 "+tile.ToTileString(selected.tiles))
+		case consts.PENG:
+			Record(getRoom(p.RoomID), p.ID, ActionPlay, "碰 "+tile.ToTileString(selected.tiles))
+		case consts.CHI:
+			Record(getRoom(p.RoomID), p.ID, ActionPlay, "吃 "+tile.ToTileString(selected.tiles))
+		}
 		return selected.operation, selected.tiles, nil
 	}
 }
 
 func (mp *MahjongPlayer) Play(tiles []int, gameState game.State) (int, error) {
 	p := GetPlayer(mp.ID)
-	Broadcast(p.RoomID, fmt.Sprintf("It's %s turn! \n", p.Name), p.ID)
+	BroadcastEvent(p.RoomID, Event{
+		Code:    rconsts.CodeTurnStarted,
+		Msg:     fmt.Sprintf("It's %s turn! \n", p.Name),
+		Player:  EventPlayerOf(p.ID),
+		Timeout: Timeout(rconsts.PlayMahjongTimeout),
+	}, p.ID)
 	buf := bytes.Buffer{}
 	buf.WriteString(fmt.Sprintf("It's your turn, %s! \n", p.Name))
 	buf.WriteString(gameState.String())
-	p.WriteString(buf.String())
+	p.WriteEvent(Event{
+		Code:    rconsts.CodeTurnStarted,
+		Msg:     buf.String(),
+		Player:  EventPlayerOf(p.ID),
+		Cards:   MahjongTiles(tiles),
+		Timeout: Timeout(rconsts.PlayMahjongTimeout),
+	})
 	askBuf := bytes.Buffer{}
+	if game := mp.mahjongGame(); game != nil {
+		if missing := game.MissingTiles(mp.Seat, tiles); len(missing) > 0 {
+			askBuf.WriteString("Discard your missing suit first!\n")
+			tiles = missing
+		}
+	}
 	askBuf.WriteString("Select a tile to play:\n")
 	tileOptions := make(map[string]int)
 	sort.Ints(tiles)
@@ -207,3 +567,103 @@ func (mp *MahjongPlayer) Play(tiles []int, gameState game.State) (int, error) {
 		return selectedCard, nil
 	}
 }
+
+// MahjongFan 胡牌时的一项番种
+type MahjongFan struct {
+	Name string `json:"name"`
+	Fan  int    `json:"fan"`
+}
+
+// MahjongFans 计算胡牌的番种，hand 为胡牌时手中的暗牌（包括胡的那张），showCards 为吃、碰、杠的明牌
+func MahjongFans(hand []int, showCards []*game.ShowCard, selfDrawn bool) []MahjongFan {
+	fans := []MahjongFan{{Name: "胡牌", Fan: 1}}
+	counts := map[int]int{}
+	for _, t := range hand {
+		counts[t]++
+	}
+	concealed := true
+	pungs := true
+	for _, sc := range showCards {
+		switch sc.GetOpCode() {
+		case consts.CHI:
+			pungs = false
+			concealed = false
+		case consts.PENG:
+			concealed = false
+		case consts.GANG:
+			// 暗杠的明牌对象为 0，不影响门前清
+			if sc.GetTarget() != 0 {
+				concealed = false
+			}
+		}
+	}
+	sevenPairs := len(showCards) == 0 && len(hand) == 14
+	pairs := 0
+	for _, n := range counts {
+		if n%2 != 0 {
+			sevenPairs = false
+		}
+		switch n {
+		case 2:
+			pairs++
+		case 3:
+		default:
+			pungs = false
+		}
+	}
+	if sevenPairs {
+		fans = append(fans, MahjongFan{Name: "七对", Fan: 4})
+	} else if pungs && pairs == 1 {
+		fans = append(fans, MahjongFan{Name: "碰碰胡", Fan: 2})
+	}
+
+	all := append(append([]int{}, hand...), showTiles(showCards)...)
+	suits := map[int]bool{}
+	honors := false
+	for _, t := range all {
+		if t/10 > tile.BING {
+			honors = true
+		} else {
+			suits[t/10] = true
+		}
+	}
+	if len(suits) == 1 && !honors {
+		fans = append(fans, MahjongFan{Name: "清一色", Fan: 4})
+	} else if len(suits) == 1 {
+		fans = append(fans, MahjongFan{Name: "混一色", Fan: 2})
+	}
+	if concealed && !sevenPairs {
+		fans = append(fans, MahjongFan{Name: "门前清", Fan: 1})
+	}
+	for _, sc := range showCards {
+		if sc.GetOpCode() != consts.GANG {
+			continue
+		}
+		if sc.GetTarget() == 0 {
+			fans = append(fans, MahjongFan{Name: "暗杠", Fan: 2})
+		} else {
+			fans = append(fans, MahjongFan{Name: "明杠", Fan: 1})
+		}
+	}
+	if selfDrawn {
+		fans = append(fans, MahjongFan{Name: "自摸", Fan: 1})
+	}
+	return fans
+}
+
+// MahjongFanTotal 番数合计
+func MahjongFanTotal(fans []MahjongFan) int {
+	total := 0
+	for _, f := range fans {
+		total += f.Fan
+	}
+	return total
+}
+
+func showTiles(showCards []*game.ShowCard) []int {
+	tiles := make([]int, 0, len(showCards)*4)
+	for _, sc := range showCards {
+		tiles = append(tiles, sc.GetTiles()...)
+	}
+	return tiles
+}
@@ -0,0 +1,55 @@
+package database
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/feel-easy/mahjong/consts"
+	"github.com/feel-easy/mahjong/game"
+)
+
+func TestMahjongFans(t *testing.T) {
+	tests := []struct {
+		hand      []int
+		showCards []*game.ShowCard
+		selfDrawn bool
+		want      []string
+		total     int
+	}{
+		// 七对和清一色七对
+		{[]int{1, 1, 2, 2, 3, 3, 11, 11, 12, 12, 21, 21, 31, 31}, nil, false, []string{"胡牌", "七对"}, 5},
+		{[]int{1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7}, nil, false, []string{"胡牌", "七对", "清一色"}, 9},
+		// 碰碰胡和混一色
+		{[]int{1, 1, 1, 12, 12, 12, 23, 23, 23, 31, 31}, []*game.ShowCard{game.NewShowCard(consts.PENG, 2, []int{5, 5, 5}, true, false)}, false, []string{"胡牌", "碰碰胡"}, 3},
+		{[]int{4, 5, 6, 7, 8, 9, 31, 31, 31, 32, 32}, []*game.ShowCard{game.NewShowCard(consts.CHI, 2, []int{1, 2, 3}, true, false)}, false, []string{"胡牌", "混一色"}, 3},
+		// 暗杠不影响门前清，明杠没有门前清
+		{[]int{1, 2, 3, 11, 12, 13, 21, 22, 23, 5, 5}, []*game.ShowCard{game.NewShowCard(consts.GANG, 0, []int{9, 9, 9, 9}, false, false)}, true, []string{"胡牌", "门前清", "暗杠", "自摸"}, 5},
+		{[]int{1, 2, 3, 11, 12, 13, 21, 22, 23, 5, 5}, []*game.ShowCard{game.NewShowCard(consts.GANG, 3, []int{9, 9, 9, 9}, true, false)}, false, []string{"胡牌", "明杠"}, 2},
+	}
+	for _, tt := range tests {
+		fans := MahjongFans(tt.hand, tt.showCards, tt.selfDrawn)
+		names := make([]string, 0, len(fans))
+		for _, f := range fans {
+			names = append(names, f.Name)
+		}
+		if total := MahjongFanTotal(fans); !slices.Equal(names, tt.want) || total != tt.total {
+			t.Errorf("%v: got %v (%d), want %v (%d)", tt.hand, names, total, tt.want, tt.total)
+		}
+	}
+}
+
+func TestMahjongFlowerFans(t *testing.T) {
+	a := &MahjongPlayer{ID: 1, Name: "a", Seat: 1}
+	b := &MahjongPlayer{ID: 2, Name: "b", Seat: 2}
+	mj := &Mahjong{
+		Game:    game.New([]game.Player{a, b}),
+		Flowers: map[int][]int{1: {51, 61}},
+	}
+	fans := mj.Fans(1, []int{1, 2, 3, 11, 12, 13, 21, 22, 23, 5, 5, 31, 31, 31}, false)
+	if last := fans[len(fans)-1]; last.Name != "花牌" || last.Fan != 2 {
+		t.Errorf("unexpected fans %v", fans)
+	}
+	if fans = mj.Fans(2, []int{1, 2, 3, 11, 12, 13, 21, 22, 23, 5, 5, 31, 31, 31}, false); slices.ContainsFunc(fans, func(f MahjongFan) bool { return f.Name == "花牌" }) {
+		t.Errorf("seat without flowers got %v", fans)
+	}
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ratel-online/core/log"
+	"github.com/ratel-online/server/consts"
+)
+
+// matchKey 匹配队列按游戏类型区分，德州扑克再按盲注级别区分
+type matchKey struct {
+	Type  int
+	Stake int
+}
+
+var (
+	matchLock   sync.Mutex
+	matchQueues = map[matchKey][]int64{}
+)
+
+// Enqueue 加入快速匹配队列，人数凑齐时创建房间并让队列中的玩家入座，返回创建的房间，否则返回 nil
+func Enqueue(player *Player, gameType, stake int) (*Room, error) {
+	need, ok := consts.MatchPlayers[gameType]
+	if !ok {
+		return nil, consts.ErrorsGameTypeInvalid
+	}
+	if gameType == consts.GameTypeTexas && (stake < 0 || stake >= len(consts.TexasStakes)) {
+		return nil, consts.ErrorsStakeInvalid
+	}
+	key := matchKey{Type: gameType, Stake: stake}
+
+	matchLock.Lock()
+	defer matchLock.Unlock()
+	dequeue(player.ID)
+	// 断线或已经进入房间的玩家不再参与匹配
+	queue := make([]int64, 0, len(matchQueues[key])+1)
+	for _, id := range matchQueues[key] {
+		if p := getPlayer(id); p != nil && p.IsOnline() && p.RoomID == 0 {
+			queue = append(queue, id)
+		}
+	}
+	queue = append(queue, player.ID)
+	if len(queue) < need {
+		matchQueues[key] = queue
+		for _, id := range queue {
+			_ = getPlayer(id).WriteString(fmt.Sprintf("Waiting for %s players, %d/%d in queue, input 'e' to cancel.\n", consts.GameTypes[gameType], len(queue), need))
+		}
+		return nil, nil
+	}
+	matchQueues[key] = queue[need:]
+
+	room := CreateRoom(queue[0], gameType)
+	if gameType == consts.GameTypeTexas {
+		room.SmallBlind, room.BigBlind = consts.TexasStakes[stake][0], consts.TexasStakes[stake][1]
+	}
+	if room.MaxPlayers < need {
+		room.MaxPlayers = need
+	}
+	saveRoom(room)
+	for _, id := range queue[:need] {
+		if err := JoinRoom(room.ID, id); err != nil {
+			log.Error(err)
+		}
+	}
+	log.Infof("match found, room %d, type %s, players %v\n", room.ID, consts.GameTypes[gameType], queue[:need])
+	return room, nil
+}
+
+// Dequeue 退出快速匹配队列，玩家不在队列中（如已经匹配成功）时返回 false
+func Dequeue(playerId int64) bool {
+	matchLock.Lock()
+	defer matchLock.Unlock()
+	return dequeue(playerId)
+}
+
+func dequeue(playerId int64) bool {
+	for key, queue := range matchQueues {
+		for i, id := range queue {
+			if id == playerId {
+				matchQueues[key] = append(queue[:i:i], queue[i+1:]...)
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,19 @@
+package database
+
+import (
+	"github.com/awesome-cap/hashmap"
+	"github.com/ratel-online/server/consts"
+	"github.com/ratel-online/server/metrics"
+)
+
+func init() {
+	metrics.NewGauge("ratel_connected_players", "Number of connected players.", func(set func(v float64, labelValues ...string)) {
+		set(float64(connPlayers.Size()))
+	})
+	metrics.NewGauge("ratel_rooms", "Number of rooms by game type and state.", func(set func(v float64, labelValues ...string)) {
+		rooms.Foreach(func(e *hashmap.Entry) {
+			room := e.Value().(*Room)
+			set(1, consts.GameTypes[room.Type], consts.RoomStates[room.State])
+		})
+	}, "type", "state")
+}
@@ -5,8 +5,10 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	constx "github.com/ratel-online/core/consts"
 	"github.com/ratel-online/core/log"
 	"github.com/ratel-online/core/model"
 	"github.com/ratel-online/core/network"
@@ -15,6 +17,7 @@ import (
 	"github.com/ratel-online/core/util/json"
 	"github.com/ratel-online/core/util/poker"
 	"github.com/ratel-online/server/consts"
+	"github.com/ratel-online/server/metrics"
 )
 
 const initialRune = 'A'
@@ -49,33 +52,127 @@ type Player struct {
 	Amount uint   `json:"amount"`
 	RoomID int64  `json:"roomId"`
 	Role   Role   `json:"role"`
-
-	conn   *network.Conn
-	data   chan *protocol.Packet
-	read   bool
-	state  consts.StateID
-	online bool
+	Guest  bool   `json:"guest"`
+
+	conn        *network.Conn
+	data        chan *protocol.Packet
+	read        bool
+	state       consts.StateID
+	online      bool
+	closed      bool
+	token       string
+	key         string
+	robot       bool
+	swap        bool // 申请在下一个回合把座位交给替补
+	kicked      bool // 被管理员踢出，断开连接后立即注销会话
+	offlineTime time.Time
+	lock        sync.Mutex
+}
+
+func (p *Player) write(packet protocol.Packet) error {
+	// 断线期间的消息直接丢弃，避免写错误中断玩家的状态机；机器人没有连接，消息同样丢弃
+	// 恢复会话时连接会被替换，在锁内取出当前连接再写入
+	p.lock.Lock()
+	online, conn := p.online, p.conn
+	p.lock.Unlock()
+	if !online || p.robot {
+		return nil
+	}
+	return conn.Write(packet)
 }
 
 func (p *Player) Write(bytes []byte) error {
-	return p.conn.Write(protocol.Packet{
+	return p.write(protocol.Packet{
 		Body: bytes,
 	})
 }
 
 func (p *Player) IsOnline() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
 	return p.online
 }
 
+// IsRobot 是否是机器人玩家
+func (p *Player) IsRobot() bool {
+	return p.robot
+}
+
+// Answer 为机器人提交输入，下一次 AskFor 系列方法将读到该输入
+func (p *Player) Answer(ans string) {
+	select {
+	case p.data <- &protocol.Packet{Body: []byte(ans)}:
+	default:
+	}
+}
+
+// Token 返回玩家的会话令牌，断线后凭此令牌在宽限期内恢复会话
+func (p *Player) Token() string {
+	return p.token
+}
+
+// Disconnected 连接断开时调用，保留玩家会话直到宽限期结束
+func (p *Player) Disconnected(conn *network.Conn) {
+	p.lock.Lock()
+	if p.conn != conn || !p.online {
+		p.lock.Unlock()
+		return
+	}
+	p.online = false
+	p.offlineTime = time.Now()
+	offlineTime := p.offlineTime
+	kicked := p.kicked
+	connPlayers.Del(conn.ID())
+	p.lock.Unlock()
+
+	_ = conn.Close()
+	if room := getRoom(p.RoomID); room != nil {
+		msg := fmt.Sprintf("%s lost connection! \n", p.Name)
+		broadcastObject(room, model.RoomEvent{
+			Data:   model.Data{Code: constx.CodeRoomEventOffline, Msg: msg},
+			Room:   room.Model(),
+			Player: p.Model(),
+		}, msg)
+	}
+	if kicked {
+		p.Offline()
+		return
+	}
+	time.AfterFunc(consts.SessionGracePeriod, func() {
+		p.lock.Lock()
+		expired := !p.online && p.offlineTime.Equal(offlineTime)
+		p.lock.Unlock()
+		if expired {
+			log.Infof("player %s session expired.\n", p)
+			p.Offline()
+		}
+	})
+}
+
+// Offline 彻底注销玩家会话，释放玩家占用的资源
 func (p *Player) Offline() {
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		return
+	}
+	p.closed = true
 	p.online = false
-	_ = p.conn.Close()
+	conn := p.conn
+	p.lock.Unlock()
+
+	sessions.Del(p.token)
+	connPlayers.Del(conn.ID())
+	_ = conn.Close()
 	close(p.data)
+	SavePlayer(p)
+	if p.Guest {
+		releaseGuest(p.Name)
+	}
 	room := getRoom(p.RoomID)
 	if room != nil {
 		room.Lock()
 		defer room.Unlock()
-		broadcast(room, fmt.Sprintf("%s lost connection! \n", p.Name))
 		if room.State == consts.RoomStateWaiting {
 			leaveRoom(room, p)
 		}
@@ -83,18 +180,43 @@ func (p *Player) Offline() {
 	}
 }
 
+// rebind 将会话绑定到新的连接上并切换协议模式，原连接会被关闭
+func (p *Player) rebind(conn *network.Conn, mode int) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed {
+		return false
+	}
+	old := p.conn
+	p.conn = conn
+	p.Mode = mode
+	p.IP = conn.IP()
+	p.online = true
+	p.offlineTime = time.Time{}
+	if old != conn {
+		connPlayers.Del(old.ID())
+		_ = old.Close()
+	}
+	return true
+}
+
 func (p *Player) Listening() error {
+	conn := p.conn
 	loopCount := 0
 	for {
 		loopCount++
 		if loopCount%1000 == 0 {
-			log.Infof("[Player.Listening] Player %d loop count: %d, online: %v\n", p.ID, loopCount, p.online)
+			log.Infof("[Player.Listening] Player %d loop count: %d, online: %v\n", p.ID, loopCount, p.IsOnline())
 		}
-		pack, err := p.conn.Read()
+		pack, err := conn.Read()
 		if err != nil {
 			log.Error(err)
 			return err
 		}
+		// 对局中不轮到自己时也可以申请换人，Uno 中可以随时喊 UNO 或者抓没有喊的玩家
+		if p.swapRequested(pack) || p.unoRequested(pack) {
+			continue
+		}
 		if p.read {
 			p.data <- pack
 		}
@@ -103,13 +225,17 @@ func (p *Player) Listening() error {
 
 // 向客户端发生消息
 func (p *Player) WriteString(data string) error {
-	return p.conn.Write(protocol.Packet{
+	// object 模式下普通文本也以消息对象发送，交互信号保持原样
+	if p.Mode == consts.ModeObject && data != consts.IsStart && data != consts.IsStop {
+		return p.WriteObject(Event{Code: consts.CodeMessage, Msg: data})
+	}
+	return p.write(protocol.Packet{
 		Body: []byte(data),
 	})
 }
 
 func (p *Player) WriteObject(data interface{}) error {
-	return p.conn.Write(protocol.Packet{
+	return p.write(protocol.Packet{
 		Body: json.Marshal(data),
 	})
 }
@@ -118,7 +244,10 @@ func (p *Player) WriteError(err error) error {
 	if err == consts.ErrorsExist {
 		return err
 	}
-	return p.conn.Write(protocol.Packet{
+	if p.Mode == consts.ModeObject {
+		return p.WriteObject(Event{Code: consts.CodeError, Msg: err.Error()})
+	}
+	return p.write(protocol.Packet{
 		Body: []byte(err.Error() + "\n"),
 	})
 }
@@ -126,12 +255,24 @@ func (p *Player) WriteError(err error) error {
 func (p *Player) AskForPacket(timeout ...time.Duration) (*protocol.Packet, error) {
 	p.StartTransaction()
 	defer p.StopTransaction()
-	return p.askForPacket(timeout...)
+	packet, err := p.askForPacket(timeout...)
+	if err == consts.ErrorsTimeout && !p.robot {
+		metrics.TurnTimeouts.Inc()
+	}
+	return packet, err
 }
 
 func (p *Player) askForPacket(timeout ...time.Duration) (*protocol.Packet, error) {
 	var packet *protocol.Packet
-	if len(timeout) > 0 {
+	if p.robot {
+		// 机器人思考片刻后作答，没有提交输入时按超时处理
+		time.Sleep(consts.RobotThinkTime)
+		select {
+		case packet = <-p.data:
+		default:
+			return nil, consts.ErrorsTimeout
+		}
+	} else if len(timeout) > 0 {
 		select {
 		case packet = <-p.data:
 		case <-time.After(timeout[0]):
@@ -206,51 +347,114 @@ func (p *Player) Conn(conn *network.Conn) {
 	p.online = true
 }
 
-func (p Player) Model() model.Player {
+func (p *Player) Model() model.Player {
 	modelPlayer := model.Player{
-		ID:   p.ID,
-		Name: p.Name,
+		ID:    p.ID,
+		Name:  p.Name,
+		Score: int64(p.Amount),
 	}
 	room := getRoom(p.RoomID)
-	if room != nil && room.Game != nil {
-		game := room.Game.(*Game)
-		modelPlayer.Pokers = len(game.Pokers[p.ID])
-		modelPlayer.Group = game.Groups[p.ID]
+	// 只有斗地主类的对局有手牌数量和分组
+	if room != nil {
+		if game, ok := room.Game.(*Game); ok {
+			modelPlayer.Pokers = len(game.Pokers[p.ID])
+			modelPlayer.Group = game.Groups[p.ID]
+		}
 	}
 	return modelPlayer
 }
 
-func (p Player) String() string {
+func (p *Player) String() string {
 	return fmt.Sprintf("%s[%d]", p.Name, p.ID)
 }
 
+// RoomGame 房间中的对局。Stop 唤醒阻塞在对局通道上的协程，让它们发现房间已解散后退出；
+// Clean 关闭对局的通道，只能在所有对局协程退出后调用
 type RoomGame interface {
+	Stop()
 	Clean()
 }
 
 type Room struct {
 	sync.Mutex
 
-	ID                  int64     `json:"id"`
-	Type                int       `json:"type"`
-	Game                RoomGame  `json:"gameId"`
-	State               int       `json:"state"`
-	Players             int       `json:"players"`
-	Banker              int       `json:"banker"`
-	Robots              int       `json:"robots"`
-	Creator             int64     `json:"creator"`
-	ActiveTime          time.Time `json:"activeTime"`
-	MaxPlayers          int       `json:"maxPlayers"`
-	Password            string    `json:"password"`
-	EnableChat          bool      `json:"enableChat"`
-	EnableLaiZi         bool      `json:"enableLaiZi"`
-	EnableSkill         bool      `json:"enableSkill"`
-	EnableLandlord      bool      `json:"enableLandlord"`
-	EnableDontShuffle   bool      `json:"enableDontShuffle"`
-	EnableShowIP        bool      `json:"enableShowIP"`
-	EnableJokerAsTarget bool      `json:"enableJokerAsTarget"`
-	UndercoverNum       int       `json:"undercoverNum"`  // 卧底数量
-	BlankWordMode       bool      `json:"blankWordMode"`  // 空白词模式
+	ID                  int64          `json:"id"`
+	Type                int            `json:"type"`
+	Game                RoomGame       `json:"gameId"`
+	Journal             *Journal       `json:"-"` // 当前对局的记录
+	State               int            `json:"state"`
+	Players             int            `json:"players"`
+	Banker              int            `json:"banker"`
+	Robots              int            `json:"robots"`
+	Creator             int64          `json:"creator"`
+	ActiveTime          time.Time      `json:"activeTime"`
+	MaxPlayers          int            `json:"maxPlayers"`
+	Password            string         `json:"password"`
+	EnableChat          bool           `json:"enableChat"`
+	EnableLaiZi         bool           `json:"enableLaiZi"`
+	EnableSkill         bool           `json:"enableSkill"`
+	EnableLandlord      bool           `json:"enableLandlord"`
+	EnableDontShuffle   bool           `json:"enableDontShuffle"`
+	EnableShowIP        bool           `json:"enableShowIP"`
+	EnableJokerAsTarget bool           `json:"enableJokerAsTarget"`
+	UndercoverNum       int            `json:"undercoverNum"` // 卧底数量
+	BlankWordMode       bool           `json:"blankWordMode"` // 空白词模式
+	SmallBlind          uint           `json:"smallBlind"`
+	BigBlind            uint           `json:"bigBlind"`
+	Ante                uint           `json:"ante"`
+	Stack               uint           `json:"stack"`           // 起始筹码，为 0 时使用玩家余额
+	BlindLevelHands     int            `json:"blindLevelHands"` // 每隔多少手升级盲注
+	BlindLevelTime      time.Duration  `json:"blindLevelTime"`  // 每隔多长时间升级盲注
+	Chips               map[int64]uint `json:"-"`               // 锦标赛中带着筹码转入本桌的玩家
+	SeriesBestOf        int            `json:"seriesBestOf"`    // 系列赛 N 局 (N/2+1) 胜
+	SeriesTarget        int            `json:"seriesTarget"`    // 系列赛先赢 N 局
+	Series              *Series        `json:"-"`               // 进行中的系列赛
+	Rematch             map[int64]bool `json:"-"`               // 同意再来一局的玩家
+	EnableSpectatorChat bool           `json:"enableSpectatorChat"`
+	RevealDelay         time.Duration  `json:"revealDelay"`      // 观众延迟看到所有玩家的手牌，为 0 时不公开
+	EnableDrawUntil     bool           `json:"enableDrawUntil"`  // Uno 一直摸到能出的牌
+	EnableStackDraws    bool           `json:"enableStackDraws"` // Uno 叠加 +2/+4
+	UnoPoints           map[int64]int  `json:"-"`                // Uno 每位玩家累计的分数
+	MahjongVariant      int            `json:"mahjongVariant"`   // 麻将的地方玩法
+	EnableTraining      bool           `json:"enableTraining"`   // 德州扑克训练模式
+	BettingLimit        int            `json:"bettingLimit"`     // 德州扑克的下注限制
+	viewLock            sync.Mutex
+	view                *SpectatorView // 观众看到的对局，由对局的协程生成
+	viewGame            RoomGame       // 生成 view 时的对局
+	reveals             []handsSnapshot
+	playing             int32 // 正在对局中的玩家协程数
+}
+
+// Track 玩家协程进入对局时调用，返回的函数在协程离开对局时调用，解散房间时等所有对局协程离开后再关闭通道
+func (r *Room) Track() func() {
+	atomic.AddInt32(&r.playing, 1)
+	return func() {
+		atomic.AddInt32(&r.playing, -1)
+	}
+}
+
+// Closed 房间是否已经解散，对局协程收到信号后发现房间已解散时直接退出
+func (r *Room) Closed() bool {
+	return getRoom(r.ID) != r
+}
+
+// BlindLevel 盲注升级间隔的描述
+func (r *Room) BlindLevel() string {
+	if r.BlindLevelHands > 0 {
+		return fmt.Sprintf("%dh", r.BlindLevelHands)
+	}
+	if r.BlindLevelTime > 0 {
+		return fmt.Sprintf("%dm", int(r.BlindLevelTime.Minutes()))
+	}
+	return "off"
+}
+
+// StartingChips 玩家入座时的筹码，锦标赛换桌的玩家带着原来的筹码
+func (r *Room) StartingChips(playerId int64, stack uint) uint {
+	if chips, ok := r.Chips[playerId]; ok {
+		return chips
+	}
+	return stack
 }
 
 func (r *Room) Model() model.Room {
@@ -289,6 +493,16 @@ type Game struct {
 	PlayTimeOut map[int64]time.Duration `json:"playTimeOut"`
 	Rules       poker.Rules             `json:"rules"`
 	Discards    model.Pokers            `json:"discards"`
+	Plays       map[int64]int           `json:"plays"` // 每位玩家出牌的次数，用于判断春天和反春
+	Bombs       map[int64]int           `json:"bombs"` // 每位玩家打出的炸弹和王炸数量
+}
+
+func (game *Game) Stop() {
+	if game != nil {
+		for _, state := range game.States {
+			wake(state)
+		}
+	}
 }
 
 func (game *Game) Clean() {
@@ -313,6 +527,15 @@ func (g Game) PrevPlayer(curr int64) int64 {
 	return g.Players[(idx+len(g.Players))%len(g.Players)]
 }
 
+// BombCount 本局打出的炸弹总数
+func (g Game) BombCount() int {
+	count := 0
+	for _, n := range g.Bombs {
+		count += n
+	}
+	return count
+}
+
 func (g Game) IsTeammate(player1, player2 int64) bool {
 	return g.Groups[player1] == g.Groups[player2]
 }
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/ratel-online/core/protocol"
+	"github.com/ratel-online/server/consts"
+)
+
+// AddRobot 向等待中的房间添加一个机器人玩家，机器人的状态机由调用方启动
+func AddRobot(roomId int64) (*Player, error) {
+	room := getRoom(roomId)
+	if room == nil {
+		return nil, consts.ErrorsRoomInvalid
+	}
+	room.Lock()
+	defer room.Unlock()
+
+	if !robotSupported(room.Type) {
+		return nil, consts.ErrorsRobotUnsupported
+	}
+	if room.State == consts.RoomStateRunning {
+		return nil, consts.ErrorsJoinFailForRoomRunning
+	}
+	if room.Players >= room.MaxPlayers {
+		return nil, consts.ErrorsRoomPlayersIsFull
+	}
+	robot := newRobot(room)
+	getRoomPlayers(room.ID)[robot.ID] = true
+	room.Players++
+	return robot, nil
+}
+
+// robotSupported 麻将和谁是卧底没有机器人
+func robotSupported(gameType int) bool {
+	switch gameType {
+	case consts.GameTypeMahjong, consts.GameTypeUndercover:
+		return false
+	}
+	return true
+}
+
+// newRobot 创建属于房间的机器人，由调用方让机器人入座
+func newRobot(room *Room) *Player {
+	id := atomic.AddInt64(&playerIds, 1)
+	robot := &Player{
+		ID:     id,
+		Name:   fmt.Sprintf("Robot-%d", id),
+		Amount: 2000,
+		RoomID: room.ID,
+		Role:   RolePlayer,
+		data:   make(chan *protocol.Packet, 8),
+		online: true,
+		robot:  true,
+	}
+	robot.State(consts.StateWaiting)
+	players.Set(robot.ID, robot)
+	room.Robots++
+	room.ActiveTime = time.Now()
+	return robot
+}
+
+// RemoveRobot 移除房间中的一个机器人，机器人的状态机发现自己不在房间后自行退出
+func RemoveRobot(roomId int64) (*Player, error) {
+	room := getRoom(roomId)
+	if room == nil {
+		return nil, consts.ErrorsRoomInvalid
+	}
+	room.Lock()
+	defer room.Unlock()
+
+	if room.State == consts.RoomStateRunning {
+		return nil, consts.ErrorsJoinFailForRoomRunning
+	}
+	for id := range getRoomPlayers(room.ID) {
+		if robot := getPlayer(id); robot != nil && robot.robot {
+			leaveRoom(room, robot)
+			return robot, nil
+		}
+	}
+	return nil, consts.ErrorsRobotNotFound
+}
+
+// hasHumans 房间中是否还有真人玩家或观众
+func hasHumans(room *Room) bool {
+	for id := range getRoomPlayers(room.ID) {
+		if p := getPlayer(id); p != nil && !p.robot {
+			return true
+		}
+	}
+	return len(getRoomSpectators(room.ID)) > 0
+}
@@ -0,0 +1,186 @@
+package database
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	modelx "github.com/ratel-online/core/model"
+	"github.com/ratel-online/server/consts"
+)
+
+// Series 房间中的系列赛，记录同一房间连续多局的胜场
+type Series struct {
+	Games  int              `json:"games"`
+	Scores map[int64]int    `json:"scores"`
+	Names  map[int64]string `json:"names"`
+}
+
+// SeriesView 系列赛的比分
+type SeriesView struct {
+	modelx.Data
+	Mode     string  `json:"mode"`
+	Games    int     `json:"games"`
+	Scores   []Score `json:"scores"`
+	Finished bool    `json:"finished"`
+	Winners  []int64 `json:"winners,omitempty"`
+}
+
+type Score struct {
+	PlayerID int64  `json:"playerId"`
+	Name     string `json:"name"`
+	Wins     int    `json:"wins"`
+}
+
+// SeriesMode 系列赛模式的描述，bo3 表示三局两胜，ft5 表示先赢 5 局
+func (r *Room) SeriesMode() string {
+	if r.SeriesBestOf > 0 {
+		return fmt.Sprintf("bo%d", r.SeriesBestOf)
+	}
+	if r.SeriesTarget > 0 {
+		return fmt.Sprintf("ft%d", r.SeriesTarget)
+	}
+	return "off"
+}
+
+func setSeriesMode(r *Room, v string) {
+	r.SeriesBestOf = 0
+	r.SeriesTarget = 0
+	r.Series = nil
+	switch {
+	case strings.HasPrefix(v, "bo"):
+		r.SeriesBestOf, _ = strconv.Atoi(strings.TrimPrefix(v, "bo"))
+	case strings.HasPrefix(v, "ft"):
+		r.SeriesTarget, _ = strconv.Atoi(strings.TrimPrefix(v, "ft"))
+	default:
+		r.SeriesBestOf, _ = strconv.Atoi(v)
+	}
+	if r.SeriesBestOf < 0 || r.SeriesTarget < 0 {
+		r.SeriesBestOf = 0
+		r.SeriesTarget = 0
+	}
+}
+
+// leaders 胜场最多的玩家
+func (s *Series) leaders() ([]int64, int) {
+	best := 0
+	leaders := make([]int64, 0)
+	for id, wins := range s.Scores {
+		if wins > best {
+			best = wins
+			leaders = leaders[:0]
+		}
+		if wins == best && wins > 0 {
+			leaders = append(leaders, id)
+		}
+	}
+	slices.Sort(leaders)
+	return leaders, best
+}
+
+// finished 三局两胜在有人赢下过半的局数或打满局数时结束，先赢 N 局在有人达到 N 局时结束
+func (s *Series) finished(room *Room) bool {
+	_, best := s.leaders()
+	if room.SeriesBestOf > 0 {
+		return best > room.SeriesBestOf/2 || s.Games >= room.SeriesBestOf
+	}
+	return best >= room.SeriesTarget
+}
+
+func (s *Series) view(room *Room) SeriesView {
+	view := SeriesView{
+		Mode:   room.SeriesMode(),
+		Games:  s.Games,
+		Scores: make([]Score, 0, len(s.Scores)),
+	}
+	for id, wins := range s.Scores {
+		view.Scores = append(view.Scores, Score{PlayerID: id, Name: s.Names[id], Wins: wins})
+	}
+	sort.Slice(view.Scores, func(i, j int) bool {
+		if view.Scores[i].Wins != view.Scores[j].Wins {
+			return view.Scores[i].Wins > view.Scores[j].Wins
+		}
+		return view.Scores[i].PlayerID < view.Scores[j].PlayerID
+	})
+	return view
+}
+
+func (v SeriesView) String() string {
+	buf := bytes.Buffer{}
+	buf.WriteString(fmt.Sprintf("Series %s, game %d\n", v.Mode, v.Games))
+	for _, score := range v.Scores {
+		buf.WriteString(fmt.Sprintf("%-20s%d\n", score.Name, score.Wins))
+	}
+	return buf.String()
+}
+
+// RoomSeries 房间当前系列赛的比分，没有开启系列赛时返回 nil
+func RoomSeries(room *Room) *SeriesView {
+	if room.Series == nil {
+		return nil
+	}
+	view := room.Series.view(room)
+	return &view
+}
+
+// settleSeries 记录一局的胜者并广播比分，系列赛结束后下一局开始新的系列赛
+func settleSeries(room *Room, winners []int64) {
+	if room.SeriesBestOf == 0 && room.SeriesTarget == 0 {
+		return
+	}
+	if room.Series == nil {
+		room.Series = &Series{Scores: map[int64]int{}, Names: map[int64]string{}}
+	}
+	s := room.Series
+	s.Games++
+	for id := range getRoomPlayers(room.ID) {
+		if p := getPlayer(id); p != nil {
+			s.Names[id] = p.Name
+			s.Scores[id] += 0
+		}
+	}
+	for _, id := range winners {
+		s.Scores[id]++
+	}
+	view := s.view(room)
+	msg := view.String()
+	if s.finished(room) {
+		view.Finished = true
+		view.Winners, _ = s.leaders()
+		names := make([]string, 0, len(view.Winners))
+		for _, id := range view.Winners {
+			names = append(names, s.Names[id])
+		}
+		if len(names) > 0 {
+			msg += fmt.Sprintf("Series over, %s won the series!\n", strings.Join(names, ", "))
+		} else {
+			msg += "Series over, no winner.\n"
+		}
+		room.Series = nil
+	}
+	msg += "Input 'rematch' to play again, the game starts when all players accept.\n"
+	view.Data = modelx.Data{Code: consts.CodeSeries, Msg: msg}
+	broadcastObject(room, view, msg)
+}
+
+// Rematch 玩家同意再来一局，返回同意的真人玩家数和真人玩家总数，所有真人玩家都同意时 ready 为 true
+func Rematch(room *Room, playerId int64) (accepted, total int, ready bool) {
+	room.Lock()
+	defer room.Unlock()
+	if room.Rematch == nil {
+		room.Rematch = map[int64]bool{}
+	}
+	room.Rematch[playerId] = true
+	for id := range getRoomPlayers(room.ID) {
+		if p := getPlayer(id); p != nil && !p.robot {
+			total++
+			if room.Rematch[id] {
+				accepted++
+			}
+		}
+	}
+	return accepted, total, accepted == total
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/ratel-online/server/consts"
+)
+
+func TestSetSeriesMode(t *testing.T) {
+	tests := []struct {
+		value  string
+		bestOf int
+		target int
+	}{
+		{"bo3", 3, 0},
+		{"ft5", 0, 5},
+		{"5", 5, 0},
+		{"off", 0, 0},
+		{"bo-1", 0, 0},
+		{"ftx", 0, 0},
+	}
+	for _, tt := range tests {
+		room := &Room{SeriesBestOf: 7, SeriesTarget: 2, Series: &Series{}}
+		setSeriesMode(room, tt.value)
+		if room.SeriesBestOf != tt.bestOf || room.SeriesTarget != tt.target || room.Series != nil {
+			t.Errorf("%s: best of %d, target %d", tt.value, room.SeriesBestOf, room.SeriesTarget)
+		}
+	}
+}
+
+func TestSeriesFinished(t *testing.T) {
+	tests := []struct {
+		name   string
+		bestOf int
+		target int
+		games  int
+		scores map[int64]int
+		want   bool
+	}{
+		{"bo3 ends at 2-0", 3, 0, 2, map[int64]int{1: 2, 2: 0}, true},
+		{"bo3 goes on at 1-1", 3, 0, 2, map[int64]int{1: 1, 2: 1}, false},
+		{"bo3 ends after three games", 3, 0, 3, map[int64]int{1: 1, 2: 1}, true},
+		{"bo5 goes on at 2-1", 5, 0, 3, map[int64]int{1: 2, 2: 1}, false},
+		{"ft5 goes on at 4", 0, 5, 8, map[int64]int{1: 4, 2: 4}, false},
+		{"ft5 ends at 5", 0, 5, 9, map[int64]int{1: 5, 2: 4}, true},
+	}
+	for _, tt := range tests {
+		room := &Room{SeriesBestOf: tt.bestOf, SeriesTarget: tt.target}
+		s := &Series{Games: tt.games, Scores: tt.scores}
+		if got := s.finished(room); got != tt.want {
+			t.Errorf("%s: finished %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRematch(t *testing.T) {
+	room := CreateRoom(0, consts.GameTypeClassic)
+	humans := make([]int64, 0, 2)
+	for i := 0; i < 2; i++ {
+		id := atomic.AddInt64(&playerIds, 1)
+		players.Set(id, &Player{ID: id, Name: "human"})
+		getRoomPlayers(room.ID)[id] = true
+		humans = append(humans, id)
+	}
+	robot, err := AddRobot(room.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if accepted, total, ready := Rematch(room, humans[0]); accepted != 1 || total != 2 || ready {
+		t.Errorf("first rematch: %d/%d ready %v", accepted, total, ready)
+	}
+	// 机器人不计入同意的人数
+	if accepted, total, ready := Rematch(room, robot.ID); accepted != 1 || total != 2 || ready {
+		t.Errorf("robot rematch: %d/%d ready %v", accepted, total, ready)
+	}
+	if accepted, total, ready := Rematch(room, humans[1]); accepted != 2 || total != 2 || !ready {
+		t.Errorf("second rematch: %d/%d ready %v", accepted, total, ready)
+	}
+}
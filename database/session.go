@@ -0,0 +1,59 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/awesome-cap/hashmap"
+	"github.com/ratel-online/core/consts"
+	"github.com/ratel-online/core/network"
+)
+
+// 会话令牌 -> 玩家，用于断线后恢复会话
+var sessions = hashmap.New()
+
+func newSessionToken() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Resume 使用会话令牌将断线的玩家重新绑定到新连接，令牌无效或会话已过期时返回 nil，
+// mode 为新连接的协议模式，在写出任何消息前生效
+func Resume(conn *network.Conn, token string, mode int) *Player {
+	if token == "" {
+		return nil
+	}
+	v, ok := sessions.Get(token)
+	if !ok {
+		return nil
+	}
+	return resume(conn, v.(*Player), mode)
+}
+
+// Takeover 账号在其他连接上仍有会话（包括断线宽限期内）时，将会话转移到新连接，原连接会被关闭
+func Takeover(conn *network.Conn, playerId int64, mode int) *Player {
+	player := getPlayer(playerId)
+	if player == nil {
+		return nil
+	}
+	return resume(conn, player, mode)
+}
+
+func resume(conn *network.Conn, player *Player, mode int) *Player {
+	if !player.rebind(conn, mode) {
+		return nil
+	}
+	connPlayers.Set(conn.ID(), player)
+
+	_ = player.WriteString(fmt.Sprintf("Welcome back %s, your session has been resumed.\n", player.Name))
+	if room := getRoom(player.RoomID); room != nil {
+		Broadcast(room.ID, fmt.Sprintf("%s reconnected! \n", player.Name), player.ID)
+	}
+	// 断线前正在等待输入时，重新通知客户端开启交互
+	if player.read {
+		_ = player.WriteString(consts.IsStart)
+	}
+	return player
+}
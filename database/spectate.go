@@ -0,0 +1,253 @@
+package database
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/feel-easy/mahjong/tile"
+	"github.com/ratel-online/core/util/poker"
+	"github.com/ratel-online/server/consts"
+)
+
+// Seat 观众看到的一名玩家，只包含公开的信息
+type Seat struct {
+	PlayerID int64  `json:"playerId"`
+	Name     string `json:"name"`
+	Cards    int    `json:"cards"`  // 手牌数量
+	Status   string `json:"status"` // 身份、筹码、存活等公开状态
+}
+
+// Hand 一名玩家的手牌，只在房间开启延迟公开后展示给观众
+type Hand struct {
+	PlayerID int64    `json:"playerId"`
+	Name     string   `json:"name"`
+	Cards    []string `json:"cards"`
+}
+
+// SpectatorView 观众看到的对局
+type SpectatorView struct {
+	Game  int      `json:"game"`
+	Board []string `json:"board"` // 桌面上的公开信息，每一项显示为一行
+	Seats []Seat   `json:"seats"`
+	Hands []Hand   `json:"hands,omitempty"`
+	Delay int      `json:"delay,omitempty"` // 手牌延迟的秒数
+}
+
+type handsSnapshot struct {
+	time  time.Time
+	hands []Hand
+}
+
+// Snapshot 在对局的协程中生成观众看到的画面。观众只读取这份副本，不直接访问对局中的数据，
+// 每次记录对局事件和开局时调用
+func Snapshot(room *Room) {
+	if room == nil || room.Game == nil {
+		return
+	}
+	view := &SpectatorView{Game: room.Type, Board: make([]string, 0), Seats: make([]Seat, 0)}
+	var hands []Hand
+	switch game := room.Game.(type) {
+	case *Game:
+		hands = spectateGame(game, view)
+	case *UnoGame:
+		hands = spectateUno(game, view)
+	case *Mahjong:
+		hands = spectateMahjong(game, view)
+	case *Texas:
+		hands = spectateTexas(game, view)
+	case *Liar:
+		hands = spectateLiar(game, view)
+	case *Undercover:
+		hands = spectateUndercover(game, view)
+	}
+	room.viewLock.Lock()
+	defer room.viewLock.Unlock()
+	if room.viewGame != room.Game {
+		room.reveals = nil
+	}
+	room.view = view
+	room.viewGame = room.Game
+	if room.RevealDelay > 0 && (len(room.reveals) == 0 || !reflect.DeepEqual(room.reveals[len(room.reveals)-1].hands, hands)) {
+		room.reveals = append(room.reveals, handsSnapshot{time: time.Now(), hands: hands})
+	}
+}
+
+// Spectate 观众看到的对局，手牌按房间的延迟设置公开，对局未开始时返回 nil
+func Spectate(room *Room) *SpectatorView {
+	room.Lock()
+	defer room.Unlock()
+	game := room.Game
+	room.viewLock.Lock()
+	defer room.viewLock.Unlock()
+	if game == nil || room.view == nil || room.viewGame != game {
+		return nil
+	}
+	view := *room.view
+	if room.RevealDelay > 0 {
+		view.Hands = room.revealed()
+		view.Delay = int(room.RevealDelay.Seconds())
+	}
+	return &view
+}
+
+// revealed 返回延迟之前最后一次记录的手牌，更早的记录会被丢弃
+func (r *Room) revealed() []Hand {
+	if len(r.reveals) == 0 {
+		return nil
+	}
+	now := time.Now()
+	i := 0
+	for i+1 < len(r.reveals) && now.Sub(r.reveals[i+1].time) >= r.RevealDelay {
+		i++
+	}
+	r.reveals = r.reveals[i:]
+	if now.Sub(r.reveals[0].time) < r.RevealDelay {
+		return nil
+	}
+	return r.reveals[0].hands
+}
+
+func spectateGame(game *Game, view *SpectatorView) []Hand {
+	if game.LastPlayer != 0 && len(game.LastPokers) > 0 {
+		view.Board = append(view.Board, fmt.Sprintf("Last play: %s by %s", game.LastPokers.String(), playerName(game.LastPlayer)))
+	}
+	if game.Multiple > 0 {
+		view.Board = append(view.Board, fmt.Sprintf("Multiple: %d", game.Multiple))
+	}
+	hands := make([]Hand, 0, len(game.Players))
+	for _, id := range game.Players {
+		view.Seats = append(view.Seats, Seat{PlayerID: id, Name: playerName(id), Cards: len(game.Pokers[id]), Status: game.Team(id)})
+		hands = append(hands, Hand{PlayerID: id, Name: playerName(id), Cards: Cards(game.Pokers[id])})
+	}
+	return hands
+}
+
+func spectateUno(game *UnoGame, view *SpectatorView) []Hand {
+	if cards := game.Game.Pile().Cards(); len(cards) > 0 {
+		view.Board = append(view.Board, fmt.Sprintf("Top card: %s", UnoCards(game.Game.Pile().Top())[0]))
+	}
+	if game.Penalty > 0 {
+		view.Board = append(view.Board, fmt.Sprintf("Pending draw: %d", game.Penalty))
+	}
+	hands := make([]Hand, 0, len(game.Players))
+	for _, id := range game.Players {
+		cards := game.Game.GetPlayerCards(game.Seat(int64(id)))
+		status := ""
+		if up := game.SeatOf(game.Seat(int64(id))); up != nil && up.Uno {
+			status = "UNO"
+		}
+		view.Seats = append(view.Seats, Seat{PlayerID: int64(id), Name: playerName(int64(id)), Cards: len(cards), Status: status})
+		hands = append(hands, Hand{PlayerID: int64(id), Name: playerName(int64(id)), Cards: UnoCards(cards...)})
+	}
+	return hands
+}
+
+func spectateMahjong(game *Mahjong, view *SpectatorView) []Hand {
+	view.Board = append(view.Board,
+		fmt.Sprintf("Rules: %s, %d tiles left", consts.MahjongVariants[game.Variant], len(game.Wall)),
+		fmt.Sprintf("Played tiles: %s", tile.ToTileString(game.Game.Pile().Tiles())),
+	)
+	hands := make([]Hand, 0, len(game.PlayerIDs))
+	for _, id := range game.PlayerIDs {
+		seat := game.Seat(int64(id))
+		p := game.Game.Players().GetPlayerController(seat)
+		status := ""
+		if !game.Active(seat) {
+			status += "won "
+		}
+		game.Lock()
+		if suit, ok := game.Missing[seat]; ok {
+			status += fmt.Sprintf("missing %s ", mahjongSuitNames[suit])
+		}
+		game.Unlock()
+		if flowers := game.Flowers[seat]; len(flowers) > 0 {
+			status += fmt.Sprintf("flowers %s ", tile.ToTileString(flowers))
+		}
+		for _, showCard := range p.GetShowCard() {
+			status += showCard.String() + " "
+		}
+		view.Seats = append(view.Seats, Seat{PlayerID: int64(id), Name: playerName(int64(id)), Cards: len(p.Hand()), Status: status})
+		hands = append(hands, Hand{PlayerID: int64(id), Name: playerName(int64(id)), Cards: MahjongTiles(p.Hand())})
+	}
+	return hands
+}
+
+func spectateTexas(game *Texas, view *SpectatorView) []Hand {
+	view.Board = append(view.Board,
+		fmt.Sprintf("Hand #%d, %s round, blinds %d/%d", game.Hands, game.Round, game.SmallBlind, game.BigBlind),
+		fmt.Sprintf("Board: %s", game.Board.TexasString()),
+		fmt.Sprintf("Pot: %d", game.Pot),
+	)
+	hands := make([]Hand, 0, len(game.Players))
+	for _, p := range game.Players {
+		status := fmt.Sprintf("chips %d, bets %d", p.Amount(), p.Bets)
+		switch {
+		case p.Out:
+			status = "out"
+		case p.Folded:
+			status += ", folded"
+		case p.AllIn:
+			status += ", all-in"
+		}
+		view.Seats = append(view.Seats, Seat{PlayerID: p.ID, Name: p.Name, Cards: len(p.Hand), Status: status})
+		if !p.Out {
+			hands = append(hands, Hand{PlayerID: p.ID, Name: p.Name, Cards: TexasCards(p.Hand)})
+		}
+	}
+	return hands
+}
+
+func spectateLiar(game *Liar, view *SpectatorView) []Hand {
+	if game.Target != nil {
+		view.Board = append(view.Board, fmt.Sprintf("Target: %s", poker.GetDesc(game.Target.Key)))
+	}
+	if game.LastPlayerID != 0 && len(game.LastPokers) > 0 {
+		view.Board = append(view.Board, fmt.Sprintf("Last play: %d cards by %s", len(game.LastPokers), playerName(game.LastPlayerID)))
+	}
+	hands := make([]Hand, 0, len(game.PlayerIDs))
+	for _, id := range game.PlayerIDs {
+		status := fmt.Sprintf("alive, [%d]/6", game.Bong[id])
+		if !game.Alive[id] {
+			status = "dead"
+		}
+		view.Seats = append(view.Seats, Seat{PlayerID: id, Name: playerName(id), Cards: len(game.Hands[id]), Status: status})
+		if game.Alive[id] {
+			hands = append(hands, Hand{PlayerID: id, Name: playerName(id), Cards: Cards(game.Hands[id])})
+		}
+	}
+	return hands
+}
+
+func spectateUndercover(game *Undercover, view *SpectatorView) []Hand {
+	game.Lock()
+	defer game.Unlock()
+	view.Board = append(view.Board, fmt.Sprintf("Round %d", game.Round))
+	hands := make([]Hand, 0, len(game.PlayerIDs))
+	for _, id := range game.PlayerIDs {
+		status := "alive"
+		if !game.Alive[id] {
+			status = "out"
+		}
+		if desc, ok := game.Descriptions[id]; ok {
+			status += ", said: " + desc
+		}
+		view.Seats = append(view.Seats, Seat{PlayerID: id, Name: fmt.Sprintf("%d.%s", game.PlayerNumbers[id], playerName(id)), Status: status})
+		word := game.Words[id]
+		switch {
+		case game.IsBlankWord[id]:
+			word = "(blank)"
+		case game.IsUndercover[id]:
+			word += " (undercover)"
+		}
+		hands = append(hands, Hand{PlayerID: id, Name: playerName(id), Cards: []string{word}})
+	}
+	return hands
+}
+
+func playerName(id int64) string {
+	if p := getPlayer(id); p != nil {
+		return p.Name
+	}
+	return fmt.Sprint(id)
+}
@@ -0,0 +1,213 @@
+package database
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/ratel-online/core/log"
+	"github.com/ratel-online/core/util/json"
+	"github.com/ratel-online/server/consts"
+)
+
+const bucketStats = "stats"
+
+var statsLock sync.Mutex
+
+// 内存中的统计，启动时从存储中恢复，RecordStats 时更新，都由 statsLock 保护
+var (
+	statsByKey   = map[string]*PlayerStats{}
+	statsNames   = map[string]string{}      // 小写的玩家名称到统计的 key
+	leaderboards = map[int][]*PlayerStats{} // 按游戏类型排好序的排行榜
+)
+
+// GameStats 玩家在一种游戏中的统计
+type GameStats struct {
+	Played       int   `json:"played"`
+	Wins         int   `json:"wins"`
+	Landlord     int   `json:"landlord,omitempty"`     // 斗地主当地主的局数
+	LandlordWins int   `json:"landlordWins,omitempty"` // 斗地主当地主获胜的局数
+	NetChips     int64 `json:"netChips,omitempty"`     // 德州扑克的净输赢
+	Survived     int   `json:"survived,omitempty"`     // 谁是卧底存活到最后的局数
+}
+
+func (s GameStats) Losses() int {
+	return s.Played - s.Wins
+}
+
+func (s GameStats) WinRate() float64 {
+	if s.Played == 0 {
+		return 0
+	}
+	return float64(s.Wins) / float64(s.Played)
+}
+
+// PlayerStats 玩家按游戏类型的统计，与玩家档案使用相同的 key
+type PlayerStats struct {
+	Key   string             `json:"key"`
+	Name  string             `json:"name"`
+	Games map[int]*GameStats `json:"games"`
+}
+
+// GameResult 一名玩家在一局游戏中的结果
+type GameResult struct {
+	PlayerID int64
+	Won      bool
+	Landlord bool
+	Chips    int64
+	Survived bool
+}
+
+// Results 按胜者列表生成一局中全部玩家的结果
+func Results[T int | int64](players []T, winners ...int64) []GameResult {
+	results := make([]GameResult, 0, len(players))
+	for _, id := range players {
+		results = append(results, GameResult{PlayerID: int64(id), Won: slices.Contains(winners, int64(id))})
+	}
+	return results
+}
+
+// clone 统计的副本，返回给调用者，避免与 RecordStats 同时读写
+func (s *PlayerStats) clone() *PlayerStats {
+	c := &PlayerStats{Key: s.Key, Name: s.Name, Games: make(map[int]*GameStats, len(s.Games))}
+	for gameType, game := range s.Games {
+		g := *game
+		c.Games[gameType] = &g
+	}
+	return c
+}
+
+// RecordStats 在结算时记录一局游戏的结果，机器人不参与统计
+func RecordStats(gameType int, results ...GameResult) {
+	statsLock.Lock()
+	defer statsLock.Unlock()
+	for _, result := range results {
+		player := getPlayer(result.PlayerID)
+		if player == nil || player.key == "" {
+			continue
+		}
+		stats := statsByKey[player.key]
+		if stats == nil {
+			stats = &PlayerStats{Key: player.key, Games: map[int]*GameStats{}}
+			statsByKey[player.key] = stats
+		}
+		if name := strings.ToLower(stats.Name); statsNames[name] == stats.Key {
+			delete(statsNames, name)
+		}
+		stats.Name = player.Name
+		statsNames[strings.ToLower(stats.Name)] = stats.Key
+		game := stats.Games[gameType]
+		if game == nil {
+			game = &GameStats{}
+			stats.Games[gameType] = game
+		}
+		game.Played++
+		if result.Won {
+			game.Wins++
+		}
+		if result.Landlord {
+			game.Landlord++
+			if result.Won {
+				game.LandlordWins++
+			}
+		}
+		game.NetChips += result.Chips
+		if result.Survived {
+			game.Survived++
+		}
+		rankStats(gameType, stats)
+		if err := store.Put(bucketStats, player.key, json.Marshal(stats)); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// restoreStats 从存储中恢复统计、名称索引和排行榜
+func restoreStats() {
+	statsLock.Lock()
+	defer statsLock.Unlock()
+	statsByKey = map[string]*PlayerStats{}
+	statsNames = map[string]string{}
+	leaderboards = map[int][]*PlayerStats{}
+	err := store.Foreach(bucketStats, func(key string, value []byte) error {
+		stats := &PlayerStats{}
+		if err := json.Unmarshal(value, stats); err != nil {
+			return nil
+		}
+		if stats.Games == nil {
+			stats.Games = map[int]*GameStats{}
+		}
+		statsByKey[stats.Key] = stats
+		statsNames[strings.ToLower(stats.Name)] = stats.Key
+		for gameType, game := range stats.Games {
+			if game.Played > 0 {
+				leaderboards[gameType] = append(leaderboards[gameType], stats)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error(err)
+	}
+	for gameType, list := range leaderboards {
+		slices.SortStableFunc(list, func(a, b *PlayerStats) int {
+			return compareStats(gameType, a, b)
+		})
+	}
+}
+
+// rankStats 玩家的统计变化后更新在排行榜中的位置
+func rankStats(gameType int, stats *PlayerStats) {
+	list := leaderboards[gameType]
+	if i := slices.Index(list, stats); i >= 0 {
+		list = slices.Delete(list, i, i+1)
+	}
+	i, _ := slices.BinarySearchFunc(list, stats, func(a, b *PlayerStats) int {
+		return compareStats(gameType, a, b)
+	})
+	leaderboards[gameType] = slices.Insert(list, i, stats)
+}
+
+// compareStats 排行榜的顺序，德州扑克按净输赢排序，其余游戏按胜场和胜率排序
+func compareStats(gameType int, x, y *PlayerStats) int {
+	a, b := x.Games[gameType], y.Games[gameType]
+	if gameType == consts.GameTypeTexas && a.NetChips != b.NetChips {
+		return cmp.Compare(b.NetChips, a.NetChips)
+	}
+	if a.Wins != b.Wins {
+		return cmp.Compare(b.Wins, a.Wins)
+	}
+	if a.WinRate() != b.WinRate() {
+		return cmp.Compare(b.WinRate(), a.WinRate())
+	}
+	return strings.Compare(x.Name, y.Name)
+}
+
+// GetStats 玩家的统计，name 为空时查询自己
+func GetStats(player *Player, name string) *PlayerStats {
+	statsLock.Lock()
+	defer statsLock.Unlock()
+	if name == "" || strings.EqualFold(name, player.Name) {
+		if stats := statsByKey[player.key]; stats != nil {
+			return stats.clone()
+		}
+		return &PlayerStats{Key: player.key, Name: player.Name, Games: map[int]*GameStats{}}
+	}
+	if stats := statsByKey[statsNames[strings.ToLower(name)]]; stats != nil {
+		return stats.clone()
+	}
+	return nil
+}
+
+// TopStats 某种游戏的排行榜，德州扑克按净输赢排序，其余游戏按胜场和胜率排序
+func TopStats(gameType int, limit int) []*PlayerStats {
+	statsLock.Lock()
+	defer statsLock.Unlock()
+	board := leaderboards[gameType]
+	list := make([]*PlayerStats, 0, min(limit, len(board)))
+	for _, stats := range board[:min(limit, len(board))] {
+		list = append(list, stats.clone())
+	}
+	return list
+}
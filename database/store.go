@@ -0,0 +1,285 @@
+package database
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/awesome-cap/hashmap"
+	"github.com/ratel-online/core/log"
+	"github.com/ratel-online/core/util/json"
+	"github.com/ratel-online/server/consts"
+)
+
+const (
+	bucketProfiles = "profiles"
+	bucketRooms    = "rooms"
+)
+
+// Store 持久化存储，数据按 bucket/key 组织，值为序列化后的内容
+type Store interface {
+	// Get 读取数据，不存在时 ok 为 false
+	Get(bucket, key string) (value []byte, ok bool, err error)
+	Put(bucket, key string, value []byte) error
+	Delete(bucket, key string) error
+	// Foreach 按 key 的字典序遍历 bucket 中的全部数据
+	Foreach(bucket string, fn func(key string, value []byte) error) error
+	Close() error
+}
+
+var store Store = NewMemoryStore()
+
+// SetStore 切换持久化存储，并恢复存储中保存的账号 ID、对局 ID、房间和统计
+func SetStore(s Store) {
+	store = s
+	restorePlayerIds()
+	restoreGameIds()
+	restoreRooms()
+	restoreStats()
+}
+
+// Flush 将会话中玩家的数据写入存储
+func Flush() {
+	sessions.Foreach(func(e *hashmap.Entry) {
+		SavePlayer(e.Value().(*Player))
+	})
+}
+
+// profile 玩家档案，跨重启保留
+type profile struct {
+	Key        string    `json:"key"`
+	Name       string    `json:"name"`
+	Amount     uint      `json:"amount"`
+	UpdateTime time.Time `json:"updateTime"`
+}
+
+func loadProfile(key string) *profile {
+	data, ok, err := store.Get(bucketProfiles, key)
+	if err != nil {
+		log.Error(err)
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+	p := &profile{}
+	if err = json.Unmarshal(data, p); err != nil {
+		log.Error(err)
+		return nil
+	}
+	return p
+}
+
+// SavePlayer 保存玩家档案，在注销会话和每次结算筹码后调用，没有档案的玩家（如机器人）不保存
+func SavePlayer(player *Player) {
+	if player == nil || player.key == "" {
+		return
+	}
+	err := store.Put(bucketProfiles, player.key, json.Marshal(profile{
+		Key:        player.key,
+		Name:       player.Name,
+		Amount:     player.Amount,
+		UpdateTime: time.Now(),
+	}))
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// roomRecord 房间配置，跨重启保留
+type roomRecord struct {
+	ID                  int64  `json:"id"`
+	Type                int    `json:"type"`
+	Creator             int64  `json:"creator"`
+	MaxPlayers          int    `json:"maxPlayers"`
+	Password            string `json:"password"`
+	EnableChat          bool   `json:"enableChat"`
+	EnableLaiZi         bool   `json:"enableLaiZi"`
+	EnableSkill         bool   `json:"enableSkill"`
+	EnableLandlord      bool   `json:"enableLandlord"`
+	EnableDontShuffle   bool   `json:"enableDontShuffle"`
+	EnableShowIP        bool   `json:"enableShowIP"`
+	EnableJokerAsTarget bool   `json:"enableJokerAsTarget"`
+	UndercoverNum       int    `json:"undercoverNum"`
+	BlankWordMode       bool   `json:"blankWordMode"`
+	SmallBlind          uint   `json:"smallBlind"`
+	BigBlind            uint   `json:"bigBlind"`
+	Ante                uint   `json:"ante"`
+	Stack               uint   `json:"stack"`
+	BlindLevelHands     int    `json:"blindLevelHands"`
+	BlindLevelTime      int64  `json:"blindLevelTime"`
+	SeriesBestOf        int    `json:"seriesBestOf"`
+	SeriesTarget        int    `json:"seriesTarget"`
+	EnableSpectatorChat bool   `json:"enableSpectatorChat"`
+	RevealDelay         int64  `json:"revealDelay"`
+	EnableDrawUntil     bool   `json:"enableDrawUntil"`
+	EnableStackDraws    bool   `json:"enableStackDraws"`
+	MahjongVariant      int    `json:"mahjongVariant"`
+	EnableTraining      bool   `json:"enableTraining"`
+	BettingLimit        int    `json:"bettingLimit"`
+}
+
+func saveRoom(room *Room) {
+	err := store.Put(bucketRooms, storeKey(room.ID), json.Marshal(roomRecord{
+		ID:                  room.ID,
+		Type:                room.Type,
+		Creator:             room.Creator,
+		MaxPlayers:          room.MaxPlayers,
+		Password:            room.Password,
+		EnableChat:          room.EnableChat,
+		EnableLaiZi:         room.EnableLaiZi,
+		EnableSkill:         room.EnableSkill,
+		EnableLandlord:      room.EnableLandlord,
+		EnableDontShuffle:   room.EnableDontShuffle,
+		EnableShowIP:        room.EnableShowIP,
+		EnableJokerAsTarget: room.EnableJokerAsTarget,
+		UndercoverNum:       room.UndercoverNum,
+		BlankWordMode:       room.BlankWordMode,
+		SmallBlind:          room.SmallBlind,
+		BigBlind:            room.BigBlind,
+		Ante:                room.Ante,
+		Stack:               room.Stack,
+		BlindLevelHands:     room.BlindLevelHands,
+		BlindLevelTime:      int64(room.BlindLevelTime),
+		SeriesBestOf:        room.SeriesBestOf,
+		SeriesTarget:        room.SeriesTarget,
+		EnableSpectatorChat: room.EnableSpectatorChat,
+		RevealDelay:         int64(room.RevealDelay),
+		EnableDrawUntil:     room.EnableDrawUntil,
+		EnableStackDraws:    room.EnableStackDraws,
+		MahjongVariant:      room.MahjongVariant,
+		EnableTraining:      room.EnableTraining,
+		BettingLimit:        room.BettingLimit,
+	}))
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+func removeRoom(room *Room) {
+	if err := store.Delete(bucketRooms, storeKey(room.ID)); err != nil {
+		log.Error(err)
+	}
+}
+
+// restoreRooms 恢复存储中的房间，恢复后的房间为空闲状态，等待玩家重新加入
+func restoreRooms() {
+	err := store.Foreach(bucketRooms, func(key string, value []byte) error {
+		r := roomRecord{}
+		if err := json.Unmarshal(value, &r); err != nil {
+			return err
+		}
+		if getRoom(r.ID) != nil {
+			return nil
+		}
+		room := &Room{
+			ID:                  r.ID,
+			Type:                r.Type,
+			State:               consts.RoomStateWaiting,
+			Creator:             r.Creator,
+			ActiveTime:          time.Now(),
+			MaxPlayers:          r.MaxPlayers,
+			Password:            r.Password,
+			EnableChat:          r.EnableChat,
+			EnableLaiZi:         r.EnableLaiZi,
+			EnableSkill:         r.EnableSkill,
+			EnableLandlord:      r.EnableLandlord,
+			EnableDontShuffle:   r.EnableDontShuffle,
+			EnableShowIP:        r.EnableShowIP,
+			EnableJokerAsTarget: r.EnableJokerAsTarget,
+			UndercoverNum:       r.UndercoverNum,
+			BlankWordMode:       r.BlankWordMode,
+			SmallBlind:          r.SmallBlind,
+			BigBlind:            r.BigBlind,
+			Ante:                r.Ante,
+			Stack:               r.Stack,
+			BlindLevelHands:     r.BlindLevelHands,
+			BlindLevelTime:      time.Duration(r.BlindLevelTime),
+			SeriesBestOf:        r.SeriesBestOf,
+			SeriesTarget:        r.SeriesTarget,
+			EnableSpectatorChat: r.EnableSpectatorChat,
+			RevealDelay:         time.Duration(r.RevealDelay),
+			EnableDrawUntil:     r.EnableDrawUntil,
+			EnableStackDraws:    r.EnableStackDraws,
+			MahjongVariant:      r.MahjongVariant,
+			EnableTraining:      r.EnableTraining,
+			BettingLimit:        r.BettingLimit,
+		}
+		roomPlayers.Set(room.ID, map[int64]bool{})
+		roomSpectators.Set(room.ID, map[int64]int{})
+		rooms.Set(room.ID, room)
+		for {
+			last := roomIds
+			if last >= room.ID || atomic.CompareAndSwapInt64(&roomIds, last, room.ID) {
+				break
+			}
+		}
+		log.Infof("room %d restored.\n", room.ID)
+		return nil
+	})
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+func storeKey(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
+
+// memoryStore 内存存储，进程退出后数据丢失
+type memoryStore struct {
+	sync.RWMutex
+	buckets map[string]map[string][]byte
+}
+
+func NewMemoryStore() Store {
+	return &memoryStore{buckets: map[string]map[string][]byte{}}
+}
+
+func (s *memoryStore) Get(bucket, key string) ([]byte, bool, error) {
+	s.RLock()
+	defer s.RUnlock()
+	value, ok := s.buckets[bucket][key]
+	return value, ok, nil
+}
+
+func (s *memoryStore) Put(bucket, key string, value []byte) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.buckets[bucket] == nil {
+		s.buckets[bucket] = map[string][]byte{}
+	}
+	s.buckets[bucket][key] = value
+	return nil
+}
+
+func (s *memoryStore) Delete(bucket, key string) error {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.buckets[bucket], key)
+	return nil
+}
+
+func (s *memoryStore) Foreach(bucket string, fn func(key string, value []byte) error) error {
+	s.RLock()
+	keys := make([]string, 0, len(s.buckets[bucket]))
+	values := make(map[string][]byte, len(s.buckets[bucket]))
+	for k, v := range s.buckets[bucket] {
+		keys = append(keys, k)
+		values[k] = v
+	}
+	s.RUnlock()
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := fn(k, values[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,266 @@
+package database
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ratel-online/core/protocol"
+	"github.com/ratel-online/server/consts"
+)
+
+// robotRunner 启动替补机器人的状态机，机器人的状态机在 state 包中，由 state 包注册
+var robotRunner func(robot *Player)
+
+func SetRobotRunner(runner func(robot *Player)) {
+	robotRunner = runner
+}
+
+// seats 支持换人的对局，把座位上的玩家替换为另一名玩家，手牌和回合状态都留在座位上
+type seats interface {
+	Substitute(from, to int64)
+}
+
+// RequestSwap 对局中的玩家申请把座位交给观众或机器人，轮到该玩家时生效
+func RequestSwap(player *Player) error {
+	room := getRoom(player.RoomID)
+	if room == nil || room.State != consts.RoomStateRunning || player.Role == RoleSpectator {
+		return consts.ErrorsSwapUnavailable
+	}
+	if _, ok := tableTournaments.Get(room.ID); ok {
+		return consts.ErrorsSwapUnavailable
+	}
+	room.Lock()
+	defer room.Unlock()
+	if _, ok := room.Game.(seats); !ok {
+		return consts.ErrorsSwapUnavailable
+	}
+	if firstSpectator(room) == 0 && !robotSupported(room.Type) {
+		return consts.ErrorsSubstituteNotFound
+	}
+	player.swap = true
+	return nil
+}
+
+// Substitute 轮到申请换人或会话已过期的玩家时，由最早进入房间的观众或新的机器人接替座位。
+// 接替的玩家得到原来的手牌和回合状态，换下的玩家成为观众，会话已过期的玩家离开房间。
+// 不需要换人或没有人可以接替时返回 nil
+func Substitute(room *Room, player *Player) *Player {
+	if !player.swap && !player.closed {
+		return nil
+	}
+	if _, ok := tableTournaments.Get(room.ID); ok {
+		return nil
+	}
+	room.Lock()
+	game, ok := room.Game.(seats)
+	// 已解散的房间不再换人，对局的通道已经关闭
+	if !ok || getRoom(room.ID) == nil {
+		room.Unlock()
+		return nil
+	}
+	var substitute *Player
+	if id := firstSpectator(room); id != 0 {
+		substitute = getPlayer(id)
+		delete(getRoomSpectators(room.ID), id)
+	} else if robotSupported(room.Type) && (!player.closed || hasOtherHumans(room, player.ID)) {
+		// 只剩机器人的房间会被解散，会话已过期的玩家没有真人对手时不再补机器人
+		substitute = newRobot(room)
+	}
+	if substitute == nil {
+		player.swap = false
+		room.Unlock()
+		_ = player.WriteError(consts.ErrorsSubstituteNotFound)
+		return nil
+	}
+	game.Substitute(player.ID, substitute.ID)
+
+	playersIds := getRoomPlayers(room.ID)
+	delete(playersIds, player.ID)
+	playersIds[substitute.ID] = true
+	substitute.RoomID = room.ID
+	substitute.Role = RolePlayer
+	player.swap = false
+	if player.closed {
+		player.RoomID = 0
+		player.Role = ""
+	} else {
+		spectatorsIds := getRoomSpectators(room.ID)
+		spectatorsIds[player.ID] = len(spectatorsIds)
+		player.Role = RoleSpectator
+	}
+	if room.Creator == player.ID {
+		room.Creator = substitute.ID
+		// 房主只转让给真人玩家
+		for id := range playersIds {
+			if p := getPlayer(id); p != nil && !p.robot {
+				room.Creator = id
+				break
+			}
+		}
+		if p := getPlayer(room.Creator); p != nil && !p.robot {
+			p.Role = RoleOwner
+		}
+	}
+	msg := fmt.Sprintf("%s takes over %s's seat\n", substitute.Name, player.Name)
+	Record(room, substitute.ID, ActionSubstitute, fmt.Sprintf("takes over %s", player.Name))
+	room.Unlock()
+
+	broadcast(room, msg)
+	if substitute.robot && robotRunner != nil {
+		robotRunner(substitute)
+	}
+	return substitute
+}
+
+// HandOver 玩家收到回合信号后调用，有人接替座位时把这次的信号转给座位的通道，由接替的玩家继续这个回合
+func HandOver(room *Room, player *Player, state chan int, signal int) bool {
+	if Substitute(room, player) == nil {
+		return false
+	}
+	state <- signal
+	return true
+}
+
+// Vacate 玩家中途离开对局时，由观众或机器人接替座位，其余玩家的对局继续；没有人可以接替时返回 nil
+func Vacate(room *Room, player *Player) *Player {
+	player.swap = true
+	return Substitute(room, player)
+}
+
+// hasOtherHumans 房间中除了指定玩家还有没有真人玩家或观众
+func hasOtherHumans(room *Room, playerId int64) bool {
+	for id := range getRoomPlayers(room.ID) {
+		if p := getPlayer(id); p != nil && !p.robot && id != playerId {
+			return true
+		}
+	}
+	return len(getRoomSpectators(room.ID)) > 0
+}
+
+// replaceKey 把 map 中 from 的值移到 to 上
+func replaceKey[K comparable, V any](m map[K]V, from, to K) {
+	if v, ok := m[from]; ok {
+		delete(m, from)
+		m[to] = v
+	}
+}
+
+// replaceValue 把切片中的 from 替换为 to
+func replaceValue[T comparable](s []T, from, to T) {
+	if i := slices.Index(s, from); i >= 0 {
+		s[i] = to
+	}
+}
+
+func (g *Game) Substitute(from, to int64) {
+	replaceValue(g.Players, from, to)
+	replaceValue(g.Robs, from, to)
+	for _, m := range []map[int64]int{g.Groups, g.Skills, g.PlayTimes, g.Plays, g.Bombs} {
+		replaceKey(m, from, to)
+	}
+	replaceKey(g.States, from, to)
+	replaceKey(g.Pokers, from, to)
+	replaceKey(g.PlayTimeOut, from, to)
+	for _, id := range []*int64{&g.FirstPlayer, &g.LastPlayer, &g.FirstRob, &g.LastRob} {
+		if *id == from {
+			*id = to
+		}
+	}
+}
+
+// Substitute 换下的玩家在这一手弃牌，已经下注的筹码留在底池中，由换下的玩家承担并计入其战绩。
+// 接替的玩家从下一手开始参与：使用玩家余额时用自己的余额下注，使用独立筹码时接替座位剩余的筹码
+func (g *Texas) Substitute(from, to int64) {
+	p := g.Player(from)
+	if p == nil {
+		return
+	}
+	if !p.Out && !p.Left {
+		RecordStats(g.Room.Type, GameResult{PlayerID: from, Chips: -int64(p.Bets)})
+		p.Left = true
+	}
+	if !p.Folded {
+		p.Folded = true
+		g.Folded++
+	}
+	p.ID = to
+	p.Name = playerName(to)
+}
+
+func (l *Liar) Substitute(from, to int64) {
+	replaceValue(l.PlayerIDs, from, to)
+	for _, m := range []map[int64]int{l.Bullets, l.Bong} {
+		replaceKey(m, from, to)
+	}
+	for _, m := range []map[int64]bool{l.Alive, l.Supervisors} {
+		replaceKey(m, from, to)
+	}
+	replaceKey(l.States, from, to)
+	replaceKey(l.Hands, from, to)
+	if l.LastPlayerID == from {
+		l.LastPlayerID = to
+	}
+}
+
+func (u *Undercover) Substitute(from, to int64) {
+	u.Lock()
+	defer u.Unlock()
+	for _, s := range [][]int64{u.PlayerIDs, u.VoteTargets, u.TiebreakPlayers, u.RevealUndercoverIDs} {
+		replaceValue(s, from, to)
+	}
+	for _, m := range []map[int64]bool{u.IsUndercover, u.IsBlankWord, u.Alive, u.RevealUsed} {
+		replaceKey(m, from, to)
+	}
+	for _, m := range []map[int64]string{u.Words, u.Descriptions} {
+		replaceKey(m, from, to)
+	}
+	replaceKey(u.States, from, to)
+	replaceKey(u.PlayerNumbers, from, to)
+	replaceKey(u.Votes, from, to)
+	for voter, target := range u.Votes {
+		if target == from {
+			u.Votes[voter] = to
+		}
+	}
+}
+
+func (ug *UnoGame) Substitute(from, to int64) {
+	replaceValue(ug.Players, int(from), int(to))
+	for _, p := range ug.Seats {
+		if p.ID == int(from) {
+			p.ID = int(to)
+			p.Name = playerName(to)
+		}
+	}
+}
+
+func (game *Mahjong) Substitute(from, to int64) {
+	replaceValue(game.PlayerIDs, int(from), int(to))
+	for _, p := range game.Seats {
+		if p.ID == from {
+			p.ID = to
+			p.Name = playerName(to)
+		}
+	}
+	if game.Room != nil && game.Room.Banker == int(from) {
+		game.Room.Banker = int(to)
+	}
+}
+
+// swapRequested 处理对局中玩家输入的 swap 指令，其余输入照常交给状态机
+func (p *Player) swapRequested(pack *protocol.Packet) bool {
+	if !strings.EqualFold(strings.TrimSpace(pack.String()), "swap") {
+		return false
+	}
+	room := getRoom(p.RoomID)
+	if room == nil || room.State != consts.RoomStateRunning || p.Role == RoleSpectator {
+		return false
+	}
+	if err := RequestSwap(p); err != nil {
+		_ = p.WriteError(err)
+		return true
+	}
+	_ = p.WriteString("Your seat will be handed over at your next turn.\n")
+	return true
+}
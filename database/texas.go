@@ -1,7 +1,12 @@
 package database
 
 import (
+	"math"
+	"sort"
+	"time"
+
 	"github.com/ratel-online/core/model"
+	"github.com/ratel-online/server/consts"
 )
 
 type Texas struct {
@@ -14,9 +19,73 @@ type Texas struct {
 	Board        model.Pokers   `json:"board"`
 	MaxBetAmount uint           `json:"maxBetAmount"`
 	MaxBetPlayer *TexasPlayer   `json:"maxBetPlayer"`
+	LastRaise    uint           `json:"lastRaise"` // 这一轮上一次完整加注的大小，下一次加注至少为这么多
+	Raises       int            `json:"raises"`    // 这一轮完整的下注和加注次数
 	Round        string         `json:"round"`
 	Folded       int            `json:"folded"`
 	AllIn        int            `json:"allIn"`
+	SmallBlind   uint           `json:"smallBlind"`
+	BigBlind     uint           `json:"bigBlind"`
+	Ante         uint           `json:"ante"`
+	Stack        uint           `json:"stack"`     // 起始筹码，为 0 时使用玩家余额
+	Level        int            `json:"level"`     // 当前盲注级别，从 0 开始
+	Hands        int            `json:"hands"`     // 已经开始的手数
+	StartTime    time.Time      `json:"startTime"` // 第一手开始的时间，用于按时间升级盲注
+}
+
+// Stacked 是否使用独立的起始筹码，筹码输光的玩家被淘汰
+func (g *Texas) Stacked() bool {
+	return g.Stack > 0
+}
+
+// UpdateBlinds 根据已进行的手数或时间计算盲注级别，每升一级盲注和前注翻倍。
+// 最多升到 consts.TexasMaxBlindLevel 级，锦标赛中大盲注达到起始筹码后不再升级
+func (g *Texas) UpdateBlinds() {
+	level := 0
+	if g.Room.BlindLevelHands > 0 {
+		level = (g.Hands - 1) / g.Room.BlindLevelHands
+	} else if g.Room.BlindLevelTime > 0 {
+		level = int(time.Since(g.StartTime) / g.Room.BlindLevelTime)
+	}
+	level = min(level, consts.TexasMaxBlindLevel)
+	for level > 0 && (max(g.Room.BigBlind, g.Room.Ante) > math.MaxUint>>level ||
+		(g.Room.Stack > 0 && g.Room.BigBlind<<(level-1) >= g.Room.Stack)) {
+		level--
+	}
+	g.Level = level
+	g.SmallBlind = g.Room.SmallBlind << level
+	g.BigBlind = g.Room.BigBlind << level
+	g.Ante = g.Room.Ante << level
+}
+
+// Remaining 还有筹码的玩家
+func (g *Texas) Remaining() []*TexasPlayer {
+	players := make([]*TexasPlayer, 0)
+	for _, p := range g.Players {
+		if !p.Out {
+			players = append(players, p)
+		}
+	}
+	return players
+}
+
+// NextSeat 返回 i 之后（包含 i）第一个未被淘汰的座位
+func (g *Texas) NextSeat(i int) int {
+	for j := 0; j < len(g.Players); j++ {
+		idx := (i + j) % len(g.Players)
+		if !g.Players[idx].Out {
+			return idx
+		}
+	}
+	return i % len(g.Players)
+}
+
+func (g *Texas) Stop() {
+	if g != nil {
+		for _, p := range g.Players {
+			wake(p.State)
+		}
+	}
 }
 
 func (g *Texas) Clean() {
@@ -61,6 +130,8 @@ func (g *Texas) BBPlayer() *TexasPlayer {
 	return g.Players[g.BB]
 }
 
+// Bet 玩家行动并投入筹码。加注的大小不少于上一次完整加注时重新计算最小加注，
+// 不足的全下只提高跟注的数量，不会让已经行动过的玩家重新获得加注的权利
 func (g *Texas) Bet(player *TexasPlayer, amount uint) {
 	if amount > 0 {
 		player.Bet(amount)
@@ -74,32 +145,167 @@ func (g *Texas) Bet(player *TexasPlayer, amount uint) {
 		g.MaxBetPlayer = player
 	}
 	if player.Bets > g.MaxBetAmount {
+		if raise := player.Bets - g.MaxBetAmount; raise >= g.LastRaise {
+			g.LastRaise = raise
+			g.Raises++
+		}
 		g.MaxBetAmount = player.Bets
 		g.MaxBetPlayer = player
 	}
+	player.Acted = true
+	player.ActedRaises = g.Raises
+}
+
+// NewRound 开始新一轮下注，最小加注恢复为一个大盲注
+func (g *Texas) NewRound() {
+	g.MaxBetPlayer = nil
+	g.LastRaise = g.BigBlind
+	g.Raises = 0
+	for _, p := range g.Players {
+		p.Acted = false
+		p.ActedRaises = 0
+	}
+}
+
+// LimitBet 固定限注每次下注或加注的大小，转牌和河牌圈加倍
+func (g *Texas) LimitBet() uint {
+	if g.Round == "turn" || g.Round == "river" {
+		return g.BigBlind * 2
+	}
+	return g.BigBlind
+}
+
+// RaiseRange 玩家这次加注需要投入的筹码范围（包括跟注的部分），筹码不足最小加注时只能全下。
+// 跟注后已经没有筹码、其他玩家都不能再下注、固定限注已到加注上限，
+// 或者玩家行动后只遇到不足的全下（没有重新开放加注）时不能加注
+func (g *Texas) RaiseRange(player *TexasPlayer) (uint, uint, bool) {
+	minCall := g.MaxBetAmount - player.Bets
+	stack := player.Amount()
+	if stack <= minCall {
+		return 0, 0, false
+	}
+	if player.Acted && player.ActedRaises == g.Raises {
+		return 0, 0, false
+	}
+	others := false
+	for _, p := range g.Players {
+		if p != player && !p.Folded && !p.AllIn {
+			others = true
+		}
+	}
+	if !others {
+		return 0, 0, false
+	}
+	var minRaise, maxRaise uint
+	switch g.Room.BettingLimit {
+	case consts.TexasFixedLimit:
+		if g.Raises >= consts.TexasRaiseCap {
+			return 0, 0, false
+		}
+		minRaise = minCall + g.LimitBet()
+		maxRaise = minRaise
+	case consts.TexasPotLimit:
+		minRaise = minCall + g.LastRaise
+		// 先跟注，再加注底池的大小
+		maxRaise = minCall + g.Pot + minCall
+	default:
+		minRaise = minCall + g.LastRaise
+		maxRaise = stack
+	}
+	maxRaise = min(maxRaise, stack)
+	minRaise = min(minRaise, maxRaise)
+	return minRaise, maxRaise, true
+}
+
+// RoundEnd 这一轮下注是否结束：还能下注的玩家都已经行动过，并且跟上了最大的下注
+func (g *Texas) RoundEnd() bool {
+	for _, p := range g.Players {
+		if p.Folded || p.AllIn {
+			continue
+		}
+		if p.Bets < g.MaxBetAmount || (!p.Acted && g.bettors() > 1) {
+			return false
+		}
+	}
+	return true
 }
 
-func (g *Texas) RoundEnd(currentPlayerId int64) bool {
-	if g.AllIn == len(g.Players) ||
-		g.AllIn+g.Folded == len(g.Players) ||
-		(g.MaxBetPlayer != nil && g.MaxBetPlayer.ID == currentPlayerId) {
-		return true
+// bettors 还能下注的玩家数量
+func (g *Texas) bettors() int {
+	n := 0
+	for _, p := range g.Players {
+		if !p.Folded && !p.AllIn {
+			n++
+		}
 	}
-	// all players except one folded or allin
-	if g.AllIn+g.Folded == len(g.Players)-1 {
-		isEnd := true
+	return n
+}
+
+// TexasPot 底池，Players 为有资格赢得该底池的玩家
+type TexasPot struct {
+	Amount  uint           `json:"amount"`
+	Players []*TexasPlayer `json:"players"`
+}
+
+// Pots 根据每个玩家的累计下注拆分主池和边池，第一个为主池
+func (g *Texas) Pots() []*TexasPot {
+	levels := make([]uint, 0)
+	seen := map[uint]bool{}
+	for _, p := range g.Players {
+		if !p.Folded && !seen[p.Bets] {
+			seen[p.Bets] = true
+			levels = append(levels, p.Bets)
+		}
+	}
+	sort.Slice(levels, func(i, j int) bool {
+		return levels[i] < levels[j]
+	})
+
+	pots := make([]*TexasPot, 0)
+	prev := uint(0)
+	for _, level := range levels {
+		pot := &TexasPot{}
 		for _, p := range g.Players {
-			if p.Folded {
-				continue
-			}
-			if p.Bets != g.MaxBetAmount {
-				isEnd = false
-				break
+			pot.Amount += min(p.Bets, level) - min(p.Bets, prev)
+			if !p.Folded && p.Bets >= level {
+				pot.Players = append(pot.Players, p)
 			}
 		}
-		return isEnd
+		prev = level
+		if pot.Amount > 0 {
+			pots = append(pots, pot)
+		}
 	}
-	return false
+	// 弃牌玩家超过最高有效下注的部分并入最后一个底池
+	extra := uint(0)
+	for _, p := range g.Players {
+		extra += p.Bets - min(p.Bets, prev)
+	}
+	if extra > 0 && len(pots) > 0 {
+		pots[len(pots)-1].Amount += extra
+	}
+	return pots
+}
+
+// Split 将底池平分给赢家，除不尽的筹码从小盲位开始按座位顺序每人多分一个
+func (g *Texas) Split(amount uint, winners []*TexasPlayer) map[int64]uint {
+	shares := map[int64]uint{}
+	if len(winners) == 0 {
+		return shares
+	}
+	each := amount / uint(len(winners))
+	rest := amount % uint(len(winners))
+	for _, w := range winners {
+		shares[w.ID] = each
+	}
+	for i := 0; rest > 0 && i < len(g.Players); i++ {
+		p := g.Players[(g.SB+i)%len(g.Players)]
+		if _, ok := shares[p.ID]; ok {
+			shares[p.ID]++
+			rest--
+		}
+	}
+	return shares
 }
 
 type TexasPlayer struct {
@@ -110,25 +316,53 @@ type TexasPlayer struct {
 	Bets   uint         `json:"bets"`
 	Folded bool         `json:"folded"`
 	AllIn  bool         `json:"allIn"`
+	// Stacked 为 true 时使用 Chips 中的独立筹码，否则使用玩家余额
+	Stacked bool `json:"stacked"`
+	Chips   uint `json:"chips"`
+	// Out 筹码输光后被淘汰，不再参与发牌和下注
+	Out bool `json:"out"`
+	// Acted 这一轮是否已经行动过，ActedRaises 为行动时这一轮的加注次数
+	Acted       bool `json:"acted"`
+	ActedRaises int  `json:"actedRaises"`
+	// Left 这一手中途换过人，座位上的下注属于换下的玩家，结算时不计入接替玩家的战绩
+	Left bool `json:"left"`
+	// EquityKey 训练模式下已经模拟过的胜率对应的公共牌数和对手人数，同一条街不重复模拟
+	EquityKey int     `json:"-"`
+	Equity    float64 `json:"-"`
 }
 
 func (p *TexasPlayer) Reset() {
 	p.Bets = 0
-	p.Folded = false
+	p.Folded = p.Out
 	p.AllIn = false
+	p.Acted = false
+	p.ActedRaises = 0
+	p.EquityKey = 0
+	p.Left = false
 	p.Hand = nil
 	p.State = make(chan int, 1)
 }
 
 func (p *TexasPlayer) Amount() uint {
+	if p.Stacked {
+		return p.Chips
+	}
 	return GetPlayer(p.ID).Amount
 }
 
 func (p *TexasPlayer) Bet(amount uint) {
 	p.Bets += amount
+	if p.Stacked {
+		p.Chips -= amount
+		return
+	}
 	GetPlayer(p.ID).Amount -= amount
 }
 
 func (p *TexasPlayer) Add(amount uint) {
+	if p.Stacked {
+		p.Chips += amount
+		return
+	}
 	GetPlayer(p.ID).Amount += amount
 }
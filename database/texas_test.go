@@ -0,0 +1,128 @@
+package database
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ratel-online/server/consts"
+)
+
+func TestTexasPots(t *testing.T) {
+	a := &TexasPlayer{ID: 1, Bets: 100, AllIn: true}
+	b := &TexasPlayer{ID: 2, Bets: 300}
+	c := &TexasPlayer{ID: 3, Bets: 300}
+	d := &TexasPlayer{ID: 4, Bets: 50, Folded: true}
+	game := &Texas{Players: []*TexasPlayer{a, b, c, d}}
+
+	pots := game.Pots()
+	if len(pots) != 2 {
+		t.Fatalf("expected 2 pots, got %d", len(pots))
+	}
+	if pots[0].Amount != 350 || len(pots[0].Players) != 3 {
+		t.Errorf("main pot: amount %d, players %d", pots[0].Amount, len(pots[0].Players))
+	}
+	if pots[1].Amount != 400 || len(pots[1].Players) != 2 {
+		t.Errorf("side pot: amount %d, players %d", pots[1].Amount, len(pots[1].Players))
+	}
+}
+
+func TestTexasSplit(t *testing.T) {
+	a := &TexasPlayer{ID: 1}
+	b := &TexasPlayer{ID: 2}
+	c := &TexasPlayer{ID: 3}
+	game := &Texas{Players: []*TexasPlayer{a, b, c}, SB: 2}
+
+	shares := game.Split(101, []*TexasPlayer{a, b})
+	// 小盲位是 c，按座位顺序 a 先拿到多出的筹码
+	if shares[1] != 51 || shares[2] != 50 {
+		t.Errorf("unexpected shares %v", shares)
+	}
+}
+
+func TestTexasRaiseRange(t *testing.T) {
+	a := &TexasPlayer{ID: 1, Stacked: true, Chips: 1000}
+	b := &TexasPlayer{ID: 2, Stacked: true, Chips: 130}
+	c := &TexasPlayer{ID: 3, Stacked: true, Chips: 1000}
+	game := &Texas{Players: []*TexasPlayer{a, b, c}, Room: &Room{}, BigBlind: 20, Round: "flop"}
+	game.NewRound()
+
+	// a 下注 50，最小加注为再加 50
+	game.Bet(a, 50)
+	if lo, hi, ok := game.RaiseRange(c); !ok || lo != 100 || hi != 1000 {
+		t.Errorf("no-limit range %d-%d %v", lo, hi, ok)
+	}
+	// b 全下 130 多出 80，不少于上一次加注，算作完整的加注
+	game.Bet(b, 130)
+	if game.LastRaise != 80 || game.Raises != 2 {
+		t.Errorf("last raise %d, raises %d", game.LastRaise, game.Raises)
+	}
+
+	// 不足的全下不会重新开放加注
+	a = &TexasPlayer{ID: 1, Stacked: true, Chips: 1000}
+	b = &TexasPlayer{ID: 2, Stacked: true, Chips: 70}
+	c = &TexasPlayer{ID: 3, Stacked: true, Chips: 1000}
+	game = &Texas{Players: []*TexasPlayer{a, b, c}, Room: &Room{}, BigBlind: 20, Round: "flop"}
+	game.NewRound()
+	game.Bet(a, 50)
+	game.Bet(b, 70)
+	game.Bet(c, 70)
+	if _, _, ok := game.RaiseRange(a); ok {
+		t.Error("short all-in should not re-open the betting")
+	}
+	if game.RoundEnd() {
+		t.Error("a still has to call the short all-in")
+	}
+	game.Bet(a, 20)
+	if !game.RoundEnd() {
+		t.Error("round should end after a calls")
+	}
+
+	// 底池限注：跟注后再加底池的大小
+	a = &TexasPlayer{ID: 1, Stacked: true, Chips: 1000}
+	b = &TexasPlayer{ID: 2, Stacked: true, Chips: 1000}
+	game = &Texas{Players: []*TexasPlayer{a, b}, Room: &Room{BettingLimit: consts.TexasPotLimit}, BigBlind: 20, Pot: 100, Round: "turn"}
+	game.NewRound()
+	game.Bet(a, 40)
+	if lo, hi, ok := game.RaiseRange(b); !ok || lo != 80 || hi != 220 {
+		t.Errorf("pot-limit range %d-%d %v", lo, hi, ok)
+	}
+
+	// 固定限注：转牌圈每次加注两个大盲注，最多加注 TexasRaiseCap 次
+	game.Room.BettingLimit = consts.TexasFixedLimit
+	game.NewRound()
+	for i := 0; i < consts.TexasRaiseCap; i++ {
+		p := game.Players[i%2]
+		lo, hi, ok := game.RaiseRange(p)
+		if !ok || lo != hi || lo != game.MaxBetAmount-p.Bets+40 {
+			t.Fatalf("fixed-limit raise %d: %d-%d %v", i, lo, hi, ok)
+		}
+		game.Bet(p, lo)
+	}
+	if _, _, ok := game.RaiseRange(game.Players[0]); ok {
+		t.Error("fixed-limit raises should be capped")
+	}
+}
+
+func TestTexasUpdateBlinds(t *testing.T) {
+	room := &Room{SmallBlind: 10, BigBlind: 20, Ante: 5, BlindLevelHands: 1}
+	game := &Texas{Room: room, Hands: 1000}
+	game.UpdateBlinds()
+	if game.Level != consts.TexasMaxBlindLevel || game.BigBlind != 20<<consts.TexasMaxBlindLevel {
+		t.Errorf("level %d, big blind %d", game.Level, game.BigBlind)
+	}
+
+	// 大盲注达到起始筹码后不再升级
+	room.Stack = 1500
+	game.UpdateBlinds()
+	if game.BigBlind != 2560 || game.SmallBlind != 1280 || game.Ante != 640 {
+		t.Errorf("stacked blinds %d/%d ante %d", game.SmallBlind, game.BigBlind, game.Ante)
+	}
+
+	// 盲注很大时不能溢出
+	room.Stack = 0
+	room.BigBlind = math.MaxUint / 4
+	game.UpdateBlinds()
+	if game.Level != 2 || game.BigBlind != math.MaxUint/4*4 {
+		t.Errorf("level %d, big blind %d", game.Level, game.BigBlind)
+	}
+}
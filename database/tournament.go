@@ -0,0 +1,596 @@
+package database
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/awesome-cap/hashmap"
+	"github.com/ratel-online/core/log"
+	modelx "github.com/ratel-online/core/model"
+	"github.com/ratel-online/server/consts"
+)
+
+var tournamentIds int64 = 0
+var tournaments = hashmap.New()
+var tableTournaments = hashmap.New() // 牌桌房间 id 到所属锦标赛
+
+// tableStarter 为牌桌补齐机器人并开局，机器人的状态机在 state 包中，由 state 包注册
+var tableStarter func(room *Room, robots int) error
+
+func SetTableStarter(starter func(room *Room, robots int) error) {
+	tableStarter = starter
+}
+
+// Standing 锦标赛中一名玩家的成绩
+type Standing struct {
+	PlayerID int64  `json:"playerId"`
+	Name     string `json:"name"`
+	Wins     int    `json:"wins"`
+	Chips    uint   `json:"chips,omitempty"` // 德州扑克的剩余筹码
+	Rank     int    `json:"rank,omitempty"`  // 淘汰或夺冠后的名次，仍在比赛中为 0
+}
+
+// Tournament 锦标赛，报名结束后按牌桌人数分桌，每轮所有牌桌结束后晋级胜者，德州扑克则并桌和平衡牌桌
+type Tournament struct {
+	sync.Mutex
+
+	ID        int64
+	Type      int
+	Organizer int64
+	State     int
+	Round     int
+	Players   []int64
+	Standings map[int64]*Standing
+
+	tables  map[int64]bool // 本轮的牌桌
+	results map[int64]*tableResult
+}
+
+type tableResult struct {
+	winners []int64
+	chips   map[int64]uint
+}
+
+// TournamentInfo 锦标赛的概况和排名
+type TournamentInfo struct {
+	modelx.Data
+	ID        int64      `json:"id"`
+	Type      int        `json:"type"`
+	State     string     `json:"state"`
+	Round     int        `json:"round"`
+	Organizer int64      `json:"organizer"`
+	Players   int        `json:"players"`
+	Tables    []int64    `json:"tables"`
+	Standings []Standing `json:"standings"`
+}
+
+func (info TournamentInfo) String() string {
+	buf := bytes.Buffer{}
+	buf.WriteString(fmt.Sprintf("Tournament %d, %s, %s, round %d\n", info.ID, consts.GameTypes[info.Type], info.State, info.Round))
+	buf.WriteString(fmt.Sprintf("%-6s%-20s%-6s%-10s\n", "Rank", "Name", "Wins", "Chips"))
+	for _, s := range info.Standings {
+		rank := "-"
+		if s.Rank > 0 {
+			rank = fmt.Sprint(s.Rank)
+		}
+		buf.WriteString(fmt.Sprintf("%-6s%-20s%-6d%-10d\n", rank, s.Name, s.Wins, s.Chips))
+	}
+	return buf.String()
+}
+
+// CreateTournament 创建锦标赛，组织者自动报名
+func CreateTournament(organizer *Player, gameType int) (*Tournament, error) {
+	if _, ok := consts.TournamentTables[gameType]; !ok {
+		return nil, consts.ErrorsGameTypeInvalid
+	}
+	t := &Tournament{
+		ID:        atomic.AddInt64(&tournamentIds, 1),
+		Type:      gameType,
+		Organizer: organizer.ID,
+		State:     consts.TournamentStateRegistering,
+		Players:   []int64{organizer.ID},
+		Standings: map[int64]*Standing{},
+		tables:    map[int64]bool{},
+		results:   map[int64]*tableResult{},
+	}
+	tournaments.Set(t.ID, t)
+	log.Infof("tournament %d created by %d, type %s\n", t.ID, organizer.ID, consts.GameTypes[gameType])
+	return t, nil
+}
+
+func GetTournaments() []*Tournament {
+	list := make([]*Tournament, 0)
+	tournaments.Foreach(func(e *hashmap.Entry) {
+		list = append(list, e.Value().(*Tournament))
+	})
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+func GetTournament(id int64) *Tournament {
+	if v, ok := tournaments.Get(id); ok {
+		return v.(*Tournament)
+	}
+	return nil
+}
+
+// PlayerTournament 玩家正在参加（包括已被淘汰）的进行中的锦标赛
+func PlayerTournament(playerId int64) *Tournament {
+	for _, t := range GetTournaments() {
+		t.Lock()
+		_, ok := t.Standings[playerId]
+		running := t.State == consts.TournamentStateRunning
+		t.Unlock()
+		if ok && running {
+			return t
+		}
+	}
+	return nil
+}
+
+// Unregister 退出所有还在报名中的锦标赛
+func Unregister(playerId int64) {
+	for _, t := range GetTournaments() {
+		t.Unregister(playerId)
+	}
+}
+
+func (t *Tournament) Register(playerId int64) error {
+	t.Lock()
+	defer t.Unlock()
+	if t.State != consts.TournamentStateRegistering {
+		return consts.ErrorsTournamentStarted
+	}
+	if !slices.Contains(t.Players, playerId) {
+		t.Players = append(t.Players, playerId)
+	}
+	return nil
+}
+
+// Unregister 退出报名，组织者退出时由最早报名的玩家接任，没人报名时锦标赛取消
+func (t *Tournament) Unregister(playerId int64) bool {
+	t.Lock()
+	defer t.Unlock()
+	if t.State != consts.TournamentStateRegistering {
+		return false
+	}
+	i := slices.Index(t.Players, playerId)
+	if i < 0 {
+		return false
+	}
+	t.Players = slices.Delete(t.Players, i, i+1)
+	if len(t.Players) == 0 {
+		tournaments.Del(t.ID)
+		log.Infof("tournament %d cancelled\n", t.ID)
+	} else if t.Organizer == playerId {
+		t.Organizer = t.Players[0]
+	}
+	return true
+}
+
+// Start 组织者开赛，只有在锦标赛大厅中等待的报名玩家会被分桌
+func (t *Tournament) Start(playerId int64) error {
+	t.Lock()
+	defer t.Unlock()
+	if t.Organizer != playerId {
+		return consts.ErrorsTournamentOrganizer
+	}
+	if t.State != consts.TournamentStateRegistering {
+		return consts.ErrorsTournamentStarted
+	}
+	if Draining() {
+		return consts.ErrorsServerDraining
+	}
+	ready := make([]int64, 0, len(t.Players))
+	for _, id := range t.Players {
+		p := getPlayer(id)
+		if p != nil && p.IsOnline() && p.RoomID == 0 && p.GetState() == consts.StateTournament {
+			ready = append(ready, id)
+		}
+	}
+	if len(ready) < 2 {
+		return consts.ErrorsTournamentPlayers
+	}
+	t.Players = ready
+	t.State = consts.TournamentStateRunning
+	for _, id := range ready {
+		standing := &Standing{PlayerID: id, Name: getPlayer(id).Name}
+		if t.Type == consts.GameTypeTexas {
+			standing.Chips = consts.TournamentStack
+		}
+		t.Standings[id] = standing
+	}
+	log.Infof("tournament %d started, players %v\n", t.ID, ready)
+	t.startRound(ready)
+	return nil
+}
+
+func (t *Tournament) Info() TournamentInfo {
+	t.Lock()
+	defer t.Unlock()
+	return t.info()
+}
+
+func (t *Tournament) info() TournamentInfo {
+	info := TournamentInfo{
+		ID:        t.ID,
+		Type:      t.Type,
+		State:     consts.TournamentStates[t.State],
+		Round:     t.Round,
+		Organizer: t.Organizer,
+		Players:   len(t.Players),
+		Tables:    make([]int64, 0, len(t.tables)),
+		Standings: make([]Standing, 0, len(t.Standings)),
+	}
+	for id := range t.tables {
+		info.Tables = append(info.Tables, id)
+	}
+	slices.Sort(info.Tables)
+	for _, s := range t.Standings {
+		info.Standings = append(info.Standings, *s)
+	}
+	// 报名阶段列出已报名的玩家
+	if t.State == consts.TournamentStateRegistering {
+		for _, id := range t.Players {
+			if p := getPlayer(id); p != nil {
+				info.Standings = append(info.Standings, Standing{PlayerID: id, Name: p.Name})
+			}
+		}
+	}
+	// 仍在比赛中的玩家排在前面，按筹码和胜场排序，淘汰的玩家按名次排序
+	sort.Slice(info.Standings, func(i, j int) bool {
+		a, b := info.Standings[i], info.Standings[j]
+		if (a.Rank == 0) != (b.Rank == 0) {
+			return a.Rank == 0
+		}
+		if a.Rank != b.Rank {
+			return a.Rank < b.Rank
+		}
+		if a.Chips != b.Chips {
+			return a.Chips > b.Chips
+		}
+		if a.Wins != b.Wins {
+			return a.Wins > b.Wins
+		}
+		return a.PlayerID < b.PlayerID
+	})
+	return info
+}
+
+// publish 向全部参赛玩家发布排名
+func (t *Tournament) publish(msg string) {
+	info := t.info()
+	info.Data = modelx.Data{Code: consts.CodeStandings, Msg: msg + info.String()}
+	for _, id := range t.Players {
+		if p := getPlayer(id); p != nil && p.IsOnline() {
+			_ = p.WriteData(info, info.Msg)
+		}
+	}
+}
+
+// startRound 把存活的玩家随机分到尽量均匀的牌桌上
+func (t *Tournament) startRound(alive []int64) {
+	t.Round++
+	size := consts.TournamentTables[t.Type]
+	rand.Shuffle(len(alive), func(i, j int) {
+		alive[i], alive[j] = alive[j], alive[i]
+	})
+	groups := make([][]int64, (len(alive)+size-1)/size)
+	for i, id := range alive {
+		groups[i%len(groups)] = append(groups[i%len(groups)], id)
+	}
+	for _, group := range groups {
+		t.openTable(group)
+	}
+	t.publish(fmt.Sprintf("Tournament %d round %d, %d players at %d tables, starting in %d seconds...\n", t.ID, t.Round, len(alive), len(groups), int(consts.TournamentDelay.Seconds())))
+	go t.startTables()
+}
+
+func (t *Tournament) openTable(group []int64) *Room {
+	room := CreateRoom(group[0], t.Type)
+	room.MaxPlayers = consts.TournamentTables[t.Type]
+	if t.Type == consts.GameTypeTexas {
+		room.Stack = consts.TournamentStack
+		room.BlindLevelHands = consts.TournamentBlindLevelHands
+		room.Chips = map[int64]uint{}
+		for _, id := range group {
+			room.Chips[id] = t.Standings[id].Chips
+		}
+	}
+	saveRoom(room)
+	for _, id := range group {
+		if err := JoinRoom(room.ID, id); err != nil {
+			log.Error(err)
+		}
+	}
+	t.tables[room.ID] = true
+	tableTournaments.Set(room.ID, t)
+	return room
+}
+
+// startTables 等待玩家进入牌桌后补齐机器人并开局
+func (t *Tournament) startTables() {
+	time.Sleep(consts.TournamentDelay)
+	t.Lock()
+	rooms := make([]*Room, 0, len(t.tables))
+	for id := range t.tables {
+		if room := getRoom(id); room != nil {
+			rooms = append(rooms, room)
+		}
+	}
+	t.Unlock()
+	for _, room := range rooms {
+		if room.State == consts.RoomStateRunning {
+			continue
+		}
+		if err := tableStarter(room, t.robots(room)); err != nil {
+			log.Errorf("tournament %d start table %d error: %v\n", t.ID, room.ID, err)
+		}
+	}
+}
+
+// robots 开局需要补充的机器人数量，斗地主和跑得快需要坐满
+func (t *Tournament) robots(room *Room) int {
+	need := 2
+	switch t.Type {
+	case consts.GameTypeClassic, consts.GameTypeRunFast:
+		need = consts.TournamentTables[t.Type]
+	}
+	return max(need-room.Players, 0)
+}
+
+// Settle 在每局结束后记录房间的结果：更新系列赛比分；锦标赛的牌桌在所有牌桌都结束后进入下一轮
+func Settle(room *Room, winners []int64, chips map[int64]uint) {
+	settleSeries(room, winners)
+	v, ok := tableTournaments.Get(room.ID)
+	if !ok {
+		return
+	}
+	v.(*Tournament).settle(room.ID, &tableResult{winners: winners, chips: chips})
+}
+
+// tableClosed 牌桌房间被解散（如真人玩家都离开了），视为本桌没有胜者
+func tableClosed(roomId int64) {
+	v, ok := tableTournaments.Get(roomId)
+	if !ok {
+		return
+	}
+	tableTournaments.Del(roomId)
+	v.(*Tournament).settle(roomId, &tableResult{})
+}
+
+func (t *Tournament) settle(roomId int64, result *tableResult) {
+	t.Lock()
+	defer t.Unlock()
+	if !t.tables[roomId] {
+		return
+	}
+	if _, ok := t.results[roomId]; ok {
+		return
+	}
+	t.results[roomId] = result
+	if len(t.results) == len(t.tables) {
+		go t.advance()
+	}
+}
+
+func (t *Tournament) advance() {
+	// 等待玩家回到房间
+	time.Sleep(consts.TournamentDelay)
+	t.Lock()
+	defer t.Unlock()
+	if t.State != consts.TournamentStateRunning {
+		return
+	}
+	if t.Type == consts.GameTypeTexas {
+		t.advanceTexas()
+	} else {
+		t.advanceWinners()
+	}
+}
+
+// seated 仍坐在牌桌上的参赛玩家
+func (t *Tournament) seated(roomId int64) []int64 {
+	ids := make([]int64, 0)
+	for id, s := range t.Standings {
+		p := getPlayer(id)
+		if s.Rank == 0 && p != nil && p.RoomID == roomId && p.Role != RoleSpectator {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// advanceWinners 每张牌桌的胜者晋级下一轮，只有机器人获胜的牌桌全员晋级重赛
+func (t *Tournament) advanceWinners() {
+	survivors := make([]int64, 0)
+	for roomId := range t.tables {
+		seated := t.seated(roomId)
+		winners := make([]int64, 0)
+		for _, id := range t.results[roomId].winners {
+			if slices.Contains(seated, id) {
+				winners = append(winners, id)
+				t.Standings[id].Wins++
+			}
+		}
+		if len(winners) == 0 {
+			winners = seated
+		}
+		survivors = append(survivors, winners...)
+	}
+	t.closeTables()
+	t.eliminate(survivors)
+	if len(survivors) <= 1 {
+		t.finish(survivors)
+		return
+	}
+	t.startRound(survivors)
+}
+
+// advanceTexas 德州扑克逐手同步进行，筹码输光的玩家出局，然后并桌、平衡各桌人数后开始下一手
+func (t *Tournament) advanceTexas() {
+	for _, result := range t.results {
+		for id, chips := range result.chips {
+			if s, ok := t.Standings[id]; ok {
+				s.Chips = chips
+			}
+		}
+		for _, id := range result.winners {
+			if s, ok := t.Standings[id]; ok {
+				s.Wins++
+			}
+		}
+	}
+	t.results = map[int64]*tableResult{}
+
+	tables := map[int64][]int64{}
+	survivors := make([]int64, 0)
+	for roomId := range t.tables {
+		if getRoom(roomId) == nil {
+			delete(t.tables, roomId)
+			continue
+		}
+		for _, id := range t.seated(roomId) {
+			if t.Standings[id].Chips > 0 {
+				tables[roomId] = append(tables[roomId], id)
+				survivors = append(survivors, id)
+			}
+		}
+	}
+	t.eliminate(survivors)
+	if len(survivors) <= 1 {
+		t.closeTables()
+		t.finish(survivors)
+		return
+	}
+
+	size := consts.TournamentTables[t.Type]
+	for roomId := range t.tables {
+		if len(tables[roomId]) == 0 {
+			t.closeTable(roomId)
+		}
+	}
+	// 并桌：人数最少的牌桌拆散到其余牌桌
+	for len(tables) > (len(survivors)+size-1)/size {
+		from := t.smallestTable(tables, 0)
+		for _, id := range tables[from] {
+			to := t.smallestTable(tables, from)
+			t.move(id, from, to)
+			tables[to] = append(tables[to], id)
+		}
+		delete(tables, from)
+		t.closeTable(from)
+	}
+	// 平衡：各桌人数相差不超过 1
+	for {
+		from, to := t.largestTable(tables), t.smallestTable(tables, 0)
+		if len(tables[from])-len(tables[to]) <= 1 {
+			break
+		}
+		id := tables[from][len(tables[from])-1]
+		tables[from] = tables[from][:len(tables[from])-1]
+		t.move(id, from, to)
+		tables[to] = append(tables[to], id)
+	}
+	for roomId, ids := range tables {
+		if room := getRoom(roomId); room != nil {
+			room.Chips = map[int64]uint{}
+			for _, id := range ids {
+				room.Chips[id] = t.Standings[id].Chips
+			}
+		}
+	}
+	t.Round++
+	t.publish(fmt.Sprintf("Tournament %d hand %d, %d players at %d tables, starting in %d seconds...\n", t.ID, t.Round, len(survivors), len(tables), int(consts.TournamentDelay.Seconds())))
+	go t.startTables()
+}
+
+func (t *Tournament) smallestTable(tables map[int64][]int64, exclude int64) int64 {
+	var target int64
+	for roomId, ids := range tables {
+		if roomId == exclude {
+			continue
+		}
+		if target == 0 || len(ids) < len(tables[target]) || (len(ids) == len(tables[target]) && roomId < target) {
+			target = roomId
+		}
+	}
+	return target
+}
+
+func (t *Tournament) largestTable(tables map[int64][]int64) int64 {
+	var target int64
+	for roomId, ids := range tables {
+		if target == 0 || len(ids) > len(tables[target]) || (len(ids) == len(tables[target]) && roomId < target) {
+			target = roomId
+		}
+	}
+	return target
+}
+
+// move 玩家换桌，带着筹码坐到新的牌桌
+func (t *Tournament) move(playerId, from, to int64) {
+	LeaveRoom(from, playerId)
+	if err := JoinRoom(to, playerId); err != nil {
+		log.Error(err)
+		return
+	}
+	if p := getPlayer(playerId); p != nil {
+		_ = p.WriteString(fmt.Sprintf("You have been moved to table %d.\n", to))
+	}
+}
+
+// eliminate 未晋级的玩家出局，并列同一名次
+func (t *Tournament) eliminate(survivors []int64) {
+	rank := len(survivors) + 1
+	for id, s := range t.Standings {
+		if s.Rank != 0 || slices.Contains(survivors, id) {
+			continue
+		}
+		s.Rank = rank
+		if p := getPlayer(id); p != nil {
+			if t.tables[p.RoomID] {
+				LeaveRoom(p.RoomID, id)
+			}
+			_ = p.WriteString(fmt.Sprintf("You have been eliminated from tournament %d, rank %d.\n", t.ID, rank))
+		}
+	}
+}
+
+// closeTables 本轮结束，参赛玩家全部离开牌桌
+func (t *Tournament) closeTables() {
+	for roomId := range t.tables {
+		t.closeTable(roomId)
+	}
+	t.results = map[int64]*tableResult{}
+}
+
+func (t *Tournament) closeTable(roomId int64) {
+	delete(t.tables, roomId)
+	tableTournaments.Del(roomId)
+	for _, id := range t.seated(roomId) {
+		LeaveRoom(roomId, id)
+	}
+}
+
+func (t *Tournament) finish(champions []int64) {
+	t.State = consts.TournamentStateFinished
+	msg := fmt.Sprintf("Tournament %d finished!\n", t.ID)
+	if len(champions) == 1 {
+		t.Standings[champions[0]].Rank = 1
+		msg = fmt.Sprintf("Tournament %d finished, %s is the champion!\n", t.ID, t.Standings[champions[0]].Name)
+	}
+	log.Infof("tournament %d finished, champions %v\n", t.ID, champions)
+	t.publish(msg)
+	time.AfterFunc(consts.TournamentRetention, func() {
+		tournaments.Del(t.ID)
+	})
+}
@@ -36,6 +36,14 @@ type Undercover struct {
 }
 
 // Clean 清理游戏资源
+func (u *Undercover) Stop() {
+	if u != nil {
+		for _, state := range u.States {
+			wake(state)
+		}
+	}
+}
+
 func (u *Undercover) Clean() {
 	if u != nil {
 		for _, state := range u.States {
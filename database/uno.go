@@ -3,26 +3,247 @@ package database
 import (
 	"bytes"
 	"fmt"
+	"regexp"
+	"slices"
 	"strings"
+	"sync"
 
 	"github.com/feel-easy/uno/card"
 	"github.com/feel-easy/uno/card/color"
 	"github.com/feel-easy/uno/event"
 	"github.com/feel-easy/uno/game"
 	"github.com/ratel-online/core/log"
+	"github.com/ratel-online/core/protocol"
 	"github.com/ratel-online/server/consts"
+	"github.com/ratel-online/server/robot"
 )
 
 type UnoGame struct {
-	Room    *Room            `json:"room"`
-	Players []int            `json:"players"`
-	States  map[int]chan int `json:"states"`
-	Game    *game.Game       `json:"game"`
+	sync.Mutex
+	Room      *Room            `json:"room"`
+	Players   []int            `json:"players"`
+	Seats     []*UnoPlayer     `json:"seats"`
+	States    map[int]chan int `json:"states"` // 按座位区分
+	Game      *game.Game       `json:"game"`
+	Penalty   int              `json:"penalty"`   // 下一位玩家要罚摸的张数，叠加时累计
+	Challenge *UnoChallenge    `json:"challenge"` // 下一位玩家可以质疑的 +4
+	calls     []unoCall        // 等待对局处理的 UNO 和抓人，由 Mutex 保护
+}
+
+// unoCall 玩家不在自己的回合中喊 UNO 或抓人
+type unoCall struct {
+	player *Player
+	catch  bool
+}
+
+// UnoChallenge 打出 +4 的座位，以及出牌时手中是否还有与之前颜色相同的牌
+type UnoChallenge struct {
+	Seat    int  `json:"seat"`
+	Illegal bool `json:"illegal"`
+}
+
+// Seat 玩家所在的座位，牌局中按座位区分玩家，换人后座位不变
+func (ug *UnoGame) Seat(playerId int64) int {
+	for _, p := range ug.Seats {
+		if p.ID == int(playerId) {
+			return p.Seat
+		}
+	}
+	return int(playerId)
+}
+
+// SeatOf 座位上的玩家
+func (ug *UnoGame) SeatOf(seat int) *UnoPlayer {
+	for _, p := range ug.Seats {
+		if p.Seat == seat {
+			return p
+		}
+	}
+	return nil
+}
+
+// Draw 座位上的玩家从牌堆摸牌
+func (ug *UnoGame) Draw(seat, amount int) {
+	ug.Game.Players().GetPlayerController(seat).AddCards(ug.Game.Deck().Draw(amount))
+}
+
+// Playable 座位上的玩家有没有能出的牌
+func (ug *UnoGame) Playable(seat int) bool {
+	top := ug.Game.Pile().Top()
+	return slices.ContainsFunc(ug.Game.GetPlayerCards(seat), func(c card.Card) bool {
+		return game.Playable(c, top)
+	})
+}
+
+// DrawUntilPlayable 一直摸牌直到摸到能出的牌，返回摸牌的张数
+func (ug *UnoGame) DrawUntilPlayable(seat int) int {
+	drawn := 0
+	for !ug.Playable(seat) {
+		drawn++
+		if drawn%100 == 0 {
+			log.Infof("[UnoGame.DrawUntilPlayable] Seat %d drawn: %d\n", seat, drawn)
+		}
+		ug.Draw(seat, 1)
+	}
+	return drawn
+}
+
+// Stackable 有待摸的牌时可以叠加的牌：+2 上可以叠 +2 或 +4，+4 上只能叠 +4，房间没有开启叠加时为空
+func (ug *UnoGame) Stackable(cards []card.Card) []card.Card {
+	if ug.Penalty == 0 || !ug.Room.EnableStackDraws {
+		return nil
+	}
+	_, drawTwo := ug.Game.Pile().Top().(card.DrawTwoCard)
+	stackable := make([]card.Card, 0)
+	for _, c := range cards {
+		if isWildDrawFour(c) {
+			stackable = append(stackable, c)
+		} else if _, ok := c.(card.DrawTwoCard); ok && drawTwo {
+			stackable = append(stackable, c)
+		}
+	}
+	return stackable
+}
+
+// PerformCardActions 执行打出的牌的效果。+2 和 +4 不再让下一位玩家立即摸牌，
+// 而是累计到罚摸张数中，轮到下一位玩家时由他质疑、叠加或者摸牌。previous 为出牌之前桌面上的牌
+func (ug *UnoGame) PerformCardActions(seat int, played, previous card.Card) string {
+	switch played.(type) {
+	case card.DrawTwoCard:
+		ug.Penalty += 2
+		ug.Challenge = nil
+		return ""
+	case card.WildDrawFourCard:
+		p := ug.Game.Players().GetPlayerController(seat)
+		picked := p.PickColor(ug.Game.ExtractState(p))
+		ug.Game.Pile().ReplaceTop(card.NewColoredCard(played, picked))
+		event.ColorPicked.Emit(event.ColorPickedPayload{
+			PlayerName: p.Name(),
+			Color:      picked,
+		})
+		ug.Penalty += 4
+		ug.Challenge = &UnoChallenge{Seat: seat}
+		if previous != nil && previous.Color() != nil {
+			ug.Challenge.Illegal = slices.ContainsFunc(p.Hand(), func(c card.Card) bool {
+				return c.Color() == previous.Color()
+			})
+		}
+		return ""
+	}
+	return ug.Game.PerformCardActions(played)
+}
+
+// Call 玩家随时喊 UNO 或抓人，只放入队列，由对局的协程在下一个回合开始时处理，
+// 手牌只在对局的协程中修改
+func (ug *UnoGame) Call(player *Player, catch bool) {
+	ug.Lock()
+	defer ug.Unlock()
+	ug.calls = append(ug.calls, unoCall{player: player, catch: catch})
+}
+
+// HandleCalls 按顺序处理排队的 UNO 和抓人，在对局的协程中调用
+func (ug *UnoGame) HandleCalls() {
+	ug.Lock()
+	calls := ug.calls
+	ug.calls = nil
+	ug.Unlock()
+	for _, call := range calls {
+		var err error
+		if call.catch {
+			err = ug.Catch(call.player)
+		} else {
+			err = ug.SayUno(call.player)
+		}
+		if err != nil {
+			_ = call.player.WriteError(err)
+		}
+	}
+}
+
+// SayUno 玩家喊 UNO，喊过之后剩一张牌时不会被抓，需要在对局的协程中调用
+func (ug *UnoGame) SayUno(player *Player) error {
+	up := ug.SeatOf(ug.Seat(player.ID))
+	if up == nil || up.ID != int(player.ID) || len(ug.Game.GetPlayerCards(up.Seat)) > 2 {
+		return consts.ErrorsUnoUnavailable
+	}
+	up.Uno = true
+	Broadcast(ug.Room.ID, fmt.Sprintf("%s says UNO!\n", player.Name))
+	Record(ug.Room, player.ID, ActionUno, "says UNO")
+	return nil
+}
+
+// Catch 抓剩一张牌却没有喊 UNO 的玩家，被抓到的玩家罚摸牌，需要在对局的协程中调用
+func (ug *UnoGame) Catch(player *Player) error {
+	caught := make([]*UnoPlayer, 0)
+	for _, up := range ug.Seats {
+		if up.ID != int(player.ID) && !up.Uno && len(ug.Game.GetPlayerCards(up.Seat)) == 1 {
+			// 摸牌后会重新清除，避免同一次被抓两次
+			up.Uno = true
+			caught = append(caught, up)
+		}
+	}
+	if len(caught) == 0 {
+		return consts.ErrorsUnoNobodyToCatch
+	}
+	for _, up := range caught {
+		ug.Draw(up.Seat, consts.UnoCatchPenalty)
+		Broadcast(ug.Room.ID, fmt.Sprintf("%s caught %s not saying UNO, %s draws %d!\n", player.Name, up.Name, up.Name, consts.UnoCatchPenalty))
+		Record(ug.Room, int64(up.ID), ActionUno, fmt.Sprintf("caught by %s, draws %d", player.Name, consts.UnoCatchPenalty))
+	}
+	return nil
+}
+
+// Score 获胜的玩家得到其他玩家手中所有牌的分数，分数在房间中累计，有人达到 UnoTargetScore 时赢得整场比赛并重新计分。返回计分表
+func (ug *UnoGame) Score(winner int64) string {
+	points := 0
+	for _, up := range ug.Seats {
+		if up.ID != int(winner) {
+			points += UnoPoints(ug.Game.GetPlayerCards(up.Seat))
+		}
+	}
+	room := ug.Room
+	if room.UnoPoints == nil {
+		room.UnoPoints = map[int64]int{}
+	}
+	room.UnoPoints[winner] += points
+
+	buf := bytes.Buffer{}
+	buf.WriteString(fmt.Sprintf("%s scores %d points\n", playerName(winner), points))
+	totals := make([]string, 0, len(ug.Players))
+	for _, id := range ug.Players {
+		totals = append(totals, fmt.Sprintf("%s %d", playerName(int64(id)), room.UnoPoints[int64(id)]))
+	}
+	buf.WriteString(fmt.Sprintf("Total: %s (first to %d wins)\n", strings.Join(totals, ", "), consts.UnoTargetScore))
+	if room.UnoPoints[winner] >= consts.UnoTargetScore {
+		buf.WriteString(fmt.Sprintf("%s reaches %d points and wins the match!\n", playerName(winner), room.UnoPoints[winner]))
+		room.UnoPoints = nil
+	}
+	return buf.String()
+}
+
+// UnoPoints 手牌的分数：数字牌为牌面数字，功能牌 20 分，万能牌 50 分
+func UnoPoints(cards []card.Card) int {
+	points := 0
+	for _, c := range cards {
+		switch c := c.(type) {
+		case card.NumberCard:
+			points += c.Number()
+		case card.WildCard, card.WildDrawFourCard:
+			points += 50
+		default:
+			points += 20
+		}
+	}
+	return points
+}
+
+func isWildDrawFour(c card.Card) bool {
+	return c.Equal(card.NewWildDrawFourCard())
 }
 
 func (ug *UnoGame) HavePlay(player *Player) bool {
 	for _, id := range ug.Players {
-		if id == int(player.ID) && player.online {
+		if id == int(player.ID) && player.IsOnline() {
 			return true
 		}
 	}
@@ -33,6 +254,14 @@ func (un *UnoGame) NeedExit() bool {
 	return un.Room.Players <= 1
 }
 
+func (un *UnoGame) Stop() {
+	if un != nil {
+		for _, state := range un.States {
+			wake(state)
+		}
+	}
+}
+
 func (un *UnoGame) Clean() {
 	if un != nil {
 		for _, state := range un.States {
@@ -41,26 +270,55 @@ func (un *UnoGame) Clean() {
 	}
 }
 
+// 卡牌描述中的终端颜色控制符
+var ansiColor = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+// UnoCards 卡牌在事件中的表示，去掉终端颜色控制符
+func UnoCards(cards ...card.Card) []string {
+	list := make([]string, 0, len(cards))
+	for _, c := range cards {
+		list = append(list, ansiColor.ReplaceAllString(c.String(), ""))
+	}
+	return list
+}
+
 type UnoPlayer struct {
 	ID   int    `json:"id"`
 	Name string `json:"name"`
+	Seat int    `json:"seat"` // 开局时玩家的 ID
+	Uno  bool   `json:"uno"`  // 喊过 UNO，摸牌后清除
 }
 
-func NewUnoPlayer(p *Player) game.Player {
+func NewUnoPlayer(p *Player) *UnoPlayer {
 	return &UnoPlayer{
 		ID:   int(p.ID),
 		Name: p.Name,
+		Seat: int(p.ID),
 	}
 }
 
 func (up *UnoPlayer) PlayerID() int {
-	return up.ID
+	return up.Seat
 }
 
 func (up *UnoPlayer) NickName() string {
 	return up.Name
 }
 
+// unoGame 玩家所在的 Uno 对局
+func (up *UnoPlayer) unoGame() *UnoGame {
+	p := getPlayer(int64(up.ID))
+	if p == nil {
+		return nil
+	}
+	room := getRoom(p.RoomID)
+	if room == nil {
+		return nil
+	}
+	ug, _ := room.Game.(*UnoGame)
+	return ug
+}
+
 func contains(cards []card.Card, searchedCard card.Card) bool {
 	for _, card := range cards {
 		if card.Equal(searchedCard) {
@@ -71,6 +329,7 @@ func contains(cards []card.Card, searchedCard card.Card) bool {
 }
 
 func (up *UnoPlayer) NotifyCardsDrawn(cards []card.Card) {
+	up.Uno = false
 	p := getPlayer(int64(up.ID))
 	getPlayer(p.ID).WriteString(fmt.Sprintf("You drew %s!\n", cards))
 }
@@ -90,7 +349,13 @@ func (up *UnoPlayer) OnFirstCardPlayed(payload event.FirstCardPlayedPayload) {
 
 func (up *UnoPlayer) OnCardPlayed(payload event.CardPlayedPayload) {
 	p := getPlayer(int64(up.ID))
-	Broadcast(p.RoomID, fmt.Sprintf("%s played %s!\n", payload.PlayerName, payload.Card))
+	BroadcastEvent(p.RoomID, Event{
+		Code:   consts.CodePlayMade,
+		Msg:    fmt.Sprintf("%s played %s!\n", payload.PlayerName, payload.Card),
+		Player: &EventPlayer{Name: payload.PlayerName},
+		Action: ActionPlay,
+		Cards:  UnoCards(payload.Card),
+	})
 }
 
 func (up *UnoPlayer) OnColorPicked(payload event.ColorPickedPayload) {
@@ -100,7 +365,12 @@ func (up *UnoPlayer) OnColorPicked(payload event.ColorPickedPayload) {
 
 func (up *UnoPlayer) OnPlayerPassed(payload event.PlayerPassedPayload) {
 	p := getPlayer(int64(up.ID))
-	Broadcast(p.RoomID, fmt.Sprintf("%s passed!\n", payload.PlayerName))
+	BroadcastEvent(p.RoomID, Event{
+		Code:   consts.CodePlayMade,
+		Msg:    fmt.Sprintf("%s passed!\n", payload.PlayerName),
+		Player: &EventPlayer{Name: payload.PlayerName},
+		Action: ActionPass,
+	})
 }
 
 func (up *UnoPlayer) PickColor(gameState game.State) color.Color {
@@ -112,6 +382,9 @@ func (up *UnoPlayer) PickColor(gameState game.State) color.Color {
 			log.Infof("[UnoPlayer.PickColor] Player %d loop count: %d\n", up.ID, loopCount)
 		}
 		p = getPlayer(p.ID)
+		if p.IsRobot() && loopCount == 1 {
+			p.Answer(robot.UnoColor(gameState.CurrentPlayerHand))
+		}
 		p.WriteString(fmt.Sprintf(
 			"Select a color: %s, %s, %s or %s ? \n",
 			color.Red,
@@ -132,17 +405,44 @@ func (up *UnoPlayer) PickColor(gameState game.State) color.Color {
 			p.WriteString(fmt.Sprintf("Unknown color '%s' \n", colorName))
 			continue
 		}
+		Record(getRoom(p.RoomID), p.ID, ActionPlay, fmt.Sprintf("picked color %s", chosenColor))
 		return chosenColor
 	}
 }
 
+// Play 选择要出的牌。有待摸的 +2/+4 时只能选择叠加的牌，或者输入 draw 摸牌，此时返回 nil。
+// 出牌的同时可以喊 UNO，如 a uno
 func (up *UnoPlayer) Play(playableCards []card.Card, gameState game.State) (card.Card, error) {
 	p := getPlayer(int64(up.ID))
-	Broadcast(p.RoomID, fmt.Sprintf("It's %s turn! \n", p.Name), p.ID)
+	ug := up.unoGame()
+	penalty := 0
+	if ug != nil && ug.Penalty > 0 {
+		penalty = ug.Penalty
+		playableCards = ug.Stackable(playableCards)
+		if len(playableCards) == 0 {
+			return nil, nil
+		}
+	}
+	BroadcastEvent(p.RoomID, Event{
+		Code:    consts.CodeTurnStarted,
+		Msg:     fmt.Sprintf("It's %s turn! \n", p.Name),
+		Player:  EventPlayerOf(p.ID),
+		Timeout: Timeout(consts.PlayTimeout),
+	}, p.ID)
 	buf := bytes.Buffer{}
 	buf.WriteString(fmt.Sprintf("It's your turn, %s! \n", p.Name))
 	buf.WriteString(gameState.String())
-	p.WriteString(buf.String())
+	if penalty > 0 {
+		buf.WriteString(fmt.Sprintf("You have to draw %d cards unless you stack a draw card! \n", penalty))
+	}
+	p.WriteEvent(Event{
+		Code:    consts.CodeTurnStarted,
+		Msg:     buf.String(),
+		Player:  EventPlayerOf(p.ID),
+		Cards:   UnoCards(gameState.CurrentPlayerHand...),
+		Amount:  uint(penalty),
+		Timeout: Timeout(consts.PlayTimeout),
+	})
 	runeSequence := runeSequence{}
 	cardOptions := make(map[string]card.Card)
 	for _, card := range playableCards {
@@ -153,6 +453,9 @@ func (up *UnoPlayer) Play(playableCards []card.Card, gameState game.State) (card
 	for label, card := range cardOptions {
 		cardSelectionLines = append(cardSelectionLines, fmt.Sprintf("%s %s", label, card))
 	}
+	if penalty > 0 {
+		cardSelectionLines = append(cardSelectionLines, fmt.Sprintf("draw: draw %d cards", penalty))
+	}
 	cardSelectionMessage := strings.Join(cardSelectionLines, " \n ") + " \n "
 	loopCount := 0
 	for {
@@ -161,14 +464,27 @@ func (up *UnoPlayer) Play(playableCards []card.Card, gameState game.State) (card
 			log.Infof("[UnoPlayer.Play] Player %d loop count: %d\n", up.ID, loopCount)
 		}
 		p = getPlayer(p.ID)
+		if p.IsRobot() && loopCount == 1 {
+			p.Answer(string(rune(initialRune + robot.UnoCard(playableCards, gameState.CurrentPlayerHand))))
+		}
 		p.WriteString(cardSelectionMessage)
 		selectedLabel, err := p.AskForString(consts.PlayTimeout)
 		if err != nil {
-			if err == consts.ErrorsTimeout {
-				selectedLabel = "A"
-			} else {
+			if err != consts.ErrorsTimeout {
 				return nil, err
 			}
+			if penalty > 0 {
+				return nil, nil
+			}
+			selectedLabel = "A"
+		}
+		segments := strings.Fields(selectedLabel)
+		if penalty > 0 && len(segments) == 1 && strings.EqualFold(segments[0], "draw") {
+			return nil, nil
+		}
+		sayUno := len(segments) == 2 && strings.EqualFold(segments[1], "uno")
+		if sayUno {
+			selectedLabel = segments[0]
 		}
 		selectedCard, found := cardOptions[strings.ToUpper(selectedLabel)]
 		if !found {
@@ -179,6 +495,40 @@ func (up *UnoPlayer) Play(playableCards []card.Card, gameState game.State) (card
 			p.WriteString(fmt.Sprintf("Cheat detected! Card %s is not in %s's hand! \n", selectedCard, p.Name))
 			continue
 		}
+		if sayUno && ug != nil {
+			if err := ug.SayUno(p); err != nil {
+				_ = p.WriteError(err)
+			}
+		}
 		return selectedCard, nil
 	}
 }
+
+// Challenge 上家打出 +4 后询问是否质疑，质疑成功时上家摸 4 张，失败时自己多摸 UnoChallengePenalty 张
+func (up *UnoPlayer) Challenge(penalty int) bool {
+	p := getPlayer(int64(up.ID))
+	if p.IsRobot() {
+		p.Answer("n")
+	}
+	p.WriteString(fmt.Sprintf("Wild Draw Four! Challenge it? If the player still had a card of the previous color they draw 4, otherwise you draw %d. (y or n) \n", penalty+consts.UnoChallengePenalty))
+	ans, err := p.AskForString(consts.PlayTimeout)
+	return err == nil && strings.EqualFold(strings.TrimSpace(ans), "y")
+}
+
+// unoRequested 处理 Uno 对局中随时可以输入的 uno 和 catch 指令，其余输入照常交给状态机
+func (p *Player) unoRequested(pack *protocol.Packet) bool {
+	signal := strings.ToLower(strings.TrimSpace(pack.String()))
+	if signal != "uno" && signal != "catch" {
+		return false
+	}
+	room := getRoom(p.RoomID)
+	if room == nil || p.Role == RoleSpectator {
+		return false
+	}
+	ug, ok := room.Game.(*UnoGame)
+	if !ok {
+		return false
+	}
+	ug.Call(p, signal == "catch")
+	return true
+}
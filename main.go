@@ -3,11 +3,15 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/ratel-online/core/log"
-	"github.com/ratel-online/core/util/async"
 	"github.com/ratel-online/server/bot"
+	"github.com/ratel-online/server/database"
 	"github.com/ratel-online/server/network"
 )
 
@@ -17,6 +21,10 @@ var (
 	BotAddr  string
 	BotToken string
 	BotGroup int64
+	DataDir  string
+	Guest    bool
+	Admin    string
+	Drain    time.Duration
 )
 
 func main() {
@@ -25,8 +33,23 @@ func main() {
 	flag.StringVar(&BotAddr, "bot", "", "Bot connection address")
 	flag.StringVar(&BotToken, "bot-token", "", "Bot token")
 	flag.Int64Var(&BotGroup, "bot-group", 0, "Bot group ID")
+	flag.StringVar(&DataDir, "data", "", "Data directory, keep data in memory if empty")
+	flag.BoolVar(&Guest, "guest", true, "Allow guests to login without an account")
+	flag.StringVar(&Admin, "admin-token", "", "Admin api token, admin api is disabled if empty")
+	flag.DurationVar(&Drain, "drain", 5*time.Minute, "Max time to wait for running games to finish on shutdown")
 
 	flag.Parse()
+	database.GuestEnabled = Guest
+	network.AdminToken = Admin
+	// 持久化存储
+	if DataDir != "" {
+		store, err := database.NewFileStore(DataDir)
+		if err != nil {
+			log.Panic(fmt.Sprintf("打开数据目录失败: %v", err))
+		}
+		database.SetStore(store)
+		defer store.Close()
+	}
 	// 连接机器人
 	if BotAddr != "" && BotToken != "" && BotGroup != 0 {
 		err := bot.Connect(BotAddr, BotToken, BotGroup)
@@ -43,11 +66,34 @@ func main() {
 		defer bot.Close()
 	}
 
-	async.Async(func() {
-		wsServer := network.NewWebsocketServer(":" + strconv.Itoa(Wsport))
-		log.Panic(wsServer.Serve())
-	})
+	servers := []network.Network{
+		network.NewWebsocketServer(":" + strconv.Itoa(Wsport)),
+		network.NewTcpServer(":" + strconv.Itoa(Tcpport)),
+	}
+	for _, server := range servers {
+		go func(server network.Network) {
+			// 监听失败时直接退出进程
+			if err := server.Serve(); err != nil {
+				log.Panic(err)
+			}
+		}(server)
+	}
 
-	server := network.NewTcpServer(":" + strconv.Itoa(Tcpport))
-	log.Panic(server.Serve())
+	// 收到退出信号后先等待进行中的对局结束，再次收到信号时立即退出
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	<-signals
+	log.Infof("shutting down, waiting up to %s for running games to finish.\n", Drain)
+	go func() {
+		<-signals
+		log.Info("forced shutdown.")
+		os.Exit(1)
+	}()
+	database.Drain(Drain)
+	for _, server := range servers {
+		if err := server.Close(); err != nil {
+			log.Error(err)
+		}
+	}
+	log.Info("server stopped.")
 }
\ No newline at end of file
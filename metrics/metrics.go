@@ -0,0 +1,169 @@
+// Package metrics 运行指标，以 Prometheus 文本格式输出
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	lock     sync.Mutex
+	registry = make([]collector, 0)
+)
+
+type collector interface {
+	collect(buf *bytes.Buffer)
+}
+
+func register(c collector) {
+	lock.Lock()
+	defer lock.Unlock()
+	registry = append(registry, c)
+}
+
+// Counter 只增不减的计数器，按标签值分别计数
+type Counter struct {
+	sync.Mutex
+	name   string
+	help   string
+	labels []string
+	values map[string]float64
+}
+
+// NewCounter 创建并注册计数器，labels 为标签名
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{name: name, help: help, labels: labels, values: map[string]float64{}}
+	if len(labels) == 0 {
+		c.values[""] = 0
+	}
+	register(c)
+	return c
+}
+
+// Inc 计数加一，labelValues 与创建时的标签名一一对应
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) Add(v float64, labelValues ...string) {
+	key := labelString(c.labels, labelValues)
+	c.Lock()
+	c.values[key] += v
+	c.Unlock()
+}
+
+func (c *Counter) collect(buf *bytes.Buffer) {
+	c.Lock()
+	defer c.Unlock()
+	writeHeader(buf, c.name, c.help, "counter")
+	writeValues(buf, c.name, c.values)
+}
+
+// Summary 记录观测值的总和与次数，用于计算平均值
+type Summary struct {
+	sync.Mutex
+	name   string
+	help   string
+	labels []string
+	sums   map[string]float64
+	counts map[string]float64
+}
+
+// NewSummary 创建并注册摘要，labels 为标签名
+func NewSummary(name, help string, labels ...string) *Summary {
+	s := &Summary{name: name, help: help, labels: labels, sums: map[string]float64{}, counts: map[string]float64{}}
+	register(s)
+	return s
+}
+
+// Observe 记录一次观测值
+func (s *Summary) Observe(v float64, labelValues ...string) {
+	key := labelString(s.labels, labelValues)
+	s.Lock()
+	s.sums[key] += v
+	s.counts[key]++
+	s.Unlock()
+}
+
+func (s *Summary) collect(buf *bytes.Buffer) {
+	s.Lock()
+	defer s.Unlock()
+	writeHeader(buf, s.name, s.help, "summary")
+	writeValues(buf, s.name+"_sum", s.sums)
+	writeValues(buf, s.name+"_count", s.counts)
+}
+
+// Gauge 瞬时值，每次输出时调用 fn 重新计算
+type Gauge struct {
+	name   string
+	help   string
+	labels []string
+	fn     func(set func(v float64, labelValues ...string))
+}
+
+// NewGauge 创建并注册瞬时值，fn 通过 set 设置各标签对应的值
+func NewGauge(name, help string, fn func(set func(v float64, labelValues ...string)), labels ...string) *Gauge {
+	g := &Gauge{name: name, help: help, labels: labels, fn: fn}
+	register(g)
+	return g
+}
+
+func (g *Gauge) collect(buf *bytes.Buffer) {
+	values := map[string]float64{}
+	g.fn(func(v float64, labelValues ...string) {
+		values[labelString(g.labels, labelValues)] += v
+	})
+	writeHeader(buf, g.name, g.help, "gauge")
+	writeValues(buf, g.name, values)
+}
+
+func labelString(labels, values []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(labels))
+	for i, label := range labels {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = label + "=" + strconv.Quote(value)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func writeHeader(buf *bytes.Buffer, name, help, typ string) {
+	buf.WriteString(fmt.Sprintf("# HELP %s %s\n", name, help))
+	buf.WriteString(fmt.Sprintf("# TYPE %s %s\n", name, typ))
+}
+
+func writeValues(buf *bytes.Buffer, name string, values map[string]float64) {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		buf.WriteString(fmt.Sprintf("%s%s %s\n", name, k, strconv.FormatFloat(values[k], 'g', -1, 64)))
+	}
+}
+
+// Handler 输出全部指标
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		collectors := append([]collector{}, registry...)
+		lock.Unlock()
+		buf := bytes.Buffer{}
+		for _, c := range collectors {
+			c.collect(&buf)
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = w.Write(buf.Bytes())
+	})
+}
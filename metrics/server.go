@@ -0,0 +1,10 @@
+package metrics
+
+// 服务器运行指标，连接数和房间数等瞬时值由 database 包注册
+var (
+	GamesStarted  = NewCounter("ratel_games_started_total", "Number of games started.", "type")
+	GamesFinished = NewCounter("ratel_games_finished_total", "Number of games finished, including games aborted when the room was closed.", "type")
+	GameDuration  = NewSummary("ratel_game_duration_seconds", "Duration of finished games in seconds.", "type")
+	TurnTimeouts  = NewCounter("ratel_turn_timeouts_total", "Number of player turns that hit the timeout.")
+	StateErrors   = NewCounter("ratel_state_errors_total", "Number of errors caught by the player state machine.", "kind")
+)
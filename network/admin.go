@@ -0,0 +1,161 @@
+package network
+
+import (
+	"crypto/subtle"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ratel-online/core/log"
+	"github.com/ratel-online/core/util/json"
+	"github.com/ratel-online/server/consts"
+	"github.com/ratel-online/server/database"
+)
+
+// AdminToken 管理接口的访问令牌，为空时不开启管理接口
+var AdminToken string
+
+type adminPlayer struct {
+	ID     int64          `json:"id"`
+	Name   string         `json:"name"`
+	IP     string         `json:"ip"`
+	RoomID int64          `json:"roomId"`
+	Role   database.Role  `json:"role"`
+	State  consts.StateID `json:"state"`
+	Online bool           `json:"online"`
+	Robot  bool           `json:"robot"`
+	Guest  bool           `json:"guest"`
+	Amount uint           `json:"amount"`
+}
+
+type adminRoom struct {
+	ID         int64         `json:"id"`
+	Type       string        `json:"type"`
+	State      string        `json:"state"`
+	Creator    int64         `json:"creator"`
+	MaxPlayers int           `json:"maxPlayers"`
+	Players    []adminPlayer `json:"players"`
+	Spectators []adminPlayer `json:"spectators"`
+}
+
+// registerAdmin 注册管理接口，所有请求都需要携带 Authorization: Bearer <token>
+func registerAdmin(mux *http.ServeMux) {
+	if AdminToken == "" {
+		return
+	}
+	mux.HandleFunc("GET /admin/rooms", adminAuth(adminRooms))
+	mux.HandleFunc("DELETE /admin/rooms/{id}", adminAuth(adminCloseRoom))
+	mux.HandleFunc("GET /admin/players", adminAuth(adminPlayers))
+	mux.HandleFunc("POST /admin/players/{id}/kick", adminAuth(adminKickPlayer))
+	mux.HandleFunc("POST /admin/notice", adminAuth(adminNotice))
+	log.Info("admin api enabled on /admin")
+}
+
+func adminAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(AdminToken)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(json.Marshal(v))
+}
+
+func toAdminPlayer(player *database.Player) adminPlayer {
+	return adminPlayer{
+		ID:     player.ID,
+		Name:   player.Name,
+		IP:     player.IP,
+		RoomID: player.RoomID,
+		Role:   player.Role,
+		State:  player.GetState(),
+		Online: player.IsOnline(),
+		Robot:  player.IsRobot(),
+		Guest:  player.Guest,
+		Amount: player.Amount,
+	}
+}
+
+func adminRooms(w http.ResponseWriter, r *http.Request) {
+	list := make([]adminRoom, 0)
+	for _, room := range database.GetRooms() {
+		item := adminRoom{
+			ID:         room.ID,
+			Type:       consts.GameTypes[room.Type],
+			State:      consts.RoomStates[room.State],
+			Creator:    room.Creator,
+			MaxPlayers: room.MaxPlayers,
+			Players:    make([]adminPlayer, 0),
+			Spectators: make([]adminPlayer, 0),
+		}
+		for id := range database.RoomPlayers(room.ID) {
+			if player := database.GetPlayer(id); player != nil {
+				item.Players = append(item.Players, toAdminPlayer(player))
+			}
+		}
+		for id := range database.RoomSpectators(room.ID) {
+			if player := database.GetPlayer(id); player != nil {
+				item.Spectators = append(item.Spectators, toAdminPlayer(player))
+			}
+		}
+		list = append(list, item)
+	}
+	writeJSON(w, list)
+}
+
+func adminCloseRoom(w http.ResponseWriter, r *http.Request) {
+	roomId, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, consts.ErrorsRoomInvalid.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = database.CloseRoom(roomId); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func adminPlayers(w http.ResponseWriter, r *http.Request) {
+	list := make([]adminPlayer, 0)
+	for _, player := range database.OnlinePlayers() {
+		list = append(list, toAdminPlayer(player))
+	}
+	writeJSON(w, list)
+}
+
+func adminKickPlayer(w http.ResponseWriter, r *http.Request) {
+	playerId, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, consts.ErrorsPlayerNotFound.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = database.KickPlayer(playerId); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminNotice 请求体为公告内容
+func adminNotice(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 4096))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	msg := strings.TrimSpace(string(body))
+	if msg == "" {
+		http.Error(w, consts.ErrorsInputInvalid.Error(), http.StatusBadRequest)
+		return
+	}
+	database.Notice(msg)
+	w.WriteHeader(http.StatusNoContent)
+}
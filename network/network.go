@@ -1,6 +1,9 @@
 package network
 
 import (
+	"strings"
+	"time"
+
 	"github.com/ratel-online/core/log"
 	"github.com/ratel-online/core/model"
 	"github.com/ratel-online/core/network"
@@ -9,12 +12,32 @@ import (
 	"github.com/ratel-online/server/consts"
 	"github.com/ratel-online/server/database"
 	"github.com/ratel-online/server/state"
-	"time"
 )
 
 // Network is interface of all kinds of network.
 type Network interface {
 	Serve() error
+	Close() error
+}
+
+// authPacket 登录信息，Token 不为空时尝试恢复断线前的会话；
+// Password 不为空时按账号登录，Register 为 true 时先注册账号，否则按游客登录
+type authPacket struct {
+	model.AuthInfo
+	Token    string `json:"token"`
+	Password string `json:"password"`
+	Register bool   `json:"register"`
+	Mode     string `json:"mode"` // 协议模式，"object" 为结构化消息，默认为文本
+}
+
+// protocolMode 解析客户端协商的协议模式
+func protocolMode(mode string) int {
+	switch mode {
+	case "object", "json":
+		return consts.ModeObject
+	default:
+		return consts.ModeText
+	}
 }
 
 func handle(rwc protocol.ReadWriteCloser) error {
@@ -32,16 +55,57 @@ func handle(rwc protocol.ReadWriteCloser) error {
 		_ = c.Write(protocol.ErrorPacket(err))
 		return err
 	}
-	player := database.Connected(c, authInfo)
-	log.Infof("player auth accessed, ip %s, %d:%s\n", player.IP, player.ID, authInfo.Name)
-	go state.Run(player)
-	defer player.Offline()
+	mode := protocolMode(authInfo.Mode)
+	player := database.Resume(c, authInfo.Token, mode)
+	if player != nil {
+		log.Infof("player session resumed, ip %s, %d:%s\n", player.IP, player.ID, player.Name)
+	} else {
+		guest, err := identify(authInfo)
+		if err != nil {
+			_ = c.Write(protocol.ErrorPacket(err))
+			return err
+		}
+		if !guest {
+			player = database.Takeover(c, authInfo.ID, mode)
+		}
+		if player != nil {
+			log.Infof("player session taken over, ip %s, %d:%s\n", player.IP, player.ID, player.Name)
+		} else {
+			player = database.Connected(c, &authInfo.AuthInfo, guest)
+			player.Mode = mode
+			log.Infof("player auth accessed, ip %s, %d:%s\n", player.IP, player.ID, player.Name)
+			go state.Run(player)
+		}
+	}
+	defer player.Disconnected(c)
 	return player.Listening()
 }
 
+// identify 校验账号或分配游客身份，结果写回 authInfo 的 ID 和 Name
+func identify(authInfo *authPacket) (guest bool, err error) {
+	var id int64
+	var name string
+	switch {
+	case authInfo.Register:
+		id, err = database.Register(authInfo.Name, authInfo.Password)
+		name = authInfo.Name
+	case authInfo.Password != "":
+		id, name, err = database.Login(authInfo.Name, authInfo.Password)
+	default:
+		guest = true
+		id, name, err = database.Guest(authInfo.Name)
+	}
+	if err != nil {
+		return
+	}
+	authInfo.ID = id
+	authInfo.Name = strings.TrimSpace(name)
+	return
+}
+
 // 登陆验签
-func loginAuth(c *network.Conn) (*model.AuthInfo, error) {
-	authChan := make(chan *model.AuthInfo)
+func loginAuth(c *network.Conn) (*authPacket, error) {
+	authChan := make(chan *authPacket)
 	defer close(authChan)
 	async.Async(func() {
 		packet, err := c.Read()
@@ -49,7 +113,7 @@ func loginAuth(c *network.Conn) (*model.AuthInfo, error) {
 			log.Error(err)
 			return
 		}
-		authInfo := &model.AuthInfo{}
+		authInfo := &authPacket{}
 		err = packet.Unmarshal(authInfo)
 		if err != nil {
 			log.Error(err)
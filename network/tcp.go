@@ -1,26 +1,33 @@
 package network
 
 import (
+	"errors"
 	"github.com/ratel-online/core/log"
 	"github.com/ratel-online/core/protocol"
 	"github.com/ratel-online/core/util/async"
 	"net"
+	"sync"
 )
 
 type Tcp struct {
-	addr string
+	addr     string
+	lock     sync.Mutex
+	listener net.Listener
 }
 
-func NewTcpServer(addr string) Tcp {
-	return Tcp{addr: addr}
+func NewTcpServer(addr string) *Tcp {
+	return &Tcp{addr: addr}
 }
 
-func (t Tcp) Serve() error {
+func (t *Tcp) Serve() error {
 	listener, err := net.Listen("tcp", t.addr)
 	if err != nil {
 		log.Error(err)
 		return err
 	}
+	t.lock.Lock()
+	t.listener = listener
+	t.lock.Unlock()
 	log.Infof("Tcp server listening on %s\n", t.addr)
 	loopCount := 0
 	for {
@@ -30,6 +37,9 @@ func (t Tcp) Serve() error {
 		}
 		conn, err := listener.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
 			log.Infof("listener.Accept err %v\n", err)
 			continue
 		}
@@ -41,3 +51,13 @@ func (t Tcp) Serve() error {
 		})
 	}
 }
+
+// Close 停止接受新连接，已建立的连接不受影响
+func (t *Tcp) Close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.listener == nil {
+		return nil
+	}
+	return t.listener.Close()
+}
@@ -1,14 +1,18 @@
 package network
 
 import (
+    "context"
+    "errors"
+    "time"
     "github.com/gorilla/websocket"
     "github.com/ratel-online/core/log"
     "github.com/ratel-online/core/protocol"
+    "github.com/ratel-online/server/metrics"
     "net/http"
 )
 
 type Websocket struct {
-    addr string
+    server *http.Server
 }
 
 var upgrader = websocket.Upgrader{
@@ -19,14 +23,28 @@ var upgrader = websocket.Upgrader{
     },
 }
 
-func NewWebsocketServer(addr string) Websocket {
-    return Websocket{addr: addr}
+func NewWebsocketServer(addr string) *Websocket {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/ws", serveWs)
+    registerAdmin(mux)
+    mux.Handle("/metrics", metrics.Handler())
+    return &Websocket{server: &http.Server{Addr: addr, Handler: mux}}
 }
 
-func (w Websocket) Serve() error {
-    http.HandleFunc("/ws", serveWs)
-    log.Infof("Websocket server listener on %s\n", w.addr)
-    return http.ListenAndServe(w.addr, nil)
+func (w *Websocket) Serve() error {
+    log.Infof("Websocket server listener on %s\n", w.server.Addr)
+    err := w.server.ListenAndServe()
+    if errors.Is(err, http.ErrServerClosed) {
+        return nil
+    }
+    return err
+}
+
+// Close 停止接受新连接，已升级的 WebSocket 连接不受影响
+func (w *Websocket) Close() error {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    return w.server.Shutdown(ctx)
 }
 
 func serveWs(w http.ResponseWriter, r *http.Request) {
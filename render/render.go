@@ -1,35 +1,78 @@
+// Package render 大厅和等待房间的输出，text 模式的客户端收到文本，object 模式的客户端收到结构化消息
 package render
 
 import (
 	"bytes"
 	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+
 	constx "github.com/ratel-online/core/consts"
 	"github.com/ratel-online/core/model"
 	"github.com/ratel-online/server/consts"
 	"github.com/ratel-online/server/database"
 )
 
+// RoomPlayer 房间中的玩家或观众
+type RoomPlayer struct {
+	model.Player
+	Role  string `json:"role"`
+	Robot bool   `json:"robot,omitempty"`
+	IP    string `json:"ip,omitempty"` // 房间开启 ip 显示时才有，已打码
+}
+
+// Setting 房间的一项配置，Key 与 set 指令中的名称一致
+type Setting struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// RoomInfo 房间详情
+type RoomInfo struct {
+	model.Data
+	Room       model.Room           `json:"room"`
+	Players    []RoomPlayer         `json:"players"`
+	Spectators []RoomPlayer         `json:"spectators"`
+	Settings   []Setting            `json:"settings"`
+	Series     *database.SeriesView `json:"series,omitempty"`
+}
+
+type welcome struct {
+	model.Data
+	Token string `json:"token"`
+}
+
 func Welcome(player *database.Player) error {
-	return player.WriteObject(model.Data{
-		Code: constx.CodeWelcome,
-		Msg:  fmt.Sprintf("Hi %s, Welcome to ratel online! \n", player.Name),
-	})
+	buf := bytes.Buffer{}
+	buf.WriteString(fmt.Sprintf("Hi %s, Welcome to ratel online! rules at https://github.com/ratel-online/server/blob/main/README.md\n", player.Name))
+	buf.WriteString(fmt.Sprintf("Your session token is %s, reconnect with it within %d minutes to resume your game.\n", player.Token(), int(consts.SessionGracePeriod.Minutes())))
+	return player.WriteData(welcome{
+		Data:  model.Data{Code: constx.CodeWelcome, Msg: buf.String()},
+		Token: player.Token(),
+	}, buf.String())
 }
 
 func HomeOptions(player *database.Player) error {
+	options := []model.Option{
+		{ID: 1, Name: "Join"},
+		{ID: 2, Name: "New"},
+		{ID: 3, Name: "Replay"},
+		{ID: 4, Name: "Quick match"},
+		{ID: 5, Name: "Tournament"},
+	}
 	buf := bytes.Buffer{}
-	buf.WriteString("1.Join\n")
-	buf.WriteString("2.New\n")
-	return player.WriteObject(model.Options{
+	for _, option := range options {
+		buf.WriteString(fmt.Sprintf("%d.%s\n", option.ID, option.Name))
+	}
+	return player.WriteData(model.Options{
 		Data: model.Data{
 			Code: constx.CodeHomeOptions,
 			Msg:  buf.String(),
 		},
-		Options: []model.Option{
-			{ID: 1, Name: "Join"},
-			{ID: 2, Name: "New"},
-		},
-	})
+		Options: options,
+	}, buf.String())
 }
 
 func GameTypeOptions(player *database.Player) error {
@@ -40,111 +83,377 @@ func GameTypeOptions(player *database.Player) error {
 		buf.WriteString(fmt.Sprintf("%d.%s\n", id, consts.GameTypes[id]))
 		options = append(options, model.Option{ID: id, Name: consts.GameTypes[id]})
 	}
-	return player.WriteObject(model.Options{
+	return player.WriteData(model.Options{
 		Data: model.Data{
 			Code: constx.CodeGameTypeOptions,
 			Msg:  buf.String(),
 		},
 		Options: options,
-	})
+	}, buf.String())
+}
+
+// StakeOptions 快速匹配德州扑克时的盲注级别
+func StakeOptions(player *database.Player) error {
+	buf := bytes.Buffer{}
+	buf.WriteString("Please select stake level\n")
+	options := make([]model.Option, 0)
+	for i, stake := range consts.TexasStakes {
+		name := fmt.Sprintf("%d/%d", stake[0], stake[1])
+		buf.WriteString(fmt.Sprintf("%d.%s\n", i+1, name))
+		options = append(options, model.Option{ID: i + 1, Name: name})
+	}
+	return player.WriteData(model.Options{
+		Data: model.Data{
+			Code: consts.CodeStakes,
+			Msg:  buf.String(),
+		},
+		Options: options,
+	}, buf.String())
 }
 
+// RoomList 房间列表，有密码的房间在类型前加 *
 func RoomList(player *database.Player) error {
+	rooms := database.GetRooms()
 	buf := bytes.Buffer{}
 	buf.WriteString(fmt.Sprintf("%-10s%-10s%-10s%-10s\n", "ID", "Type", "Players", "State"))
-	for _, room := range database.GetRooms() {
-		buf.WriteString(fmt.Sprintf("%-10d%-10s%-10d%-10s\n", room.ID, consts.GameTypes[room.Type], room.Players, consts.RoomStates[room.State]))
-	}
 	modelRooms := make([]model.Room, 0)
-	for _, room := range database.GetRooms() {
+	for _, room := range rooms {
+		pwdFlag := ""
+		if room.Password != "" {
+			pwdFlag = "*"
+		}
+		buf.WriteString(fmt.Sprintf("%-10d%-10s%-10d%-10s\n", room.ID, pwdFlag+consts.GameTypes[room.Type], room.Players, consts.RoomStates[room.State]))
 		modelRooms = append(modelRooms, room.Model())
 	}
-	return player.WriteObject(model.RoomList{
+	return player.WriteData(model.RoomList{
 		Data: model.Data{
 			Code: constx.CodeRoomList,
 			Msg:  buf.String(),
 		},
 		Rooms: modelRooms,
-	})
+	}, buf.String())
+}
+
+type tournamentList struct {
+	model.Data
+	Tournaments []database.TournamentInfo `json:"tournaments"`
 }
 
-func RoomInfo(player *database.Player, room *database.Room) error {
+// TournamentList 锦标赛列表
+func TournamentList(player *database.Player) error {
 	buf := bytes.Buffer{}
-	
-	// 如果游戏正在进行中，显示玩家号码
-	if room.Game != nil {
-		if undercoverGame, ok := room.Game.(*database.Undercover); ok {
-			buf.WriteString(fmt.Sprintf("%-10s%-20s%-10s%-10s\n", "Number", "Name", "Amount", "Title"))
-			for playerId := range database.RoomPlayers(room.ID) {
-				title := "player"
-				if playerId == room.Creator {
-					title = "owner"
-				}
-				info := database.GetPlayer(playerId)
-				playerNum := undercoverGame.PlayerNumbers[playerId]
-				buf.WriteString(fmt.Sprintf("%-10d%-20s%-10d%-10s\n", playerNum, info.Name, info.Amount, title))
-			}
-			return player.WriteString(buf.String())
+	buf.WriteString(fmt.Sprintf("%-10s%-16s%-10s%-10s%-14s\n", "ID", "Type", "Players", "Round", "State"))
+	list := make([]database.TournamentInfo, 0)
+	for _, t := range database.GetTournaments() {
+		info := t.Info()
+		buf.WriteString(fmt.Sprintf("%-10d%-16s%-10d%-10d%-14s\n", info.ID, consts.GameTypes[info.Type], info.Players, info.Round, info.State))
+		list = append(list, info)
+	}
+	return player.WriteData(tournamentList{
+		Data:        model.Data{Code: consts.CodeTournaments, Msg: buf.String()},
+		Tournaments: list,
+	}, buf.String())
+}
+
+// Standings 锦标赛的排名
+func Standings(player *database.Player, t *database.Tournament) error {
+	info := t.Info()
+	info.Data = model.Data{Code: consts.CodeStandings, Msg: info.String()}
+	return player.WriteData(info, info.Msg)
+}
+
+type stats struct {
+	model.Data
+	*database.PlayerStats
+}
+
+// Stats 玩家各游戏的战绩
+func Stats(player *database.Player, s *database.PlayerStats) error {
+	buf := bytes.Buffer{}
+	buf.WriteString(fmt.Sprintf("Stats of %s\n", s.Name))
+	buf.WriteString(fmt.Sprintf("%-16s%-8s%-8s%-8s%-10s\n", "Game", "Played", "Wins", "Losses", "Win rate"))
+	for _, id := range consts.GameTypesIds {
+		game := s.Games[id]
+		if game == nil {
+			continue
+		}
+		buf.WriteString(fmt.Sprintf("%-16s%-8d%-8d%-8d%-10s", consts.GameTypes[id], game.Played, game.Wins, game.Losses(), winRate(game.Wins, game.Played)))
+		switch id {
+		case consts.GameTypeClassic, consts.GameTypeLaiZi, consts.GameTypeSkill:
+			buf.WriteString(fmt.Sprintf("landlord: %d/%d (%s)", game.LandlordWins, game.Landlord, winRate(game.LandlordWins, game.Landlord)))
+		case consts.GameTypeTexas:
+			buf.WriteString(fmt.Sprintf("net chips: %+d", game.NetChips))
+		case consts.GameTypeUndercover:
+			buf.WriteString(fmt.Sprintf("survived: %d", game.Survived))
 		}
+		buf.WriteString("\n")
 	}
-	
-	// 默认显示（等待状态或其他游戏）
-	buf.WriteString(fmt.Sprintf("%-20s%-10s%-10s\n", "Name", "Amount", "Title"))
-	for playerId := range database.RoomPlayers(room.ID) {
-		title := "player"
-		if playerId == room.Creator {
-			title = "owner"
+	return player.WriteData(stats{
+		Data:        model.Data{Code: consts.CodeStats, Msg: buf.String()},
+		PlayerStats: s,
+	}, buf.String())
+}
+
+type leaderboard struct {
+	model.Data
+	Type    int                     `json:"type"`
+	Players []*database.PlayerStats `json:"players"`
+}
+
+// Leaderboard 某种游戏的排行榜
+func Leaderboard(player *database.Player, gameType int, list []*database.PlayerStats) error {
+	buf := bytes.Buffer{}
+	buf.WriteString(fmt.Sprintf("Top players of %s\n", consts.GameTypes[gameType]))
+	buf.WriteString(fmt.Sprintf("%-6s%-20s%-8s%-8s%-10s\n", "Rank", "Name", "Played", "Wins", "Win rate"))
+	for i, s := range list {
+		game := s.Games[gameType]
+		buf.WriteString(fmt.Sprintf("%-6d%-20s%-8d%-8d%-10s", i+1, s.Name, game.Played, game.Wins, winRate(game.Wins, game.Played)))
+		if gameType == consts.GameTypeTexas {
+			buf.WriteString(fmt.Sprintf("net chips: %+d", game.NetChips))
 		}
-		info := database.GetPlayer(playerId)
-		buf.WriteString(fmt.Sprintf("%-20s%-10d%-10s\n", info.Name, info.Amount, title))
+		buf.WriteString("\n")
 	}
-	return player.WriteString(buf.String())
+	return player.WriteData(leaderboard{
+		Data:    model.Data{Code: consts.CodeLeaderboard, Msg: buf.String()},
+		Type:    gameType,
+		Players: list,
+	}, buf.String())
 }
 
-func Error(player *database.Player, err error) error {
-	return player.WriteError(err)
+func winRate(wins, played int) string {
+	if played == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", float64(wins)*100/float64(played))
 }
 
-func Join(player *database.Player, room *database.Room) {
-	database.BroadcastObject(room.ID, model.RoomEvent{
+func RoomCreated(player *database.Player, room *database.Room) error {
+	msg := fmt.Sprintf("Create room successful, id : %d\n", room.ID)
+	return player.WriteData(model.RoomEvent{
 		Data: model.Data{
-			Code: constx.CodeRoomEventJoin,
-			Msg:  fmt.Sprintf("%s joined room! room current has %d players\n", player.Name, room.Players),
+			Code: constx.CodeRoomEventCreate,
+			Msg:  msg,
 		},
 		Room:   room.Model(),
 		Player: player.Model(),
-	})
+	}, msg)
 }
 
-func Exit(player *database.Player, room *database.Room) {
-	database.BroadcastObject(room.ID, model.RoomEvent{
-		Data: model.Data{
-			Code: constx.CodeRoomEventExit,
-			Msg:  fmt.Sprintf("%s exited room! room current has %d players\n", player.Name, room.Players),
-		},
-		Room:   room.Model(),
-		Player: player.Model(),
+// RoomPlayers 房间中的玩家、观众和房间配置，房主以外的玩家看不到密码
+func RoomPlayers(currPlayer *database.Player, room *database.Room) error {
+	info := RoomInfo{
+		Room:       room.Model(),
+		Players:    roomPlayers(room, slices.Collect(maps.Keys(database.RoomPlayers(room.ID))), ""),
+		Spectators: roomPlayers(room, slices.Collect(maps.Keys(database.RoomSpectators(room.ID))), string(database.RoleSpectator)),
+		Settings:   make([]Setting, 0),
+	}
+	buf := bytes.Buffer{}
+	buf.WriteString(fmt.Sprintf("Room ID: %d\n", room.ID))
+	buf.WriteString("Players:\n")
+	for _, p := range info.Players {
+		buf.WriteString(roomPlayerString(p))
+	}
+	buf.WriteString("\nSpectators:\n")
+	for _, p := range info.Spectators {
+		buf.WriteString(roomPlayerString(p))
+	}
+	buf.WriteString("\nSettings:\n")
+	for _, row := range settings(room, currPlayer) {
+		items := make([]string, 0, len(row))
+		for _, setting := range row {
+			items = append(items, fmt.Sprintf("%-5s%-5v", setting.Key+":", setting.Value))
+			info.Settings = append(info.Settings, setting)
+		}
+		buf.WriteString(strings.Join(items, ", ") + "\n")
+	}
+	if info.Series = database.RoomSeries(room); info.Series != nil {
+		buf.WriteString("\n" + info.Series.String())
+	}
+	info.Data = model.Data{Code: consts.CodeRoomInfo, Msg: buf.String()}
+	return currPlayer.WriteData(info, buf.String())
+}
+
+type spectatorView struct {
+	model.Data
+	*database.SpectatorView
+}
+
+// Spectate 观众看到的对局：桌面、每位玩家的手牌数量和公开状态，开启延迟公开时还有所有玩家的手牌
+func Spectate(player *database.Player, view *database.SpectatorView) error {
+	buf := bytes.Buffer{}
+	buf.WriteString(fmt.Sprintf("Spectating %s\n", consts.GameTypes[view.Game]))
+	for _, line := range view.Board {
+		buf.WriteString(line + "\n")
+	}
+	buf.WriteString(fmt.Sprintf("%-20s%-10s%s\n", "Name", "Cards", "Status"))
+	for _, seat := range view.Seats {
+		buf.WriteString(fmt.Sprintf("%-20s%-10d%s\n", seat.Name, seat.Cards, seat.Status))
+	}
+	if len(view.Hands) > 0 {
+		buf.WriteString(fmt.Sprintf("Hands (%ds ago):\n", view.Delay))
+		for _, hand := range view.Hands {
+			buf.WriteString(fmt.Sprintf("%-20s%s\n", hand.Name, strings.Join(hand.Cards, " ")))
+		}
+	}
+	return player.WriteData(spectatorView{
+		Data:          model.Data{Code: consts.CodeSpectate, Msg: buf.String()},
+		SpectatorView: view,
+	}, buf.String())
+}
+
+func roomPlayers(room *database.Room, ids []int64, role string) []RoomPlayer {
+	list := make([]RoomPlayer, 0, len(ids))
+	for _, id := range ids {
+		player := database.GetPlayer(id)
+		if player == nil {
+			continue
+		}
+		item := RoomPlayer{Player: player.Model(), Role: role, Robot: player.IsRobot()}
+		if item.Role == "" {
+			item.Role = string(player.Role)
+			if player.IsRobot() {
+				item.Role = "robot"
+			}
+		}
+		if room.EnableShowIP {
+			item.IP = maskIP(player.IP)
+		}
+		list = append(list, item)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
 	})
+	return list
+}
+
+func roomPlayerString(p RoomPlayer) string {
+	if p.IP != "" {
+		return fmt.Sprintf("%s [%s], score: %d, id: %d, ip: %s\n", p.Name, p.Role, p.Score, p.ID, p.IP)
+	}
+	return fmt.Sprintf("%s [%s], score: %d, id: %d\n", p.Name, p.Role, p.Score, p.ID)
+}
+
+// settings 房间配置，每一行在文本中显示为一行，系列赛和观众的配置对所有游戏类型可用
+func settings(room *database.Room, currPlayer *database.Player) [][]Setting {
+	reveal := "off"
+	if room.RevealDelay > 0 {
+		reveal = fmt.Sprint(int(room.RevealDelay.Seconds()))
+	}
+	return append(gameSettings(room, currPlayer),
+		[]Setting{{"sr", room.SeriesMode()}},
+		[]Setting{{"sc", propsState(room.EnableSpectatorChat)}, {"rv", reveal}},
+	)
+}
+
+// gameSettings 按游戏类型列出房间配置
+func gameSettings(room *database.Room, currPlayer *database.Player) [][]Setting {
+	ip := Setting{"ip", propsState(room.EnableShowIP)}
+	pn := Setting{"pn", fmt.Sprint(room.MaxPlayers)}
+	switch room.Type {
+	case consts.GameTypeUno:
+		return [][]Setting{
+			{{"du", propsState(room.EnableDrawUntil)}, {"sd", propsState(room.EnableStackDraws)}},
+			{pn},
+			{ip},
+		}
+	case consts.GameTypeMahjong:
+		return [][]Setting{
+			{{"mv", consts.MahjongVariants[room.MahjongVariant]}},
+			{ip},
+		}
+	case consts.GameTypeTexas:
+		stack := "off"
+		if room.Stack > 0 {
+			stack = fmt.Sprint(room.Stack)
+		}
+		return [][]Setting{
+			{{"sb", fmt.Sprint(room.SmallBlind)}, {"bb", fmt.Sprint(room.BigBlind)}, {"bl", consts.TexasLimits[room.BettingLimit]}},
+			{{"ante", fmt.Sprint(room.Ante)}, {"stack", stack}},
+			{{"lvl", fmt.Sprint(room.BlindLevel())}, {"tm", propsState(room.EnableTraining)}},
+			{pn},
+			{ip},
+		}
+	case consts.GameTypeLiar:
+		return [][]Setting{
+			{{"jt", propsState(room.EnableJokerAsTarget)}},
+			{ip},
+		}
+	case consts.GameTypeUndercover:
+		return [][]Setting{
+			{pn},
+			{{"ucn", fmt.Sprint(room.UndercoverNum)}},
+			{{"bwm", propsState(room.BlankWordMode)}},
+			{ip},
+		}
+	default:
+		pwd := room.Password
+		if pwd != "" {
+			if room.Creator != currPlayer.ID {
+				pwd = "********"
+			}
+		} else {
+			pwd = "off"
+		}
+		return [][]Setting{
+			{{"lz", propsState(room.EnableLaiZi)}},
+			{{"ds", propsState(room.EnableDontShuffle)}, {"sk", propsState(room.EnableSkill)}},
+			{pn, {"ct", propsState(room.EnableChat)}},
+			{ip},
+			{{"pwd", pwd}},
+		}
+	}
+}
+
+func propsState(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+func maskIP(ip string) string {
+	parts := strings.Split(ip, ".")
+	if len(parts) == 4 {
+		return parts[0] + "." + parts[1] + ".*.*"
+	}
+	return "*.*.*.*"
+}
+
+func Error(player *database.Player, err error) error {
+	return player.WriteError(err)
 }
 
-func Offline(player *database.Player, room *database.Room) {
-	database.BroadcastObject(room.ID, model.RoomEvent{
+func newRoomEvent(code int, player *database.Player, room *database.Room, msg string) model.RoomEvent {
+	return model.RoomEvent{
 		Data: model.Data{
-			Code: constx.CodeRoomEventOffline,
-			Msg:  fmt.Sprintf("%s lost connection", player.Name),
+			Code: code,
+			Msg:  msg,
 		},
 		Room:   room.Model(),
 		Player: player.Model(),
-	})
+	}
+}
+
+func roomEvent(code int, player *database.Player, room *database.Room, msg string) {
+	database.BroadcastObject(room.ID, newRoomEvent(code, player, room, msg), msg)
+}
+
+func Join(player *database.Player, room *database.Room) {
+	roomEvent(constx.CodeRoomEventJoin, player, room, fmt.Sprintf("%s [%s] joined room! room current has %d players\n", player.Name, player.Role, room.Players))
+}
+
+func Exit(player *database.Player, room *database.Room) {
+	roomEvent(constx.CodeRoomEventExit, player, room, fmt.Sprintf("%s exited room! room current has %d players\n", player.Name, room.Players))
+}
+
+// Kicked 被房主踢出房间，与退出房间使用相同的消息类型，需要在玩家离开房间后调用
+func Kicked(player *database.Player, room *database.Room) {
+	msg := fmt.Sprintf("%s has been kicked! room current has %d players\n", player.Name, room.Players)
+	event := newRoomEvent(constx.CodeRoomEventExit, player, room, msg)
+	database.BroadcastObject(room.ID, event, msg)
+	_ = player.WriteData(event, ">> "+msg)
 }
 
 func OwnerChange(player *database.Player, room *database.Room) {
-	database.BroadcastObject(room.ID, model.RoomEvent{
-		Data: model.Data{
-			Code: constx.CodeRoomEventOwnerChange,
-			Msg:  fmt.Sprintf("%s become new owner\n", player.Name),
-		},
-		Room:   room.Model(),
-		Player: player.Model(),
-	})
+	roomEvent(constx.CodeRoomEventOwnerChange, player, room, fmt.Sprintf("%s become new owner\n", player.Name))
 }
@@ -0,0 +1,40 @@
+package robot
+
+import (
+	"github.com/ratel-online/core/model"
+	"github.com/ratel-online/core/util/poker"
+	"github.com/ratel-online/core/util/rand"
+)
+
+// liarTruths 骗子酒馆牌堆中每种指示牌的数量加上两张王
+const liarTruths = 10
+
+// Liar 骗子酒馆出牌，lastCount 为上家出牌的张数，为 0 时不能质疑；返回 "c" 表示质疑，否则返回出牌的别名
+func Liar(hand model.Pokers, target, lastCount int) string {
+	truths := make([]int, 0)
+	lies := make([]int, 0)
+	for _, p := range hand {
+		if p.Key == target || p.Key == 14 || p.Key == 15 {
+			truths = append(truths, p.Key)
+		} else {
+			lies = append(lies, p.Key)
+		}
+	}
+	if lastCount > 0 {
+		// 上家出的牌比场上可能剩余的真牌还多时必然撒谎，否则按出牌张数随机质疑
+		if lastCount > liarTruths-len(truths) || rand.Intn(10) < lastCount*2 {
+			return "c"
+		}
+	}
+	if len(truths) > 0 {
+		n := 1 + rand.Intn(len(truths))
+		if n > 3 {
+			n = 3
+		}
+		return alias(truths[:n])
+	}
+	if len(lies) == 0 {
+		return poker.GetAlias(hand[0].Key)
+	}
+	return alias(lies[:1])
+}
@@ -0,0 +1,242 @@
+// Package robot 机器人玩家的出牌策略，只根据传入的牌面做决策，不依赖房间和玩家状态
+package robot
+
+import (
+	"sort"
+
+	"github.com/ratel-online/core/consts"
+	"github.com/ratel-online/core/model"
+	"github.com/ratel-online/core/util/poker"
+)
+
+// Rob 叫地主，大牌足够多时抢地主；返回 "y" 或 "n"
+func Rob(pokers model.Pokers) string {
+	score := 0
+	counts := map[int]int{}
+	for _, p := range pokers {
+		counts[p.Key]++
+		switch p.Key {
+		case 15:
+			score += 4
+		case 14:
+			score += 3
+		case 2:
+			score += 2
+		case 1:
+			score += 1
+		}
+	}
+	for _, c := range counts {
+		if c == 4 {
+			score += 4
+		}
+	}
+	if score >= 7 {
+		return "y"
+	}
+	return "n"
+}
+
+// Play 斗地主出牌，last 为空时主动出牌，teammate 表示上家是队友；返回出牌的别名，不出时返回 "p"
+func Play(pokers model.Pokers, last *model.Faces, rules poker.Rules, teammate bool) string {
+	list := candidates(pokers, rules, poker.ParseFaces)
+	if last == nil {
+		return alias(lead(list, len(pokers)))
+	}
+	if isRocket(last.Keys) {
+		return "p"
+	}
+	var best *candidate
+	for i := range list {
+		c := &list[i]
+		if !c.faces.Compare(*last) && !isRocket(c.keys) {
+			continue
+		}
+		// 能一手出完时直接出完
+		if len(c.keys) == len(pokers) {
+			return alias(c.keys)
+		}
+		if teammate {
+			continue
+		}
+		// 炸弹留到手牌不多的时候再用
+		if c.bomb() && len(pokers) > 8 {
+			continue
+		}
+		if best == nil || c.weight() < best.weight() {
+			best = c
+		}
+	}
+	if best == nil {
+		return "p"
+	}
+	return alias(best.keys)
+}
+
+// RunFast 跑得快出牌，last 为空时主动出牌；跑得快有牌必须出，因此总是返回可以出的牌
+func RunFast(pokers model.Pokers, last *model.Faces, rules poker.Rules) string {
+	if last == nil {
+		return alias(lead(candidates(pokers, rules, poker.RunFastParseFaces), len(pokers)))
+	}
+	list := poker.RunFastComparativeFaces(*last, pokers, rules)
+	if len(list) == 0 {
+		return "p"
+	}
+	best := list[0]
+	for _, faces := range list[1:] {
+		if len(faces.Keys) == len(pokers) {
+			return alias(faces.Keys)
+		}
+		if (best.Type == consts.FacesBomb && faces.Type != consts.FacesBomb) ||
+			(best.Type == faces.Type && faces.Score < best.Score) {
+			best = faces
+		}
+	}
+	return alias(best.Keys)
+}
+
+type candidate struct {
+	keys  []int
+	faces model.Faces
+}
+
+func (c candidate) bomb() bool {
+	return c.faces.Type == consts.FacesBomb || isRocket(c.keys)
+}
+
+// low 组合中最小的牌值
+func (c candidate) low() int {
+	low := 0
+	for i, v := range c.faces.Values {
+		if i == 0 || v < low {
+			low = v
+		}
+	}
+	return low
+}
+
+// weight 跟牌时优先出分值小的牌，炸弹排在最后
+func (c candidate) weight() int64 {
+	if c.bomb() {
+		return c.faces.Score + 10000
+	}
+	return c.faces.Score
+}
+
+// candidates 列出手牌中所有合法的出牌组合
+func candidates(pokers model.Pokers, rules poker.Rules, parse func(model.Pokers, poker.Rules) []model.Faces) []candidate {
+	counts := map[int]int{}
+	for _, p := range pokers {
+		counts[p.Key]++
+	}
+	keys := make([]int, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return rules.Value(keys[i]) < rules.Value(keys[j])
+	})
+
+	groups := make([][]int, 0)
+	for _, k := range keys {
+		for n := 1; n <= counts[k] && n <= 4; n++ {
+			groups = append(groups, repeat(k, n))
+		}
+		if counts[k] >= 3 {
+			// 三带一、三带二，带最小的单牌和对子
+			single, pair := false, false
+			for _, o := range keys {
+				if o == k {
+					continue
+				}
+				if !single {
+					single = true
+					groups = append(groups, append(repeat(k, 3), o))
+				}
+				if !pair && counts[o] >= 2 {
+					pair = true
+					groups = append(groups, append(repeat(k, 3), o, o))
+				}
+			}
+		}
+	}
+	// 顺子、连对、飞机
+	for n := 1; n <= 3; n++ {
+		for i := range keys {
+			run := make([]int, 0)
+			for j := i; j < len(keys) && counts[keys[j]] >= n; j++ {
+				if j > i && rules.Value(keys[j]) != rules.Value(keys[j-1])+1 {
+					break
+				}
+				run = append(run, repeat(keys[j], n)...)
+				if j > i {
+					groups = append(groups, append([]int{}, run...))
+				}
+			}
+		}
+	}
+	if counts[14] > 0 && counts[15] > 0 {
+		groups = append(groups, []int{14, 15})
+	}
+
+	list := make([]candidate, 0, len(groups))
+	for _, group := range groups {
+		sells := poker.GetPokers(group...)
+		for i := range sells {
+			sells[i].Val = rules.Value(sells[i].Key)
+		}
+		facesArr := parse(sells, rules)
+		if len(facesArr) == 0 {
+			continue
+		}
+		list = append(list, candidate{keys: group, faces: facesArr[0]})
+	}
+	return list
+}
+
+// lead 主动出牌，优先把最小的牌连带出去，能一手出完时直接出完
+func lead(list []candidate, size int) []int {
+	var best *candidate
+	for i := range list {
+		c := &list[i]
+		if len(c.keys) == size {
+			return c.keys
+		}
+		if c.bomb() {
+			continue
+		}
+		if best == nil || c.low() < best.low() || (c.low() == best.low() && len(c.keys) > len(best.keys)) {
+			best = c
+		}
+	}
+	if best == nil && len(list) > 0 {
+		best = &list[0]
+	}
+	if best == nil {
+		return nil
+	}
+	return best.keys
+}
+
+func isRocket(keys []int) bool {
+	return len(keys) == 2 && ((keys[0] == 14 && keys[1] == 15) || (keys[0] == 15 && keys[1] == 14))
+}
+
+func repeat(key, n int) []int {
+	keys := make([]int, n)
+	for i := range keys {
+		keys[i] = key
+	}
+	return keys
+}
+
+func alias(keys []int) string {
+	if len(keys) == 0 {
+		return "p"
+	}
+	ans := ""
+	for _, k := range keys {
+		ans += poker.GetAlias(k)
+	}
+	return ans
+}
@@ -0,0 +1,142 @@
+package robot
+
+import (
+	"fmt"
+
+	"github.com/ratel-online/core/model"
+	"github.com/ratel-online/core/util/rand"
+)
+
+// Bet 德州扑克下注，minCall 为跟注需要的筹码，amount 为剩余筹码，minRaise 和 maxRaise 为允许加注的范围，
+// 不能加注时都为 0；返回 call/raise/fold/check/allin 指令
+func Bet(hand, board model.Pokers, minCall, amount, pot, minRaise, maxRaise uint) string {
+	strength := strength(hand, board)
+	if minCall == 0 {
+		if strength >= 0.6 && amount > 0 {
+			return raise(minCall, amount, pot, minRaise, maxRaise)
+		}
+		return "check"
+	}
+	if amount <= minCall {
+		if strength >= 0.6 {
+			return "allin"
+		}
+		return "fold"
+	}
+	if strength >= 0.75 {
+		return raise(minCall, amount, pot, minRaise, maxRaise)
+	}
+	// 牌力一般时只跟小注，偶尔诈唬
+	if strength >= 0.3 || minCall*20 <= amount || rand.Intn(10) == 0 {
+		return "call"
+	}
+	return "fold"
+}
+
+func raise(minCall, amount, pot, minRaise, maxRaise uint) string {
+	if maxRaise == 0 {
+		if minCall == 0 {
+			return "check"
+		}
+		return "call"
+	}
+	bet := min(max(minCall+pot/2, minRaise), maxRaise)
+	if bet >= amount {
+		return "allin"
+	}
+	return fmt.Sprintf("raise %d", bet)
+}
+
+// strength 估算牌力，取值 0~1
+func strength(hand, board model.Pokers) float64 {
+	if len(board) == 0 {
+		return preFlopStrength(hand)
+	}
+	ranks := map[int]int{}
+	suits := map[model.PokerSuit]int{}
+	for _, cards := range []model.Pokers{hand, board} {
+		for _, card := range cards {
+			ranks[card.Key]++
+			suits[card.Suit]++
+		}
+	}
+	pairs, trips, quads := 0, 0, 0
+	for _, n := range ranks {
+		switch {
+		case n >= 4:
+			quads++
+		case n == 3:
+			trips++
+		case n == 2:
+			pairs++
+		}
+	}
+	flush := false
+	for _, n := range suits {
+		if n >= 5 {
+			flush = true
+		}
+	}
+	switch {
+	case quads > 0:
+		return 0.97
+	case trips > 0 && (pairs > 0 || trips > 1):
+		return 0.92
+	case flush:
+		return 0.88
+	case isStraight(ranks):
+		return 0.82
+	case trips > 0:
+		return 0.72
+	case pairs >= 2:
+		return 0.58
+	case pairs == 1:
+		return 0.38
+	}
+	return 0.15
+}
+
+func preFlopStrength(hand model.Pokers) float64 {
+	if len(hand) < 2 {
+		return 0
+	}
+	a, b := rank(hand[0].Key), rank(hand[1].Key)
+	if a < b {
+		a, b = b, a
+	}
+	s := float64(a+b) / 28 * 0.5
+	if a == b {
+		s += 0.35
+	}
+	if hand[0].Suit == hand[1].Suit {
+		s += 0.05
+	}
+	return s
+}
+
+func isStraight(ranks map[int]int) bool {
+	run := 0
+	// A 既可以当 1 也可以当 14
+	for r := 1; r <= 14; r++ {
+		key := r
+		if r == 14 {
+			key = 1
+		}
+		if ranks[key] > 0 {
+			run++
+			if run >= 5 {
+				return true
+			}
+		} else {
+			run = 0
+		}
+	}
+	return false
+}
+
+func rank(key int) int {
+	if key == 1 {
+		return 14
+	}
+	return key
+}
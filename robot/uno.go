@@ -0,0 +1,49 @@
+package robot
+
+import (
+	"github.com/feel-easy/uno/card"
+	"github.com/feel-easy/uno/card/color"
+)
+
+// UnoCard Uno 出牌，返回要出的牌在 playable 中的下标；优先出手中数量最多的颜色，万能牌留到最后
+func UnoCard(playable, hand []card.Card) int {
+	counts := colorCounts(hand)
+	best := 0
+	for i, c := range playable {
+		if c.Color() == nil {
+			continue
+		}
+		if playable[best].Color() == nil || counts[c.Color()] > counts[playable[best].Color()] {
+			best = i
+		}
+	}
+	return best
+}
+
+// UnoColor Uno 选择颜色，返回手中数量最多的颜色名称
+func UnoColor(hand []card.Card) string {
+	counts := colorCounts(hand)
+	names := map[color.Color]string{
+		color.Red:    "r",
+		color.Yellow: "y",
+		color.Green:  "g",
+		color.Blue:   "b",
+	}
+	best, max := "r", 0
+	for _, c := range []color.Color{color.Red, color.Yellow, color.Green, color.Blue} {
+		if counts[c] > max {
+			best, max = names[c], counts[c]
+		}
+	}
+	return best
+}
+
+func colorCounts(hand []card.Card) map[color.Color]int {
+	counts := map[color.Color]int{}
+	for _, c := range hand {
+		if c.Color() != nil {
+			counts[c.Color()]++
+		}
+	}
+	return counts
+}
@@ -1,5 +1,14 @@
 package rule
 
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
+
+	"github.com/ratel-online/core/model"
+)
+
 type _texasRule struct {
 }
 
@@ -10,9 +19,12 @@ func (r _texasRule) Value(key int) int {
 	return key - 1
 }
 
+// IsStraight 五张不同的牌连续，A 也可以当作 1 组成 A-2-3-4-5
 func (r _texasRule) IsStraight(faces []int, count int) bool {
-	// todo
-	return false
+	if count != 1 || len(faces) != 5 {
+		return false
+	}
+	return straightHigh(faces) > 0
 }
 
 func (r _texasRule) StraightBoundary() (int, int) {
@@ -22,3 +34,233 @@ func (r _texasRule) StraightBoundary() (int, int) {
 func (r _texasRule) Reserved() bool {
 	return false
 }
+
+// TexasHand 德州扑克的一手牌力，Ranks 为依次比较的牌值（2 为 1，A 为 13）
+type TexasHand struct {
+	Type  model.TexasFacesType `json:"type"`
+	Ranks []int                `json:"ranks"`
+	Cards model.Pokers         `json:"cards"` // 组成这手牌的最多五张牌
+}
+
+// Compare 比较两手牌的大小，大于、等于、小于时分别返回 1、0、-1
+func (h TexasHand) Compare(o TexasHand) int {
+	if h.Type != o.Type {
+		if h.Type > o.Type {
+			return 1
+		}
+		return -1
+	}
+	return slices.Compare(h.Ranks, o.Ranks)
+}
+
+var texasRankNames = []string{"", "Two", "Three", "Four", "Five", "Six", "Seven", "Eight", "Nine", "Ten", "Jack", "Queen", "King", "Ace"}
+
+var texasRankDesc = []string{"", "2", "3", "4", "5", "6", "7", "8", "9", "10", "J", "Q", "K", "A"}
+
+func rankName(v int) string {
+	return texasRankNames[v]
+}
+
+func rankNames(v int) string {
+	if v == 5 {
+		return "Sixes"
+	}
+	return texasRankNames[v] + "s"
+}
+
+func rankDesc(values []int) string {
+	list := make([]string, 0, len(values))
+	for _, v := range values {
+		list = append(list, texasRankDesc[v])
+	}
+	return strings.Join(list, " ")
+}
+
+// Name 牌型的名称，包括决定大小的踢脚
+func (h TexasHand) Name() string {
+	r := h.Ranks
+	switch h.Type {
+	case model.TexasFacesTypeRoyalFlush:
+		return "Royal Flush"
+	case model.TexasFacesTypeStraightFlush:
+		return fmt.Sprintf("Straight Flush, %s high", rankName(r[0]))
+	case model.TexasFacesTypeFourOfAKind:
+		return withKickers(fmt.Sprintf("Four of a Kind, %s", rankNames(r[0])), r[1:])
+	case model.TexasFacesTypeFullHouse:
+		return fmt.Sprintf("Full House, %s full of %s", rankNames(r[0]), rankNames(r[1]))
+	case model.TexasFacesTypeFlush:
+		return fmt.Sprintf("Flush, %s", rankDesc(r))
+	case model.TexasFacesTypeStraight:
+		return fmt.Sprintf("Straight, %s high", rankName(r[0]))
+	case model.TexasFacesTypeThreeOfAKind:
+		return withKickers(fmt.Sprintf("Three of a Kind, %s", rankNames(r[0])), r[1:])
+	case model.TexasFacesTypeTwoPairs:
+		return withKickers(fmt.Sprintf("Two Pairs, %s and %s", rankNames(r[0]), rankNames(r[1])), r[2:])
+	case model.TexasFacesTypeOnePair:
+		return withKickers(fmt.Sprintf("One Pair, %s", rankNames(r[0])), r[1:])
+	}
+	return fmt.Sprintf("High Card, %s", rankDesc(r))
+}
+
+func withKickers(name string, kickers []int) string {
+	if len(kickers) == 0 {
+		return name
+	}
+	if len(kickers) == 1 {
+		return fmt.Sprintf("%s, kicker %s", name, rankDesc(kickers))
+	}
+	return fmt.Sprintf("%s, kickers %s", name, rankDesc(kickers))
+}
+
+// Evaluate 从手牌和公共牌中选出最大的五张牌，不足五张时按已有的牌计算
+func (r _texasRule) Evaluate(hand, board model.Pokers) TexasHand {
+	cards := make(model.Pokers, 0, len(hand)+len(board))
+	cards = append(cards, hand...)
+	cards = append(cards, board...)
+	if len(cards) <= 5 {
+		return r.evaluate(cards)
+	}
+	var best TexasHand
+	picked := make(model.Pokers, 5)
+	var choose func(start, n int)
+	choose = func(start, n int) {
+		if n == 5 {
+			if h := r.evaluate(picked); best.Ranks == nil || h.Compare(best) > 0 {
+				best = h
+				best.Cards = slices.Clone(picked)
+			}
+			return
+		}
+		for i := start; i <= len(cards)-(5-n); i++ {
+			picked[n] = cards[i]
+			choose(i+1, n+1)
+		}
+	}
+	choose(0, 0)
+	return best
+}
+
+// evaluate 计算最多五张牌的牌型
+func (r _texasRule) evaluate(cards model.Pokers) TexasHand {
+	if len(cards) == 0 {
+		return TexasHand{Type: model.TexasFacesTypeHigh, Ranks: []int{}}
+	}
+	counts := map[int]int{}
+	values := make([]int, 0, len(cards))
+	flush := len(cards) == 5
+	for _, card := range cards {
+		v := r.Value(card.Key)
+		if counts[v] == 0 {
+			values = append(values, v)
+		}
+		counts[v]++
+		if card.Suit != cards[0].Suit {
+			flush = false
+		}
+	}
+	// 先按张数，再按牌值从大到小排列
+	slices.SortFunc(values, func(a, b int) int {
+		if counts[a] != counts[b] {
+			return counts[b] - counts[a]
+		}
+		return b - a
+	})
+	hand := TexasHand{Ranks: values, Cards: cards}
+	high := 0
+	if len(values) == 5 {
+		high = straightHigh(values)
+	}
+	switch {
+	case flush && high == 13:
+		hand.Type = model.TexasFacesTypeRoyalFlush
+		hand.Ranks = []int{high}
+	case flush && high > 0:
+		hand.Type = model.TexasFacesTypeStraightFlush
+		hand.Ranks = []int{high}
+	case counts[values[0]] == 4:
+		hand.Type = model.TexasFacesTypeFourOfAKind
+	case counts[values[0]] == 3 && len(values) > 1 && counts[values[1]] == 2:
+		hand.Type = model.TexasFacesTypeFullHouse
+	case flush:
+		hand.Type = model.TexasFacesTypeFlush
+	case high > 0:
+		hand.Type = model.TexasFacesTypeStraight
+		hand.Ranks = []int{high}
+	case counts[values[0]] == 3:
+		hand.Type = model.TexasFacesTypeThreeOfAKind
+	case counts[values[0]] == 2 && len(values) > 1 && counts[values[1]] == 2:
+		hand.Type = model.TexasFacesTypeTwoPairs
+	case counts[values[0]] == 2:
+		hand.Type = model.TexasFacesTypeOnePair
+	default:
+		hand.Type = model.TexasFacesTypeHigh
+	}
+	return hand
+}
+
+// straightHigh 五个不同的牌值组成顺子时返回最大的牌值，A-2-3-4-5 的最大牌为 5，不是顺子时返回 0
+func straightHigh(values []int) int {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	if slices.Equal(sorted, []int{1, 2, 3, 4, 13}) {
+		return 4
+	}
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i] != sorted[i-1]+1 {
+			return 0
+		}
+	}
+	return sorted[len(sorted)-1]
+}
+
+// Equity 用蒙特卡洛模拟估算手牌对 opponents 名对手的胜率，平分底池时按人数折算
+func (r _texasRule) Equity(hand, board model.Pokers, opponents, trials int) float64 {
+	if opponents <= 0 {
+		return 1
+	}
+	deck := make(model.Pokers, 0, 52)
+	for _, suit := range []model.PokerSuit{model.Spade, model.Heart, model.Club, model.Diamond} {
+		for key := 1; key <= 13; key++ {
+			card := model.Poker{Key: key, Suit: suit}
+			if !slices.ContainsFunc(hand, sameCard(card)) && !slices.ContainsFunc(board, sameCard(card)) {
+				deck = append(deck, card)
+			}
+		}
+	}
+	missing := 5 - len(board)
+	if len(deck) < missing+opponents*2 {
+		return 0
+	}
+	won := 0.0
+	full := make(model.Pokers, 0, 5)
+	for t := 0; t < trials; t++ {
+		// 只需要打乱会用到的牌
+		for i := 0; i < missing+opponents*2; i++ {
+			j := i + rand.Intn(len(deck)-i)
+			deck[i], deck[j] = deck[j], deck[i]
+		}
+		full = append(append(full[:0], board...), deck[:missing]...)
+		mine := r.Evaluate(hand, full)
+		ties := 0
+		lost := false
+		for o := 0; o < opponents && !lost; o++ {
+			start := missing + o*2
+			switch r.Evaluate(deck[start:start+2], full).Compare(mine) {
+			case 1:
+				lost = true
+			case 0:
+				ties++
+			}
+		}
+		if !lost {
+			won += 1 / float64(ties+1)
+		}
+	}
+	return won / float64(trials)
+}
+
+func sameCard(card model.Poker) func(model.Poker) bool {
+	return func(c model.Poker) bool {
+		return c.Key == card.Key && c.Suit == card.Suit
+	}
+}
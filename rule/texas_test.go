@@ -0,0 +1,78 @@
+package rule
+
+import (
+	"testing"
+
+	"github.com/ratel-online/core/model"
+)
+
+// cards 按 "As Kh 10d" 的格式生成牌，10 写作 10
+func cards(desc ...string) model.Pokers {
+	keys := map[string]int{"A": 1, "J": 11, "Q": 12, "K": 13}
+	suits := map[byte]model.PokerSuit{'s': model.Spade, 'h': model.Heart, 'c': model.Club, 'd': model.Diamond}
+	list := make(model.Pokers, 0, len(desc))
+	for _, d := range desc {
+		rank, suit := d[:len(d)-1], d[len(d)-1]
+		key, ok := keys[rank]
+		if !ok {
+			for _, c := range rank {
+				key = key*10 + int(c-'0')
+			}
+		}
+		list = append(list, model.Poker{Key: key, Suit: suits[suit]})
+	}
+	return list
+}
+
+func TestTexasEvaluate(t *testing.T) {
+	tests := []struct {
+		hand, board model.Pokers
+		typ         model.TexasFacesType
+		name        string
+	}{
+		{cards("As", "2d"), cards("3c", "4h", "5s", "Kd", "Kh"), model.TexasFacesTypeStraight, "Straight, Five high"},
+		{cards("6s", "2d"), cards("3c", "4h", "5s", "Ad", "Kh"), model.TexasFacesTypeStraight, "Straight, Six high"},
+		{cards("As", "Ks"), cards("Qs", "Js", "10s", "2d", "3h"), model.TexasFacesTypeRoyalFlush, "Royal Flush"},
+		{cards("Ah", "2h"), cards("3h", "4h", "5h", "9d", "Kh"), model.TexasFacesTypeStraightFlush, "Straight Flush, Five high"},
+		{cards("Kd", "Kh"), cards("Ks", "5c", "5s", "5d", "2h"), model.TexasFacesTypeFullHouse, "Full House, Kings full of Fives"},
+		{cards("Ad", "Ah"), cards("10s", "10c", "6s", "6d", "2h"), model.TexasFacesTypeTwoPairs, "Two Pairs, Aces and Tens, kicker 6"},
+		{cards("Jd", "Jh"), cards("As", "9c", "4s", "3d", "2h"), model.TexasFacesTypeOnePair, "One Pair, Jacks, kickers A 9 4"},
+		{cards("Ad", "Jh"), cards("9s", "7c", "4s", "3d", "2h"), model.TexasFacesTypeHigh, "High Card, A J 9 7 4"},
+		{cards("Ad", "Ah"), nil, model.TexasFacesTypeOnePair, "One Pair, Aces"},
+	}
+	for _, tt := range tests {
+		h := TexasRules.Evaluate(tt.hand, tt.board)
+		if h.Type != tt.typ || h.Name() != tt.name {
+			t.Errorf("%s %s: got %v %q, want %v %q", tt.hand.TexasString(), tt.board.TexasString(), h.Type, h.Name(), tt.typ, tt.name)
+		}
+	}
+}
+
+func TestTexasCompare(t *testing.T) {
+	board := cards("Ks", "Kd", "8c", "5h", "2s")
+	a := TexasRules.Evaluate(cards("Ah", "3d"), board)
+	b := TexasRules.Evaluate(cards("Qh", "Jd"), board)
+	if a.Compare(b) != 1 || b.Compare(a) != -1 {
+		t.Errorf("ace kicker should beat queen kicker: %s vs %s", a.Name(), b.Name())
+	}
+	// 公共牌组成最大的牌时平分
+	board = cards("As", "Ks", "Qs", "Js", "10s")
+	if TexasRules.Evaluate(cards("2h", "3d"), board).Compare(TexasRules.Evaluate(cards("4h", "5d"), board)) != 0 {
+		t.Error("board royal flush should tie")
+	}
+	wheel := TexasRules.Evaluate(cards("As", "2d"), cards("3c", "4h", "5s", "9d", "Jh"))
+	six := TexasRules.Evaluate(cards("6s", "2d"), cards("3c", "4h", "5s", "9d", "Jh"))
+	if wheel.Compare(six) != -1 {
+		t.Error("wheel should lose to six high straight")
+	}
+}
+
+func TestTexasEquity(t *testing.T) {
+	board := cards("Ks", "Kd", "8c", "5h", "2s")
+	if e := TexasRules.Equity(cards("Kh", "Kc"), board, 2, 200); e != 1 {
+		t.Errorf("quads with the river dealt should always win, got %f", e)
+	}
+	if e := TexasRules.Equity(cards("Ah", "Ad"), nil, 1, 2000); e < 0.75 || e > 0.9 {
+		t.Errorf("pocket aces against one hand should win about 85%%, got %f", e)
+	}
+}
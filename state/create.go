@@ -1,24 +1,27 @@
 package state
 
 import (
-	"bytes"
 	"errors"
-	"fmt"
 
 	"github.com/ratel-online/server/consts"
 	"github.com/ratel-online/server/database"
+	"github.com/ratel-online/server/render"
 )
 
 type create struct{}
 
 func (*create) Next(player *database.Player) (consts.StateID, error) {
+	if database.Draining() {
+		_ = player.WriteError(consts.ErrorsServerDraining)
+		return consts.StateHome, nil
+	}
 	gameType, err := askForGameType(player)
 	if err != nil {
 		return 0, err
 	}
 	// 创建房间
 	room := database.CreateRoom(player.ID, gameType)
-	err = player.WriteString(fmt.Sprintf("Create room successful, id : %d\n", room.ID))
+	err = render.RoomCreated(player, room)
 	if err != nil {
 		return 0, player.WriteError(err)
 	}
@@ -35,12 +38,7 @@ func (*create) Exit(_ *database.Player) consts.StateID {
 
 // 询问游戏类型
 func askForGameType(player *database.Player) (gameType int, err error) {
-	buf := bytes.Buffer{}
-	buf.WriteString("Please select game type\n")
-	for _, id := range consts.GameTypesIds {
-		buf.WriteString(fmt.Sprintf("%d.%s\n", id, consts.GameTypes[id]))
-	}
-	err = player.WriteString(buf.String())
+	err = render.GameTypeOptions(player)
 	if err != nil {
 		return 0, player.WriteError(err)
 	}
@@ -3,6 +3,7 @@ package game
 import (
 	"bytes"
 	"fmt"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -10,11 +11,13 @@ import (
 	"github.com/ratel-online/core/util/rand"
 	"github.com/ratel-online/server/rule"
 
+	constx "github.com/ratel-online/core/consts"
 	"github.com/ratel-online/core/log"
 	modelx "github.com/ratel-online/core/model"
 	"github.com/ratel-online/core/util/poker"
 	"github.com/ratel-online/server/consts"
 	"github.com/ratel-online/server/database"
+	"github.com/ratel-online/server/robot"
 	"github.com/ratel-online/server/skill"
 )
 
@@ -34,6 +37,7 @@ func (g *Game) Next(player *database.Player) (consts.StateID, error) {
 	if room == nil {
 		return 0, player.WriteError(consts.ErrorsExist)
 	}
+	defer room.Track()()
 	game := room.Game.(*database.Game)
 	buf := bytes.Buffer{}
 	if game.Room.EnableLaiZi {
@@ -52,7 +56,13 @@ func (g *Game) Next(player *database.Player) (consts.StateID, error) {
 		buf.WriteString(fmt.Sprintf("Got skill %s\n", skill.Skills[consts.SkillID(game.Skills[player.ID])].Name()))
 	}
 	buf.WriteString(fmt.Sprintf("Your pokers: %s\n", game.Pokers[player.ID].String()))
-	_ = player.WriteString(buf.String())
+	_ = player.WriteEvent(database.Event{
+		Code:   consts.CodeHandDealt,
+		Msg:    buf.String(),
+		Game:   game.Room.Type,
+		Player: database.EventPlayerOf(player.ID),
+		Cards:  database.Cards(game.Pokers[player.ID]),
+	})
 	loopCount := 0
 	for {
 		loopCount++
@@ -65,6 +75,12 @@ func (g *Game) Next(player *database.Player) (consts.StateID, error) {
 		}
 		log.Infof("[Game.Next] Player %d waiting for state, loop count: %d\n", player.ID, loopCount)
 		state := <-game.States[player.ID]
+		if room.Closed() {
+			return consts.StateWaiting, nil
+		}
+		if state != stateWaiting && database.HandOver(room, player, game.States[player.ID], state) {
+			return consts.StateWaiting, nil
+		}
 		switch state {
 		case stateRob:
 			if !game.Room.EnableLandlord {
@@ -138,6 +154,7 @@ func handleRob(player *database.Player, game *database.Game) error {
 				buf.WriteString(fmt.Sprintf("%s became landlord, got pokers: %s\n", landlord.Name, game.Additional.String()))
 			}
 			database.Broadcast(player.RoomID, buf.String())
+			database.Record(game.Room, landlord.ID, database.ActionRob, fmt.Sprintf("became landlord, got pokers: %s", game.Additional.String()))
 			game.States[landlord.ID] <- statePlay
 		} else {
 			game.FinalRob = true
@@ -159,6 +176,9 @@ func handleRob(player *database.Player, game *database.Game) error {
 		}
 		before := time.Now().Unix()
 		_ = player.WriteString("Are you want to become landlord? (y or n)\n")
+		if player.IsRobot() && loopCount == 1 {
+			player.Answer(robot.Rob(game.Pokers[player.ID]))
+		}
 		ans, err := player.AskForString(timeout)
 		if err != nil && err != consts.ErrorsExist {
 			ans = "n"
@@ -172,9 +192,11 @@ func handleRob(player *database.Player, game *database.Game) error {
 			game.LastRob = player.ID
 			game.Multiple *= 2
 			database.Broadcast(player.RoomID, fmt.Sprintf("%s rob\n", player.Name))
+			database.Record(game.Room, player.ID, database.ActionRob, "rob")
 			break
 		} else if ans == "n" {
 			database.Broadcast(player.RoomID, fmt.Sprintf("%s don't rob\n", player.Name))
+			database.Record(game.Room, player.ID, database.ActionRob, "don't rob")
 			break
 		} else {
 			_ = player.WriteError(consts.ErrorsInputInvalid)
@@ -208,6 +230,13 @@ func playing(player *database.Player, game *database.Game, master bool, playTime
 		_ = player.WriteString(buf.String())
 		before := time.Now().Unix()
 		pokers := game.Pokers[player.ID]
+		if player.IsRobot() && loopCount == 1 {
+			if master {
+				player.Answer(robot.Play(pokers, nil, game.Rules, false))
+			} else {
+				player.Answer(robot.Play(pokers, game.LastFaces, game.Rules, game.IsTeammate(player.ID, game.LastPlayer)))
+			}
+		}
 		ans, err := player.AskForString(timeout)
 		if err != nil {
 			if master {
@@ -231,7 +260,14 @@ func playing(player *database.Player, game *database.Game, master bool, playTime
 				continue
 			} else {
 				nextPlayer := database.GetPlayer(game.NextPlayer(player.ID))
-				database.Broadcast(player.RoomID, fmt.Sprintf("%s passed, next %s\n", player.Name, nextPlayer.Name))
+				database.BroadcastEvent(player.RoomID, database.Event{
+					Code:   consts.CodePlayMade,
+					Msg:    fmt.Sprintf("%s passed, next %s\n", player.Name, nextPlayer.Name),
+					Player: database.EventPlayerOf(player.ID),
+					Next:   database.EventPlayerOf(nextPlayer.ID),
+					Action: database.ActionPass,
+				})
+				database.Record(game.Room, player.ID, database.ActionPass, "")
 				game.States[nextPlayer.ID] <- statePlay
 				return nil
 			}
@@ -309,6 +345,7 @@ func playing(player *database.Player, game *database.Game, master bool, playTime
 		for _, key := range realSellKeys {
 			game.Mnemonic[key]--
 		}
+		countPlay(game, player.ID, *lastFaces)
 		pokers = make(modelx.Pokers, 0)
 		for _, curr := range normalPokers {
 			pokers = append(pokers, curr...)
@@ -320,12 +357,35 @@ func playing(player *database.Player, game *database.Game, master bool, playTime
 		game.LastFaces = lastFaces
 		game.LastPokers = sells
 		game.Discards = append(game.Discards, sells...)
+		database.Record(game.Room, player.ID, database.ActionPlay, sells.OaaString())
 		if len(pokers) == 0 {
-			database.Broadcast(player.RoomID, fmt.Sprintf("%s played %s, won the game! \n", player.Name, sells.OaaString()))
+			winners := make([]int64, 0)
+			for _, playerId := range game.Players {
+				if game.IsTeammate(player.ID, playerId) {
+					winners = append(winners, playerId)
+				}
+			}
+			settlement := settle(game, winners)
+			database.BroadcastEvent(player.RoomID, database.Event{
+				Code:    consts.CodeGameOver,
+				Msg:     fmt.Sprintf("%s played %s, won the game! \n%s", player.Name, sells.OaaString(), settlement),
+				Player:  database.EventPlayerOf(player.ID),
+				Action:  database.ActionPlay,
+				Cards:   database.Cards(sells),
+				Winners: database.EventPlayers(winners...),
+			})
+			database.Record(game.Room, player.ID, database.ActionSettlement, fmt.Sprintf("won the game, %s won\n%s", game.Team(player.ID), settlement))
+			results := database.Results(game.Players, winners...)
+			for i := range results {
+				results[i].Landlord = game.Room.EnableLandlord && game.IsLandlord(results[i].PlayerID)
+			}
+			database.RecordStats(game.Room.Type, results...)
 			room := database.GetRoom(player.RoomID)
 			if room != nil {
+				database.FinishJournal(room)
 				room.Game = nil
 				room.State = consts.RoomStateWaiting
+				database.Settle(room, winners, nil)
 			}
 			for _, playerId := range game.Players {
 				game.States[playerId] <- stateWaiting
@@ -335,12 +395,25 @@ func playing(player *database.Player, game *database.Game, master bool, playTime
 		if master {
 			playTimes--
 			if playTimes > 0 {
-				database.Broadcast(player.RoomID, fmt.Sprintf("%s played %s\n", player.Name, sells.OaaString()))
+				database.BroadcastEvent(player.RoomID, database.Event{
+					Code:   consts.CodePlayMade,
+					Msg:    fmt.Sprintf("%s played %s\n", player.Name, sells.OaaString()),
+					Player: database.EventPlayerOf(player.ID),
+					Action: database.ActionPlay,
+					Cards:  database.Cards(sells),
+				})
 				return playing(player, game, master, playTimes)
 			}
 		}
 		nextPlayer := database.GetPlayer(game.NextPlayer(player.ID))
-		database.Broadcast(player.RoomID, fmt.Sprintf("%s played %s, next %s\n", player.Name, sells.OaaString(), nextPlayer.Name))
+		database.BroadcastEvent(player.RoomID, database.Event{
+			Code:   consts.CodePlayMade,
+			Msg:    fmt.Sprintf("%s played %s, next %s\n", player.Name, sells.OaaString(), nextPlayer.Name),
+			Player: database.EventPlayerOf(player.ID),
+			Next:   database.EventPlayerOf(nextPlayer.ID),
+			Action: database.ActionPlay,
+			Cards:  database.Cards(sells),
+		})
 		game.States[nextPlayer.ID] <- statePlay
 		return nil
 	}
@@ -348,10 +421,16 @@ func playing(player *database.Player, game *database.Game, master bool, playTime
 
 func handlePlay(player *database.Player, game *database.Game) error {
 	master := player.ID == game.LastPlayer || game.LastPlayer == 0
-	database.Broadcast(player.RoomID, fmt.Sprintf("%s turn to play\n", player.Name))
+	database.BroadcastEvent(player.RoomID, database.Event{
+		Code:    consts.CodeTurnStarted,
+		Msg:     fmt.Sprintf("%s turn to play\n", player.Name),
+		Player:  database.EventPlayerOf(player.ID),
+		Timeout: database.Timeout(game.PlayTimeOut[player.ID]),
+	})
 	if master && game.Room.EnableSkill {
 		sk := skill.Skills[consts.SkillID(game.Skills[player.ID])]
 		database.Broadcast(player.RoomID, fmt.Sprintf("%s \n", sk.Desc(player)))
+		database.Record(game.Room, player.ID, database.ActionSkill, sk.Name())
 		sk.Apply(player, game)
 	}
 	return playing(player, game, master, game.PlayTimes[player.ID])
@@ -392,6 +471,10 @@ func InitGame(room *database.Room) (*database.Game, error) {
 		playTimes[players[i]] = 1
 		playTimeout[players[i]] = consts.PlayTimeout
 	}
+	for i := range players {
+		database.Record(room, players[i], database.ActionDeal, pokers[players[i]].String())
+	}
+	database.Record(room, 0, database.ActionDeal, fmt.Sprintf("additional pokers: %s", distributes[len(distributes)-1].String()))
 	states[players[rand.Intn(len(states))]] <- stateRob
 	return &database.Game{
 		Room:        room,
@@ -409,6 +492,8 @@ func InitGame(room *database.Room) (*database.Game, error) {
 		PlayTimeOut: playTimeout,
 		Rules:       rules,
 		Discards:    modelx.Pokers{},
+		Plays:       map[int64]int{},
+		Bombs:       map[int64]int{},
 	}, nil
 }
 
@@ -443,6 +528,13 @@ func resetGame(game *database.Game) error {
 	game.PlayTimes = playTimes
 	game.PlayTimeOut = playTimeout
 	game.Discards = modelx.Pokers{}
+	game.Plays = map[int64]int{}
+	game.Bombs = map[int64]int{}
+	database.Record(game.Room, 0, database.ActionDeal, "all players gave up the landlord, redealing")
+	for i := range players {
+		database.Record(game.Room, players[i], database.ActionDeal, game.Pokers[players[i]].String())
+	}
+	database.Record(game.Room, 0, database.ActionDeal, fmt.Sprintf("additional pokers: %s", game.Additional.String()))
 	return nil
 }
 
@@ -482,6 +574,80 @@ func viewGame(game *database.Game, currPlayer *database.Player) {
 	_ = currPlayer.WriteString(buf.String())
 }
 
+// countPlay 记录玩家出牌的次数，打出炸弹或王炸时倍数翻倍
+func countPlay(game *database.Game, playerId int64, faces modelx.Faces) {
+	game.Plays[playerId]++
+	if faces.Type == constx.FacesBomb || isMax(game, faces) {
+		game.Bombs[playerId]++
+		game.Multiple *= 2
+	}
+}
+
+// settle 按底分乘以倍数结算筹码，每一对胜负双方之间结算一次，筹码不足时只输掉剩余的筹码，返回结算表
+func settle(game *database.Game, winners []int64) string {
+	spring := ""
+	if game.Room.EnableLandlord {
+		landlordWon := game.IsLandlord(winners[0])
+		peasantsPlays, landlordPlays := 0, 0
+		for _, id := range game.Players {
+			if game.IsLandlord(id) {
+				landlordPlays += game.Plays[id]
+			} else {
+				peasantsPlays += game.Plays[id]
+			}
+		}
+		if landlordWon && peasantsPlays == 0 {
+			spring = "spring"
+		} else if !landlordWon && landlordPlays == 1 {
+			spring = "anti-spring"
+		}
+		if spring != "" {
+			game.Multiple *= 2
+		}
+	}
+	score := uint(consts.LandlordBaseScore * game.Multiple)
+
+	deltas := map[int64]int64{}
+	for _, id := range game.Players {
+		if slices.Contains(winners, id) {
+			continue
+		}
+		loser := database.GetPlayer(id)
+		if loser == nil {
+			continue
+		}
+		paid := min(score*uint(len(winners)), loser.Amount)
+		loser.Amount -= paid
+		deltas[id] -= int64(paid)
+		for i, winnerId := range winners {
+			share := paid / uint(len(winners))
+			if i == 0 {
+				share += paid % uint(len(winners))
+			}
+			if winner := database.GetPlayer(winnerId); winner != nil {
+				winner.Amount += share
+				deltas[winnerId] += int64(share)
+			}
+		}
+	}
+	for id := range deltas {
+		database.SavePlayer(database.GetPlayer(id))
+	}
+
+	buf := bytes.Buffer{}
+	buf.WriteString(fmt.Sprintf("Settlement: base %d, multiple x%d, bombs %d", consts.LandlordBaseScore, game.Multiple, game.BombCount()))
+	if spring != "" {
+		buf.WriteString(", " + spring)
+	}
+	buf.WriteString("\n")
+	for _, id := range game.Players {
+		if p := database.GetPlayer(id); p != nil {
+			buf.WriteString(fmt.Sprintf("%-20s%-10s%+-8d amount: %d\n", p.Name, game.Team(id), deltas[id], p.Amount))
+		}
+	}
+	return buf.String()
+}
+
 func isMax(game *database.Game, faces modelx.Faces) bool {
 	if game.Decks == 1 && len(faces.Keys) == 2 {
 		if (faces.Keys[0] == 14 && faces.Keys[1] == 15) || (faces.Keys[0] == 15 && faces.Keys[1] == 14) {
@@ -0,0 +1,130 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	constx "github.com/ratel-online/core/consts"
+	modelx "github.com/ratel-online/core/model"
+	"github.com/ratel-online/server/consts"
+	"github.com/ratel-online/server/database"
+)
+
+func TestSettle(t *testing.T) {
+	room := database.CreateRoom(0, consts.GameTypeClassic)
+	robots := make([]*database.Player, 0, 3)
+	for i := 0; i < 3; i++ {
+		robot, err := database.AddRobot(room.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		robots = append(robots, robot)
+	}
+	landlord, a, b := robots[0], robots[1], robots[2]
+	ids := []int64{landlord.ID, a.ID, b.ID}
+	groups := map[int64]int{landlord.ID: 1, a.ID: 2, b.ID: 2}
+
+	// 地主赢，两位农民各输一倍底分
+	landlord.Amount, a.Amount, b.Amount = 1000, 1000, 1000
+	game := &database.Game{Room: room, Players: ids, Groups: groups, Bombs: map[int64]int{}, Multiple: 1, Decks: 1}
+	game.Plays = map[int64]int{landlord.ID: 2, a.ID: 1, b.ID: 1}
+	settle(game, []int64{landlord.ID})
+	if landlord.Amount != 1020 || a.Amount != 990 || b.Amount != 990 {
+		t.Errorf("landlord wins: %d %d %d", landlord.Amount, a.Amount, b.Amount)
+	}
+
+	// 农民赢
+	landlord.Amount, a.Amount, b.Amount = 1000, 1000, 1000
+	game = &database.Game{Room: room, Players: ids, Groups: groups, Bombs: map[int64]int{}, Multiple: 1, Decks: 1}
+	game.Plays = map[int64]int{landlord.ID: 2, a.ID: 1, b.ID: 1}
+	settle(game, []int64{a.ID, b.ID})
+	if landlord.Amount != 980 || a.Amount != 1010 || b.Amount != 1010 {
+		t.Errorf("peasants win: %d %d %d", landlord.Amount, a.Amount, b.Amount)
+	}
+
+	// 春天：农民一手牌都没有出，倍数翻倍
+	landlord.Amount, a.Amount, b.Amount = 1000, 1000, 1000
+	game = &database.Game{Room: room, Players: ids, Groups: groups, Bombs: map[int64]int{}, Multiple: 1, Decks: 1}
+	game.Plays = map[int64]int{landlord.ID: 3}
+	settle(game, []int64{landlord.ID})
+	if game.Multiple != 2 || landlord.Amount != 1040 || a.Amount != 980 || b.Amount != 980 {
+		t.Errorf("spring: multiple %d, %d %d %d", game.Multiple, landlord.Amount, a.Amount, b.Amount)
+	}
+
+	// 反春天：地主只出了第一手牌
+	landlord.Amount, a.Amount, b.Amount = 1000, 1000, 1000
+	game = &database.Game{Room: room, Players: ids, Groups: groups, Bombs: map[int64]int{}, Multiple: 1, Decks: 1}
+	game.Plays = map[int64]int{landlord.ID: 1, a.ID: 2, b.ID: 1}
+	settle(game, []int64{a.ID, b.ID})
+	if game.Multiple != 2 || landlord.Amount != 960 || a.Amount != 1020 || b.Amount != 1020 {
+		t.Errorf("anti-spring: multiple %d, %d %d %d", game.Multiple, landlord.Amount, a.Amount, b.Amount)
+	}
+
+	// 王炸和炸弹各翻一倍
+	landlord.Amount, a.Amount, b.Amount = 1000, 1000, 1000
+	game = &database.Game{Room: room, Players: ids, Groups: groups, Plays: map[int64]int{}, Bombs: map[int64]int{}, Multiple: 1, Decks: 1}
+	countPlay(game, landlord.ID, modelx.Faces{Type: constx.FacesDouble, Keys: []int{14, 15}})
+	countPlay(game, a.ID, modelx.Faces{Type: constx.FacesBomb, Keys: []int{5, 5, 5, 5}})
+	countPlay(game, landlord.ID, modelx.Faces{Type: constx.FacesSingle, Keys: []int{3}})
+	settle(game, []int64{landlord.ID})
+	if game.Multiple != 4 || landlord.Amount != 1080 || a.Amount != 960 || b.Amount != 960 {
+		t.Errorf("bombs: multiple %d, %d %d %d", game.Multiple, landlord.Amount, a.Amount, b.Amount)
+	}
+
+	// 地主的筹码不够赔时，剩下的筹码由两位农民平分
+	landlord.Amount, a.Amount, b.Amount = 15, 1000, 1000
+	game = &database.Game{Room: room, Players: ids, Groups: groups, Bombs: map[int64]int{}, Multiple: 1, Decks: 1}
+	game.Plays = map[int64]int{landlord.ID: 2, a.ID: 1, b.ID: 1}
+	settle(game, []int64{a.ID, b.ID})
+	if landlord.Amount != 0 || a.Amount != 1008 || b.Amount != 1007 {
+		t.Errorf("landlord short on chips: %d %d %d", landlord.Amount, a.Amount, b.Amount)
+	}
+}
+
+func TestCloseRunningRoom(t *testing.T) {
+	room := database.CreateRoom(0, consts.GameTypeClassic)
+	robots := make([]*database.Player, 0, 3)
+	for i := 0; i < 3; i++ {
+		robot, err := database.AddRobot(room.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		robots = append(robots, robot)
+	}
+	game, err := InitGame(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.Game = game
+	room.State = consts.RoomStateRunning
+	done := make(chan struct{}, len(robots))
+	for _, robot := range robots {
+		go func() {
+			_, _ = (&Game{}).Next(robot)
+			done <- struct{}{}
+		}()
+	}
+	time.Sleep(consts.RobotThinkTime / 2)
+
+	if err = database.CloseRoom(room.ID); err != nil {
+		t.Fatal(err)
+	}
+	for range robots {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("game goroutines are still running after the room was closed")
+		}
+	}
+	// 对局协程全部退出后通道才会关闭
+	deadline := time.After(time.Second)
+	for _, state := range game.States {
+		for open := true; open; {
+			select {
+			case _, open = <-state:
+			case <-deadline:
+				t.Fatal("state channels are not closed")
+			}
+		}
+	}
+}
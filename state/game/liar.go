@@ -11,6 +11,7 @@ import (
 	"github.com/ratel-online/core/util/rand"
 	"github.com/ratel-online/server/consts"
 	"github.com/ratel-online/server/database"
+	"github.com/ratel-online/server/robot"
 )
 
 type Liar struct{}
@@ -33,6 +34,7 @@ func (g *Liar) Next(player *database.Player) (consts.StateID, error) {
 	if room == nil {
 		return 0, player.WriteError(consts.ErrorsExist)
 	}
+	defer room.Track()()
 	game := room.Game.(*database.Liar)
 	buf := bytes.Buffer{}
 
@@ -58,6 +60,12 @@ func (g *Liar) Next(player *database.Player) (consts.StateID, error) {
 		}
 		log.Infof("[Game.Next] Player %d waiting for state, loop count: %d\n", player.ID, loopCount)
 		state := <-game.States[player.ID]
+		if room.Closed() {
+			return consts.StateWaiting, nil
+		}
+		if state == liarStatePlay && database.HandOver(room, player, game.States[player.ID], state) {
+			return consts.StateWaiting, nil
+		}
 		switch state {
 		case liarStatePlay:
 			err := g.handlePlay(player, game)
@@ -99,9 +107,24 @@ func (g *Liar) handlePlay(player *database.Player, game *database.Liar) error {
 	}
 
 	buf.WriteString(fmt.Sprintf("你的手牌: %s\n", game.Hands[player.ID].String()))
-	_ = player.WriteString(buf.String())
-
+	_ = player.WriteEvent(database.Event{
+		Code:    consts.CodeTurnStarted,
+		Msg:     buf.String(),
+		Player:  database.EventPlayerOf(player.ID),
+		Cards:   database.Cards(game.Hands[player.ID]),
+		Timeout: database.Timeout(consts.PlayTimeout),
+	})
+
+	robotAnswered := false
 	for {
+		if player.IsRobot() && !robotAnswered {
+			robotAnswered = true
+			lastCount := 0
+			if hasLastMove {
+				lastCount = len(game.LastPokers)
+			}
+			player.Answer(robot.Liar(game.Hands[player.ID], game.Target.Key, lastCount))
+		}
 		ans, err := player.AskForString(consts.PlayTimeout)
 		if err != nil || ans == "" {
 			// 超时或无输入自动出第一张牌
@@ -191,7 +214,13 @@ func (g *Liar) handlePlay(player *database.Player, game *database.Liar) error {
 		game.LastPlayerID = player.ID
 		game.LastPokers = playedPokers
 
-		database.Broadcast(player.RoomID, fmt.Sprintf("%s 出了 %d 张牌, 剩余张数: %d\n", player.Name, len(playedPokers), len(game.Hands[player.ID])))
+		database.BroadcastEvent(player.RoomID, database.Event{
+			Code:   consts.CodePlayMade,
+			Msg:    fmt.Sprintf("%s 出了 %d 张牌, 剩余张数: %d\n", player.Name, len(playedPokers), len(game.Hands[player.ID])),
+			Player: database.EventPlayerOf(player.ID),
+			Action: database.ActionPlay,
+		})
+		database.Record(game.Room, player.ID, database.ActionPlay, playedPokers.String())
 
 		// 广播给具有观察权限的玩家以及房主（如果开启了详细日志）
 		for id, isSupervisor := range game.Supervisors {
@@ -220,7 +249,15 @@ func (g *Liar) handlePlay(player *database.Player, game *database.Liar) error {
 
 func (g *Liar) handleChallenge(challenger *database.Player, game *database.Liar) {
 	lastPlayer := database.GetPlayer(game.LastPlayerID)
-	database.Broadcast(game.Room.ID, fmt.Sprintf("%s 质疑了 %s 的出牌！\n", challenger.Name, lastPlayer.Name))
+	database.BroadcastEvent(game.Room.ID, database.Event{
+		Code:   consts.CodePlayMade,
+		Msg:    fmt.Sprintf("%s 质疑了 %s 的出牌！\n", challenger.Name, lastPlayer.Name),
+		Player: database.EventPlayerOf(challenger.ID),
+		Target: database.EventPlayerOf(lastPlayer.ID),
+		Action: "challenge",
+		Cards:  database.Cards(game.LastPokers),
+	})
+	database.Record(game.Room, challenger.ID, database.ActionPlay, fmt.Sprintf("质疑 %s，实际出牌: %s", lastPlayer.Name, game.LastPokers.String()))
 	database.Broadcast(game.Room.ID, fmt.Sprintf("%s 实际上出了: %s\n", lastPlayer.Name, game.LastPokers.String()))
 
 	isLying := false
@@ -273,9 +310,11 @@ func (g *Liar) pullTrigger(player *database.Player, game *database.Liar) bool {
 	if game.Bong[player.ID] == game.Bullets[player.ID] {
 		game.Alive[player.ID] = false
 		database.Broadcast(game.Room.ID, fmt.Sprintf("砰！！！%s 被子弹贯穿，倒在了地上。\n", player.Name))
+		database.Record(game.Room, player.ID, database.ActionSkill, fmt.Sprintf("第 %d 次扣动扳机，中弹", game.Bong[player.ID]))
 		return true
 	}
 	database.Broadcast(game.Room.ID, fmt.Sprintf("咔哒。是空枪。%s 活了下来，长舒了一口气。\n", player.Name))
+	database.Record(game.Room, player.ID, database.ActionSkill, fmt.Sprintf("第 %d 次扣动扳机，空枪", game.Bong[player.ID]))
 	return false
 }
 
@@ -297,24 +336,39 @@ func (g *Liar) resetRound(game *database.Liar) {
 		}
 	}
 	database.Broadcast(game.Room.ID, "新的一轮开始了！指示牌已更新，存活玩家手牌已重新发放。\n")
+	recordLiarDeal(game.Room, game.Target, game.PlayerIDs, game.Hands)
 }
 
 func (g *Liar) handleGameEnd(player *database.Player, game *database.Liar) (consts.StateID, error) {
 	room := database.GetRoom(player.RoomID)
 	if room != nil {
 		room.Lock()
+		settled := false
+		winnerID := int64(0)
 		if room.Game != nil {
-			winnerID := g.getLastSurvivor(game)
+			settled = true
+			winnerID = g.getLastSurvivor(game)
 			winnerName := "未知"
 			winner := database.GetPlayer(winnerID)
 			if winner != nil {
 				winnerName = winner.Name
 			}
-			database.Broadcast(player.RoomID, fmt.Sprintf("游戏结束! %s 获得了胜利!\n", winnerName))
+			database.BroadcastEvent(player.RoomID, database.Event{
+				Code:    consts.CodeGameOver,
+				Msg:     fmt.Sprintf("游戏结束! %s 获得了胜利!\n", winnerName),
+				Winners: database.EventPlayers(winnerID),
+			})
+			database.Record(room, winnerID, database.ActionSettlement, "获得了胜利")
+			database.RecordStats(room.Type, database.Results(game.PlayerIDs, winnerID)...)
+			database.FinishJournal(room)
 			room.Game = nil
 			room.State = consts.RoomStateWaiting
 		}
 		room.Unlock()
+		// 锦标赛可能需要操作房间，在释放房间锁之后结算
+		if settled {
+			database.Settle(room, []int64{winnerID}, nil)
+		}
 	}
 
 	return consts.StateWaiting, nil
@@ -421,6 +475,8 @@ func InitLiarGame(room *database.Room) (*database.Liar, error) {
 		hands[id] = deck[i*5 : (i+1)*5]
 	}
 
+	recordLiarDeal(room, target, playerIDs, hands)
+
 	// 随机选择一个玩家开始出牌
 	states[playerIDs[rand.Intn(len(playerIDs))]] <- liarStatePlay
 
@@ -439,6 +495,16 @@ func InitLiarGame(room *database.Room) (*database.Liar, error) {
 	}, nil
 }
 
+// recordLiarDeal 记录指示牌和每位存活玩家的手牌
+func recordLiarDeal(room *database.Room, target *model.Poker, playerIDs []int64, hands map[int64]model.Pokers) {
+	database.Record(room, 0, database.ActionDeal, fmt.Sprintf("指示牌: %s", poker.GetDesc(target.Key)))
+	for _, id := range playerIDs {
+		if hand, ok := hands[id]; ok {
+			database.Record(room, id, database.ActionDeal, hand.String())
+		}
+	}
+}
+
 // 初始化牌堆：八张K，八张Q，八张A，一张大王(S)，一张小王(X)
 func initLiarDeck() model.Pokers {
 	keys := make([]int, 0)
@@ -3,6 +3,7 @@ package game
 import (
 	"bytes"
 	"fmt"
+	"slices"
 	"sort"
 
 	mjconsts "github.com/feel-easy/mahjong/consts"
@@ -10,7 +11,6 @@ import (
 	mjgame "github.com/feel-easy/mahjong/game"
 	"github.com/feel-easy/mahjong/tile"
 	"github.com/feel-easy/mahjong/util"
-	"github.com/feel-easy/mahjong/win"
 	"github.com/ratel-online/core/log"
 	"github.com/ratel-online/core/util/rand"
 	"github.com/ratel-online/server/consts"
@@ -24,35 +24,74 @@ func (g *Mahjong) Next(player *database.Player) (consts.StateID, error) {
 	if room == nil {
 		return 0, player.WriteError(consts.ErrorsExist)
 	}
+	defer room.Track()()
 	game := room.Game.(*database.Mahjong)
 	buf := bytes.Buffer{}
 	buf.WriteString("WELCOME TO MAHJONG GAME!!! \n")
-	buf.WriteString(fmt.Sprintf("%s is Banker! \n", database.GetPlayer(int64(room.Banker)).Name))
-	buf.WriteString(fmt.Sprintf("Your Tiles: %s\n", game.Game.GetPlayerTiles(int(player.ID))))
-	_ = player.WriteString(buf.String())
+	buf.WriteString(fmt.Sprintf("Rules: %s\n", consts.MahjongVariants[game.Variant]))
+	buf.WriteString(fmt.Sprintf("%s is Banker! \n", database.GetPlayer(game.PlayerOf(room.Banker)).Name))
+	buf.WriteString(fmt.Sprintf("Your Tiles: %s\n", game.Game.GetPlayerTiles(game.Seat(player.ID))))
+	if flowers := game.Flowers[game.Seat(player.ID)]; len(flowers) > 0 {
+		buf.WriteString(fmt.Sprintf("Your Flowers: %s\n", tile.ToTileString(flowers)))
+	}
+	_ = player.WriteEvent(database.Event{
+		Code:   consts.CodeHandDealt,
+		Msg:    buf.String(),
+		Game:   room.Type,
+		Player: database.EventPlayerOf(player.ID),
+		Cards:  database.MahjongTiles(game.Game.Players().GetPlayerController(game.Seat(player.ID)).Hand()),
+	})
+	// 四川麻将所有玩家定缺后由庄家开始摸牌，只由完成最后一个定缺的玩家发出信号
+	if game.Variant == consts.MahjongVariantSichuan {
+		declared, err := game.Declare(player)
+		if err != nil {
+			return 0, err
+		}
+		if declared {
+			game.States[game.Game.Current().ID()] <- stateTakeCard
+		}
+	}
 	loopCount := 0
 	for {
 		loopCount++
 		if loopCount%100 == 0 {
 			log.Infof("[Mahjong.Next] Player %d (Room %d) loop count: %d, room.State: %d\n", player.ID, player.RoomID, loopCount, room.State)
 		}
-		if room.State == int(consts.StateWaiting) {
+		if room.State == consts.RoomStateWaiting {
 			log.Infof("[Mahjong.Next] Player %d exiting, room state changed to waiting, loop count: %d\n", player.ID, loopCount)
 			return consts.StateWaiting, nil
 		}
 		log.Infof("[Mahjong.Next] Player %d waiting for state, loop count: %d\n", player.ID, loopCount)
-		state := <-game.States[int(player.ID)]
+		seat := game.States[game.Seat(player.ID)]
+		state := <-seat
+		if room.Closed() {
+			return consts.StateWaiting, nil
+		}
+		if state != stateWaiting && database.HandOver(room, player, seat, state) {
+			return consts.StateWaiting, nil
+		}
 		switch state {
 		case statePlay:
+			game.SetTurn(game.Seat(player.ID), state)
 			err := handlePlayMahjong(room, player, game)
 			if err != nil {
+				// 会话过期的玩家在回合中断开时，由观众接替这个回合，不再结束整局
+				if database.HandOver(room, player, seat, state) {
+					return consts.StateWaiting, nil
+				}
 				return 0, err
 			}
+			game.SetTurn(game.Seat(player.ID), 0)
 		case stateTakeCard:
+			game.SetTurn(game.Seat(player.ID), state)
 			err := handleTake(room, player, game)
 			if err != nil {
+				if database.HandOver(room, player, seat, state) {
+					return consts.StateWaiting, nil
+				}
 				return 0, err
 			}
+			game.SetTurn(game.Seat(player.ID), 0)
 		case stateWaiting:
 			return consts.StateWaiting, nil
 		default:
@@ -66,14 +105,29 @@ func (g *Mahjong) Exit(player *database.Player) consts.StateID {
 	if room == nil {
 		return consts.StateHome
 	}
-	game := room.Game.(*database.Mahjong)
-	if game == nil {
+	game, ok := room.Game.(*database.Mahjong)
+	if !ok || game == nil {
 		return consts.StateHome
 	}
-	for _, playerId := range game.PlayerIDs {
-		game.States[playerId] <- stateWaiting
+	// 离开的玩家把座位交给观众，正在处理的回合由接替的玩家继续
+	seat := game.Seat(player.ID)
+	if database.Vacate(room, player) != nil {
+		if turn := game.Turn(seat); turn != 0 {
+			game.States[seat] <- turn
+		}
+		return consts.StateWaiting
 	}
-	database.Broadcast(player.RoomID, fmt.Sprintf("player %s exit, game over! \n", player.Name))
+	// 没有人可以接替时只能结束这一局
+	for _, state := range game.States {
+		state <- stateWaiting
+	}
+	database.BroadcastEvent(player.RoomID, database.Event{
+		Code:   consts.CodeGameOver,
+		Msg:    fmt.Sprintf("player %s exit, game over! \n", player.Name),
+		Player: database.EventPlayerOf(player.ID),
+	})
+	database.Record(room, player.ID, database.ActionSettlement, "exit, game over")
+	database.FinishJournal(room)
 	database.LeaveRoom(player.RoomID, player.ID)
 	room.Game = nil
 	room.State = consts.RoomStateWaiting
@@ -82,27 +136,37 @@ func (g *Mahjong) Exit(player *database.Player) consts.StateID {
 
 func handleTake(room *database.Room, player *database.Player, game *database.Mahjong) error {
 	p := game.Game.Current()
-	if p.ID() != int(player.ID) {
+	if p.ID() != game.Seat(player.ID) {
 		game.States[p.ID()] <- stateTakeCard
 		return nil
 	}
-	if game.Game.Deck().NoTiles() {
-		database.Broadcast(room.ID, "Game over but no winners!!! \n")
-		room.Game = nil
-		room.State = consts.RoomStateWaiting
-		for _, playerId := range game.PlayerIDs {
-			game.States[playerId] <- stateWaiting
+	if game.NoTiles() {
+		if len(game.Finished) == 0 {
+			database.BroadcastEvent(room.ID, database.Event{
+				Code: consts.CodeGameOver,
+				Msg:  fmt.Sprintf("Game over but no winners!!! \n%s stays the banker\n", database.GetPlayer(int64(room.Banker)).Name),
+			})
+			database.Record(room, 0, database.ActionSettlement, "no winners")
+		} else {
+			database.BroadcastEvent(room.ID, database.Event{
+				Code:    consts.CodeGameOver,
+				Msg:     "No tiles left, game over! \n",
+				Winners: database.EventPlayers(game.Winners()...),
+			})
+			database.Record(room, 0, database.ActionSettlement, "no tiles left")
 		}
+		mahjongOver(room, game)
 		return nil
 	}
 
-	gameState := game.Game.ExtractState(p)
+	gameState := game.ExtractState(p.ID())
 	if len(gameState.SpecialPrivileges) > 0 {
 		_, ok, err := p.Take(gameState, game.Game.Deck(), game.Game.Pile())
 		if err != nil {
 			return err
 		}
 		if ok {
+			game.Discarded = false
 			game.States[p.ID()] <- statePlay
 			return nil
 		}
@@ -114,35 +178,48 @@ func handleTake(room *database.Room, player *database.Player, game *database.Mah
 			}
 			if gameState.OriginallyPlayer.ID() == p.ID() {
 				log.Infof("[handleTake] Player %d found originally player, loop count: %d\n", p.ID(), loopCount)
-				p.TryTopDecking(game.Game.Deck())
+				game.Draw(p.ID(), 1)
 				game.States[p.ID()] <- statePlay
 				return nil
 			}
 			p = game.Game.Next()
 		}
 	}
-	p.TryTopDecking(game.Game.Deck())
+	game.Draw(p.ID(), 1)
 	game.States[p.ID()] <- statePlay
 	return nil
 }
 
 func handlePlayMahjong(room *database.Room, player *database.Player, game *database.Mahjong) error {
 	p := game.Game.Current()
-	if p.ID() != int(player.ID) {
+	if p.ID() != game.Seat(player.ID) {
 		game.States[p.ID()] <- statePlay
 		return nil
 	}
-	gameState := game.Game.ExtractState(p)
-	if win.CanWin(p.Hand(), p.GetShowCardTiles()) {
+	gameState := game.ExtractState(p.ID())
+	if game.CanWin(p.ID(), p.Hand(), true) {
 		tiles := p.Tiles()
 		sort.Ints(tiles)
-		database.Broadcast(room.ID, fmt.Sprintf("%s wins! \n%s \n", p.Name(), tile.ToTileString(tiles)))
-		room.Game = nil
-		room.Banker = p.ID()
-		room.State = consts.RoomStateWaiting
-		for _, playerId := range game.PlayerIDs {
-			game.States[playerId] <- stateWaiting
+		winner := game.PlayerOf(p.ID())
+		settlement := mahjongSettle(room, game, []int64{winner}, map[int64][]database.MahjongFan{
+			winner: game.Fans(p.ID(), p.Hand(), true),
+		}, 0)
+		database.BroadcastEvent(room.ID, database.Event{
+			Code:    consts.CodeGameOver,
+			Msg:     fmt.Sprintf("%s wins! \n%s \n%s", p.Name(), tile.ToTileString(tiles), settlement),
+			Player:  database.EventPlayerOf(game.PlayerOf(p.ID())),
+			Action:  "self-drawn",
+			Cards:   database.MahjongTiles(tiles),
+			Winners: database.EventPlayers(game.PlayerOf(p.ID())),
+		})
+		database.Record(room, winner, database.ActionSettlement, "self-drawn win "+tile.ToTileString(tiles)+"\n"+settlement)
+		game.Finish(p.ID())
+		if game.Over() {
+			mahjongOver(room, game)
+			return nil
 		}
+		// 血战到底，由下一位还没有胡牌的玩家摸牌
+		game.States[game.Next()] <- stateTakeCard
 		return nil
 	}
 
@@ -151,26 +228,55 @@ func handlePlayMahjong(room *database.Room, player *database.Player, game *datab
 		return err
 	}
 	game.Game.Pile().Add(til)
+	database.Record(room, player.ID, database.ActionPlay, tile.Tile(til).String())
 	game.Game.Pile().SetLastPlayer(p)
 	event.TilePlayed.Emit(event.TilePlayedPayload{
 		PlayerName: p.Name(),
 		Tile:       til,
 	})
-	pc := game.Game.Next()
+	game.Discarded = true
+	game.Next()
+	pc := game.Game.Current()
 	game.Game.Pile().SetOriginallyPlayer(pc)
-	gameState = game.Game.ExtractState(p)
+	gameState = game.ExtractState(p.ID())
 	if len(gameState.CanWin) > 0 {
+		winners := make([]int64, 0, len(gameState.CanWin))
+		fans := map[int64][]database.MahjongFan{}
+		for _, p := range gameState.CanWin {
+			winner := game.PlayerOf(p.ID())
+			winners = append(winners, winner)
+			hand := append(p.Hand(), gameState.LastPlayedTile)
+			fans[winner] = game.Fans(p.ID(), hand, false)
+		}
+		settlement := mahjongSettle(room, game, winners, fans, player.ID)
 		for _, p := range gameState.CanWin {
 			tiles := append(p.Tiles(), gameState.LastPlayedTile)
 			sort.Ints(tiles)
-			database.Broadcast(room.ID, fmt.Sprintf("%s wins! \n%s \n", p.Name(), tile.ToTileString(tiles)))
+			database.BroadcastEvent(room.ID, database.Event{
+				Code:    consts.CodeGameOver,
+				Msg:     fmt.Sprintf("%s wins on %s's discard! \n%s \n", p.Name(), player.Name, tile.ToTileString(tiles)),
+				Player:  database.EventPlayerOf(game.PlayerOf(p.ID())),
+				Cards:   database.MahjongTiles(tiles),
+				Winners: database.EventPlayers(game.PlayerOf(p.ID())),
+			})
+			database.Record(room, game.PlayerOf(p.ID()), database.ActionSettlement, "win "+tile.ToTileString(tiles))
 		}
-		room.Game = nil
-		room.Banker = gameState.CanWin[rand.Intn(len(gameState.CanWin))].ID()
-		room.State = consts.RoomStateWaiting
-		for _, playerId := range game.PlayerIDs {
-			game.States[playerId] <- stateWaiting
+		database.Broadcast(room.ID, settlement)
+		database.Record(room, player.ID, database.ActionSettlement, "discarded the winning tile\n"+settlement)
+		for _, p := range gameState.CanWin {
+			game.Finish(p.ID())
 		}
+		if game.Over() {
+			mahjongOver(room, game)
+			return nil
+		}
+		// 血战到底，由最后一位胡牌的玩家的下家摸牌
+		last := gameState.CanWin[len(gameState.CanWin)-1].ID()
+		for game.Game.Current().ID() != last {
+			game.Game.Next()
+		}
+		game.Discarded = false
+		game.States[game.Next()] <- stateTakeCard
 		return nil
 	}
 	if len(gameState.SpecialPrivileges) > 0 {
@@ -199,26 +305,119 @@ func handlePlayMahjong(room *database.Room, player *database.Player, game *datab
 				game.States[pc.ID()] <- stateTakeCard
 				return nil
 			}
-			pc = game.Game.Next()
+			game.Game.Next()
+			pc = game.Game.Current()
 		}
 	}
 	game.States[pc.ID()] <- stateTakeCard
 	return nil
 }
 
+// mahjongSettle 按番数结算，每番 consts.MahjongBaseScore 分：自摸时其他每位还没有胡牌的玩家各输给赢家，
+// 点炮时由打出这张牌的玩家输给每位赢家。筹码不足时只输掉剩余的筹码。
+// 庄家胡牌时连庄，否则由第一位胡牌的玩家坐庄，返回番种和结算表
+func mahjongSettle(room *database.Room, game *database.Mahjong, winners []int64, fans map[int64][]database.MahjongFan, discarder int64) string {
+	deltas := map[int64]int64{}
+	transfer := func(from, to int64, amount uint) {
+		loser, gainer := database.GetPlayer(from), database.GetPlayer(to)
+		if loser == nil || gainer == nil {
+			return
+		}
+		amount = min(amount, loser.Amount)
+		loser.Amount -= amount
+		gainer.Amount += amount
+		deltas[from] -= int64(amount)
+		deltas[to] += int64(amount)
+	}
+	buf := bytes.Buffer{}
+	buf.WriteString(fmt.Sprintf("Settlement: %d per fan\n", consts.MahjongBaseScore))
+	for _, winner := range winners {
+		total := database.MahjongFanTotal(fans[winner])
+		score := uint(total * consts.MahjongBaseScore)
+		if discarder != 0 {
+			transfer(discarder, winner, score)
+		} else {
+			for _, id := range game.PlayerIDs {
+				if int64(id) != winner && game.Active(game.Seat(int64(id))) {
+					transfer(int64(id), winner, score)
+				}
+			}
+		}
+		buf.WriteString(fmt.Sprintf("%s: ", database.GetPlayer(winner).Name))
+		for i, f := range fans[winner] {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(fmt.Sprintf("%s %d", f.Name, f.Fan))
+		}
+		buf.WriteString(fmt.Sprintf(" = %d fan(s)\n", total))
+	}
+	for id := range deltas {
+		database.SavePlayer(database.GetPlayer(id))
+	}
+	for _, id := range game.PlayerIDs {
+		p := database.GetPlayer(int64(id))
+		if p == nil {
+			continue
+		}
+		buf.WriteString(fmt.Sprintf("%-20s%+-8d amount: %d\n", p.Name, deltas[int64(id)], p.Amount))
+	}
+	// 血战到底时只有第一次胡牌决定下一局的庄家
+	if len(game.Finished) > 0 {
+		return buf.String()
+	}
+	if !slices.Contains(winners, int64(room.Banker)) {
+		room.Banker = int(winners[0])
+		buf.WriteString(fmt.Sprintf("%s becomes the banker\n", database.GetPlayer(winners[0]).Name))
+	} else {
+		buf.WriteString(fmt.Sprintf("%s stays the banker\n", database.GetPlayer(int64(room.Banker)).Name))
+	}
+	return buf.String()
+}
+
+// mahjongOver 这一局结束，胡牌的玩家记为胜者
+func mahjongOver(room *database.Room, game *database.Mahjong) {
+	winners := game.Winners()
+	database.RecordStats(room.Type, database.Results(game.PlayerIDs, winners...)...)
+	database.FinishJournal(room)
+	room.Game = nil
+	room.State = consts.RoomStateWaiting
+	database.Settle(room, winners, nil)
+	for _, state := range game.States {
+		state <- stateWaiting
+	}
+}
+
 func InitMahjongGame(room *database.Room) (*database.Mahjong, error) {
 	playerIDs := make([]int, 0, room.Players)
 	mjPlayers := make([]mjgame.Player, 0, room.Players)
+	seats := make([]*database.MahjongPlayer, 0, room.Players)
 	states := map[int]chan int{}
 	roomPlayers := database.RoomPlayers(room.ID)
 	for playerId := range roomPlayers {
 		player := database.GetPlayer(playerId)
-		mjPlayers = append(mjPlayers, database.NewPlayer(player))
+		seat := database.NewPlayer(player)
+		mjPlayers = append(mjPlayers, seat)
+		seats = append(seats, seat)
 		playerIDs = append(playerIDs, int(player.ID))
-		states[int(playerId)] = make(chan int, 1)
+		states[seat.Seat] = make(chan int, 1)
+	}
+	game := &database.Mahjong{
+		Room:      room,
+		PlayerIDs: playerIDs,
+		Seats:     seats,
+		States:    states,
+		Game:      mjgame.New(mjPlayers),
+		Variant:   room.MahjongVariant,
+		Wall:      database.NewMahjongWall(room.MahjongVariant),
+		Flowers:   map[int][]int{},
+		Missing:   map[int]int{},
+		Turns:     map[int]int{},
+	}
+	game.Deal()
+	for _, id := range playerIDs {
+		database.Record(room, int64(id), database.ActionDeal, game.Game.GetPlayerTiles(id))
 	}
-	mahjong := mjgame.New(mjPlayers)
-	mahjong.DealStartingTiles()
 	if room.Banker == 0 || !util.IntInSlice(room.Banker, playerIDs) {
 		room.Banker = playerIDs[rand.Intn(len(playerIDs))]
 	}
@@ -226,19 +425,17 @@ func InitMahjongGame(room *database.Room) (*database.Mahjong, error) {
 	for {
 		loopCount++
 		if loopCount%100 == 0 {
-			log.Infof("[InitMahjongGame] Room %d finding banker loop count: %d, current: %d, target: %d\n", room.ID, loopCount, mahjong.Current().ID(), room.Banker)
+			log.Infof("[InitMahjongGame] Room %d finding banker loop count: %d, current: %d, target: %d\n", room.ID, loopCount, game.Game.Current().ID(), room.Banker)
 		}
-		if mahjong.Current().ID() == room.Banker {
+		if game.Game.Current().ID() == room.Banker {
 			log.Infof("[InitMahjongGame] Room %d found banker, loop count: %d\n", room.ID, loopCount)
 			break
 		}
-		mahjong.Next()
+		game.Game.Next()
 	}
-	states[mahjong.Current().ID()] <- stateTakeCard
-	return &database.Mahjong{
-		Room:      room,
-		PlayerIDs: playerIDs,
-		States:    states,
-		Game:      mahjong,
-	}, nil
+	// 四川麻将在所有玩家定缺后才开始
+	if game.Variant != consts.MahjongVariantSichuan {
+		states[game.Game.Current().ID()] <- stateTakeCard
+	}
+	return game, nil
 }
@@ -0,0 +1,59 @@
+package game
+
+import (
+	"testing"
+
+	"github.com/ratel-online/server/consts"
+	"github.com/ratel-online/server/database"
+)
+
+func TestMahjongSettle(t *testing.T) {
+	room := database.CreateRoom(0, consts.GameTypeClassic)
+	robots := make([]*database.Player, 0, 3)
+	for i := 0; i < 3; i++ {
+		robot, err := database.AddRobot(room.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		robots = append(robots, robot)
+	}
+	a, b, c := robots[0], robots[1], robots[2]
+	seats := []*database.MahjongPlayer{database.NewPlayer(a), database.NewPlayer(b), database.NewPlayer(c)}
+	playerIDs := []int{int(a.ID), int(b.ID), int(c.ID)}
+	oneFan := []database.MahjongFan{{Name: "胡牌", Fan: 1}}
+
+	// 庄家自摸，其他玩家各付一番，庄家连庄
+	a.Amount, b.Amount, c.Amount = 1000, 1000, 1000
+	room.Banker = int(a.ID)
+	game := &database.Mahjong{Room: room, PlayerIDs: playerIDs, Seats: seats}
+	mahjongSettle(room, game, []int64{a.ID}, map[int64][]database.MahjongFan{a.ID: oneFan}, 0)
+	if a.Amount != 1020 || b.Amount != 990 || c.Amount != 990 || room.Banker != int(a.ID) {
+		t.Errorf("banker self-drawn: %d %d %d, banker %d", a.Amount, b.Amount, c.Amount, room.Banker)
+	}
+
+	// 点炮只由点炮的玩家付，胡牌的玩家坐庄
+	a.Amount, b.Amount, c.Amount = 1000, 1000, 1000
+	game = &database.Mahjong{Room: room, PlayerIDs: playerIDs, Seats: seats}
+	mahjongSettle(room, game, []int64{b.ID}, map[int64][]database.MahjongFan{b.ID: oneFan}, c.ID)
+	if a.Amount != 1000 || b.Amount != 1010 || c.Amount != 990 || room.Banker != int(b.ID) {
+		t.Errorf("discard win: %d %d %d, banker %d", a.Amount, b.Amount, c.Amount, room.Banker)
+	}
+
+	// 点炮的玩家筹码不够时只付剩下的筹码
+	a.Amount, b.Amount, c.Amount = 1000, 1000, 4
+	room.Banker = int(a.ID)
+	game = &database.Mahjong{Room: room, PlayerIDs: playerIDs, Seats: seats}
+	mahjongSettle(room, game, []int64{b.ID}, map[int64][]database.MahjongFan{b.ID: oneFan}, c.ID)
+	if a.Amount != 1000 || b.Amount != 1004 || c.Amount != 0 {
+		t.Errorf("short discarder: %d %d %d", a.Amount, b.Amount, c.Amount)
+	}
+
+	// 血战到底时之后的胡牌不再改变庄家
+	a.Amount, b.Amount, c.Amount = 1000, 1000, 1000
+	room.Banker = int(a.ID)
+	game = &database.Mahjong{Room: room, PlayerIDs: playerIDs, Seats: seats, Finished: []int{int(a.ID)}}
+	mahjongSettle(room, game, []int64{c.ID}, map[int64][]database.MahjongFan{c.ID: oneFan}, b.ID)
+	if a.Amount != 1000 || b.Amount != 990 || c.Amount != 1010 || room.Banker != int(a.ID) {
+		t.Errorf("later win: %d %d %d, banker %d", a.Amount, b.Amount, c.Amount, room.Banker)
+	}
+}
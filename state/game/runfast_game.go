@@ -9,11 +9,13 @@ import (
 
 	"github.com/ratel-online/core/util/rand"
 
+	constx "github.com/ratel-online/core/consts"
 	"github.com/ratel-online/core/log"
 	modelx "github.com/ratel-online/core/model"
 	"github.com/ratel-online/core/util/poker"
 	"github.com/ratel-online/server/consts"
 	"github.com/ratel-online/server/database"
+	"github.com/ratel-online/server/robot"
 	"github.com/ratel-online/server/rule"
 	"github.com/ratel-online/server/skill"
 )
@@ -25,11 +27,18 @@ func (g *RunFastGame) Next(player *database.Player) (consts.StateID, error) {
 	if room == nil {
 		return 0, player.WriteError(consts.ErrorsExist)
 	}
+	defer room.Track()()
 	game := room.Game.(*database.Game)
 	buf := bytes.Buffer{}
 	buf.WriteString(fmt.Sprintf("Game starting!\n"))
 	buf.WriteString(fmt.Sprintf("Your pokers: %s\n", game.Pokers[player.ID].String()))
-	_ = player.WriteString(buf.String())
+	_ = player.WriteEvent(database.Event{
+		Code:   consts.CodeHandDealt,
+		Msg:    buf.String(),
+		Game:   game.Room.Type,
+		Player: database.EventPlayerOf(player.ID),
+		Cards:  database.Cards(game.Pokers[player.ID]),
+	})
 	loopCount := 0
 	for {
 		loopCount++
@@ -42,6 +51,12 @@ func (g *RunFastGame) Next(player *database.Player) (consts.StateID, error) {
 		}
 		log.Infof("[RunFastGame.Next] Player %d waiting for state, loop count: %d\n", player.ID, loopCount)
 		state := <-game.States[player.ID]
+		if room.Closed() {
+			return consts.StateWaiting, nil
+		}
+		if state != stateWaiting && database.HandOver(room, player, game.States[player.ID], state) {
+			return consts.StateWaiting, nil
+		}
 		switch state {
 		case stateRob:
 			for i, id := range game.Players {
@@ -93,11 +108,25 @@ func runFastPlaying(player *database.Player, game *database.Game, master bool, p
 			list := poker.RunFastComparativeFaces(*game.LastFaces, game.Pokers[player.ID], rule.RunFastRules)
 			if len(list) == 0 {
 				nextPlayer := database.GetPlayer(game.NextPlayer(player.ID))
-				database.Broadcast(player.RoomID, fmt.Sprintf("%s auto passed, next %s\n", player.Name, nextPlayer.Name))
+				database.BroadcastEvent(player.RoomID, database.Event{
+					Code:   consts.CodePlayMade,
+					Msg:    fmt.Sprintf("%s auto passed, next %s\n", player.Name, nextPlayer.Name),
+					Player: database.EventPlayerOf(player.ID),
+					Next:   database.EventPlayerOf(nextPlayer.ID),
+					Action: database.ActionPass,
+				})
+				database.Record(game.Room, player.ID, database.ActionPass, "auto")
 				game.States[nextPlayer.ID] <- statePlay
 				return nil
 			}
 		}
+		if player.IsRobot() && loopCount == 1 {
+			if master {
+				player.Answer(robot.RunFast(pokers, nil, game.Rules))
+			} else {
+				player.Answer(robot.RunFast(pokers, game.LastFaces, game.Rules))
+			}
+		}
 		ans, err := player.AskForString(timeout)
 		if err != nil {
 			if master {
@@ -139,7 +168,14 @@ func runFastPlaying(player *database.Player, game *database.Game, master bool, p
 					continue
 				} else {
 					nextPlayer := database.GetPlayer(game.NextPlayer(player.ID))
-					database.Broadcast(player.RoomID, fmt.Sprintf("%s passed, next %s\n", player.Name, nextPlayer.Name))
+					database.BroadcastEvent(player.RoomID, database.Event{
+						Code:   consts.CodePlayMade,
+						Msg:    fmt.Sprintf("%s passed, next %s\n", player.Name, nextPlayer.Name),
+						Player: database.EventPlayerOf(player.ID),
+						Next:   database.EventPlayerOf(nextPlayer.ID),
+						Action: database.ActionPass,
+					})
+					database.Record(game.Room, player.ID, database.ActionPass, "")
 					game.States[nextPlayer.ID] <- statePlay
 					return nil
 				}
@@ -225,6 +261,10 @@ func runFastPlaying(player *database.Player, game *database.Game, master bool, p
 		for _, key := range realSellKeys {
 			game.Mnemonic[key]--
 		}
+		game.Plays[player.ID]++
+		if lastFaces.Type == constx.FacesBomb || runFastIsMax(*lastFaces) {
+			game.Bombs[player.ID]++
+		}
 		pokers = make(modelx.Pokers, 0)
 		for _, curr := range normalPokers {
 			pokers = append(pokers, curr...)
@@ -236,12 +276,25 @@ func runFastPlaying(player *database.Player, game *database.Game, master bool, p
 		game.LastFaces = lastFaces
 		game.LastPokers = sells
 		game.Discards = append(game.Discards, sells...)
+		database.Record(game.Room, player.ID, database.ActionPlay, sells.OaaString())
 		if len(pokers) == 0 {
-			database.Broadcast(player.RoomID, fmt.Sprintf("%s played %s, won the game! \n", player.Name, sells.OaaString()))
+			settlement := runFastSettle(game, player.ID)
+			database.BroadcastEvent(player.RoomID, database.Event{
+				Code:    consts.CodeGameOver,
+				Msg:     fmt.Sprintf("%s played %s, won the game! \n%s", player.Name, sells.OaaString(), settlement),
+				Player:  database.EventPlayerOf(player.ID),
+				Action:  database.ActionPlay,
+				Cards:   database.Cards(sells),
+				Winners: database.EventPlayers(player.ID),
+			})
+			database.Record(game.Room, player.ID, database.ActionSettlement, "won the game\n"+settlement)
+			database.RecordStats(game.Room.Type, database.Results(game.Players, player.ID)...)
 			room := database.GetRoom(player.RoomID)
 			if room != nil {
+				database.FinishJournal(room)
 				room.Game = nil
 				room.State = consts.RoomStateWaiting
+				database.Settle(room, []int64{player.ID}, nil)
 			}
 			for _, playerId := range game.Players {
 				game.States[playerId] <- stateWaiting
@@ -251,12 +304,25 @@ func runFastPlaying(player *database.Player, game *database.Game, master bool, p
 		if master {
 			playTimes--
 			if playTimes > 0 {
-				database.Broadcast(player.RoomID, fmt.Sprintf("%s played %s\n", player.Name, sells.OaaString()))
+				database.BroadcastEvent(player.RoomID, database.Event{
+					Code:   consts.CodePlayMade,
+					Msg:    fmt.Sprintf("%s played %s\n", player.Name, sells.OaaString()),
+					Player: database.EventPlayerOf(player.ID),
+					Action: database.ActionPlay,
+					Cards:  database.Cards(sells),
+				})
 				return runFastPlaying(player, game, master, playTimes)
 			}
 		}
 		nextPlayer := database.GetPlayer(game.NextPlayer(player.ID))
-		database.Broadcast(player.RoomID, fmt.Sprintf("%s played %s, next %s\n", player.Name, sells.OaaString(), nextPlayer.Name))
+		database.BroadcastEvent(player.RoomID, database.Event{
+			Code:   consts.CodePlayMade,
+			Msg:    fmt.Sprintf("%s played %s, next %s\n", player.Name, sells.OaaString(), nextPlayer.Name),
+			Player: database.EventPlayerOf(player.ID),
+			Next:   database.EventPlayerOf(nextPlayer.ID),
+			Action: database.ActionPlay,
+			Cards:  database.Cards(sells),
+		})
 		game.States[nextPlayer.ID] <- statePlay
 		return nil
 	}
@@ -264,7 +330,12 @@ func runFastPlaying(player *database.Player, game *database.Game, master bool, p
 
 func runFastHandlePlay(player *database.Player, game *database.Game) error {
 	master := player.ID == game.LastPlayer || game.LastPlayer == 0
-	database.Broadcast(player.RoomID, fmt.Sprintf("%s turn to play\n", player.Name))
+	database.BroadcastEvent(player.RoomID, database.Event{
+		Code:    consts.CodeTurnStarted,
+		Msg:     fmt.Sprintf("%s turn to play\n", player.Name),
+		Player:  database.EventPlayerOf(player.ID),
+		Timeout: database.Timeout(game.PlayTimeOut[player.ID]),
+	})
 	return runFastPlaying(player, game, master, game.PlayTimes[player.ID])
 }
 
@@ -314,6 +385,9 @@ func InitRunFastGame(room *database.Room, rules poker.Rules) (*database.Game, er
 	} else {
 		FirstPlayerId = FirstPlayerIds[rand.Intn(len(FirstPlayerIds)-1)]
 	}
+	for i := range players {
+		database.Record(room, players[i], database.ActionDeal, pokers[players[i]].String())
+	}
 	states[players[rand.Intn(len(states))]] <- stateRob
 	return &database.Game{
 		FirstPlayer: FirstPlayerId,
@@ -332,6 +406,8 @@ func InitRunFastGame(room *database.Room, rules poker.Rules) (*database.Game, er
 		PlayTimeOut: playTimeout,
 		Rules:       rules,
 		Discards:    modelx.Pokers{},
+		Plays:       map[int64]int{},
+		Bombs:       map[int64]int{},
 	}, nil
 }
 
@@ -365,6 +441,65 @@ func runFastViewGame(game *database.Game, currPlayer *database.Player) {
 	_ = currPlayer.WriteString(buf.String())
 }
 
+// runFastSettle 输家按剩余手牌数输给赢家，一张牌都没出过（关门）时加倍；
+// 炸弹另外从其他每位玩家处各得到奖励。筹码不足时只输掉剩余的筹码，返回结算表
+func runFastSettle(game *database.Game, winner int64) string {
+	deltas := map[int64]int64{}
+	closed := map[int64]bool{}
+	transfer := func(from, to int64, amount uint) {
+		loser, gainer := database.GetPlayer(from), database.GetPlayer(to)
+		if loser == nil || gainer == nil {
+			return
+		}
+		amount = min(amount, loser.Amount)
+		loser.Amount -= amount
+		gainer.Amount += amount
+		deltas[from] -= int64(amount)
+		deltas[to] += int64(amount)
+	}
+	for _, id := range game.Players {
+		if id == winner {
+			continue
+		}
+		score := uint(len(game.Pokers[id]) * consts.RunFastCardScore)
+		if game.Plays[id] == 0 {
+			closed[id] = true
+			score *= 2
+		}
+		transfer(id, winner, score)
+	}
+	// 按座位顺序结算炸弹奖励，筹码不足时谁先得到赔付是确定的
+	for _, bomber := range game.Players {
+		n := game.Bombs[bomber]
+		if n == 0 {
+			continue
+		}
+		for _, id := range game.Players {
+			if id != bomber {
+				transfer(id, bomber, uint(n*consts.RunFastBombScore))
+			}
+		}
+	}
+	for id := range deltas {
+		database.SavePlayer(database.GetPlayer(id))
+	}
+
+	buf := bytes.Buffer{}
+	buf.WriteString(fmt.Sprintf("Settlement: %d per card left, bomb bonus %d\n", consts.RunFastCardScore, consts.RunFastBombScore))
+	for _, id := range game.Players {
+		p := database.GetPlayer(id)
+		if p == nil {
+			continue
+		}
+		buf.WriteString(fmt.Sprintf("%-20scards left: %-4dbombs: %-4d%+-8d amount: %d", p.Name, len(game.Pokers[id]), game.Bombs[id], deltas[id], p.Amount))
+		if closed[id] {
+			buf.WriteString(" (closed door)")
+		}
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
 func runFastIsMax(faces modelx.Faces) bool {
 	if len(faces.Keys) != 4 {
 		return false
@@ -9,20 +9,31 @@ import (
 	"github.com/ratel-online/core/log"
 	"github.com/ratel-online/server/consts"
 	"github.com/ratel-online/server/database"
+	"github.com/ratel-online/server/robot"
+	"github.com/ratel-online/server/rule"
 	"github.com/spf13/cast"
 )
 
 func bet(player *database.Player, game *database.Texas) error {
 	texasPlayer := game.Player(player.ID)
 
-	if game.RoundEnd(player.ID) {
+	// 换人弃牌后只剩一名玩家时直接结算
+	if game.Folded == len(game.Players)-1 {
+		return settlementRound(game)
+	}
+	if game.RoundEnd() {
 		return nextRound(game)
 	}
 	if texasPlayer.Folded || texasPlayer.AllIn {
 		return nextPlayer(player, game, stateBet)
 	}
 
-	database.Broadcast(player.RoomID, fmt.Sprintf("%s's turn to bet\n", player.Name), player.ID)
+	database.BroadcastEvent(player.RoomID, database.Event{
+		Code:    consts.CodeTurnStarted,
+		Msg:     fmt.Sprintf("%s's turn to bet\n", player.Name),
+		Player:  database.EventPlayerOf(player.ID),
+		Timeout: database.Timeout(consts.BetTimeout),
+	}, player.ID)
 
 	timeout := consts.BetTimeout
 	loopCount := 0
@@ -34,12 +45,19 @@ func bet(player *database.Player, game *database.Texas) error {
 		before := time.Now().Unix()
 
 		buf := bytes.Buffer{}
+		buf.WriteString(fmt.Sprintf("Hand #%d, %s\n", game.Hands, blindsString(game)))
 		buf.WriteString(fmt.Sprintf("Your hand: %s\n", texasPlayer.Hand.TexasString()))
+		if game.Room.EnableTraining && !player.IsRobot() && loopCount == 1 {
+			buf.WriteString(trainingHint(game, texasPlayer))
+		}
 		for _, p := range game.Players {
 			status := "betting"
 			if p.Folded {
 				status = "folded"
 			}
+			if p.Out {
+				status = "out"
+			}
 			if p.AllIn {
 				status = "all in"
 			}
@@ -49,8 +67,23 @@ func bet(player *database.Player, game *database.Texas) error {
 			}
 			buf.WriteString(fmt.Sprintf("%s amount %d, total bets %d, status: %s\n", name, p.Amount(), p.Bets, status))
 		}
+		buf.WriteString(betHint(game, texasPlayer))
 		buf.WriteString("What do you want to do? (call/raise/fold/check/allin)\n")
-		_ = player.WriteString(buf.String())
+		_ = player.WriteEvent(database.Event{
+			Code:    consts.CodeTurnStarted,
+			Msg:     buf.String(),
+			Player:  database.EventPlayerOf(player.ID),
+			Cards:   database.TexasCards(texasPlayer.Hand),
+			Amount:  game.MaxBetAmount - texasPlayer.Bets,
+			Timeout: database.Timeout(timeout),
+		})
+		minRaise, maxRaise, canRaise := game.RaiseRange(texasPlayer)
+		if player.IsRobot() && loopCount == 1 {
+			if !canRaise {
+				minRaise, maxRaise = 0, 0
+			}
+			player.Answer(robot.Bet(texasPlayer.Hand, game.Board, game.MaxBetAmount-texasPlayer.Bets, texasPlayer.Amount(), game.Pot, minRaise, maxRaise))
+		}
 		ans, err := player.AskForString(timeout)
 		if err != nil {
 			ans = "fold"
@@ -70,31 +103,44 @@ func bet(player *database.Player, game *database.Texas) error {
 				continue
 			}
 			game.Bet(texasPlayer, minCall)
-			database.Broadcast(player.RoomID, fmt.Sprintf("%s call, bet %d\n", player.Name, minCall))
+			broadcastBet(player, "call", minCall, fmt.Sprintf("%s call, bet %d\n", player.Name, minCall))
+			database.Record(game.Room, player.ID, database.ActionBet, fmt.Sprintf("call %d", minCall))
 		case "raise":
-			if len(instructions) <= 1 || instructions[1] == "" {
+			if !canRaise {
+				_ = player.WriteString("You can't raise now, please call, check or fold\n")
+				continue
+			}
+			betAmount := minRaise
+			if len(instructions) > 1 && instructions[1] != "" {
+				betAmount, err = cast.ToUintE(instructions[1])
+				if err != nil {
+					_ = player.WriteString("Invalid amount\n")
+					continue
+				}
+			} else if game.Room.BettingLimit != consts.TexasFixedLimit {
 				_ = player.WriteString("Please input the amount you want to raise\n")
 				continue
 			}
-			betAmount, err := cast.ToUintE(instructions[1])
-			if err != nil {
-				_ = player.WriteString("Invalid amount\n")
+			if texasPlayer.Amount() < betAmount {
+				_ = player.WriteString("You don't have enough money to raise\n")
 				continue
 			}
-			if betAmount < minCall {
-				_ = player.WriteString(fmt.Sprintf("The amount you want to raise is less than the minimum call amount %d\n", minCall))
+			if minRaise == maxRaise && betAmount != minRaise {
+				_ = player.WriteString(fmt.Sprintf("The amount you raise must be %d\n", minRaise))
 				continue
 			}
-			if texasPlayer.Amount() < betAmount {
-				_ = player.WriteString("You don't have enough money to raise\n")
+			if betAmount < minRaise || betAmount > maxRaise {
+				_ = player.WriteString(fmt.Sprintf("The amount you raise must be between %d and %d\n", minRaise, maxRaise))
 				continue
 			}
 			game.Bet(texasPlayer, betAmount)
-			database.Broadcast(player.RoomID, fmt.Sprintf("%s raise, bet %d\n", player.Name, betAmount))
+			broadcastBet(player, "raise", betAmount, fmt.Sprintf("%s raise, bet %d\n", player.Name, betAmount))
+			database.Record(game.Room, player.ID, database.ActionBet, fmt.Sprintf("raise %d", betAmount))
 		case "fold":
 			texasPlayer.Folded = true
 			game.Folded++
-			database.Broadcast(player.RoomID, fmt.Sprintf("%s fold\n", player.Name))
+			broadcastBet(player, "fold", 0, fmt.Sprintf("%s fold\n", player.Name))
+			database.Record(game.Room, player.ID, database.ActionBet, "fold")
 			if game.Folded == len(game.Players)-1 {
 				return settlementRound(game)
 			}
@@ -104,11 +150,21 @@ func bet(player *database.Player, game *database.Texas) error {
 				continue
 			}
 			game.Bet(texasPlayer, 0)
-			database.Broadcast(player.RoomID, fmt.Sprintf("%s check\n", player.Name))
+			broadcastBet(player, "check", 0, fmt.Sprintf("%s check\n", player.Name))
+			database.Record(game.Room, player.ID, database.ActionBet, "check")
 		case "allin":
 			betAmount := texasPlayer.Amount()
+			if betAmount > minCall && !canRaise {
+				_ = player.WriteString("You can't raise now, please call, check or fold\n")
+				continue
+			}
+			if betAmount > minCall && betAmount > maxRaise {
+				_ = player.WriteString(fmt.Sprintf("You can't all in, the most you can raise is %d under %s rules\n", maxRaise, consts.TexasLimitNames[game.Room.BettingLimit]))
+				continue
+			}
 			game.Bet(texasPlayer, betAmount)
-			database.Broadcast(player.RoomID, fmt.Sprintf("%s all in, bet %d\n", player.Name, betAmount))
+			broadcastBet(player, "allin", betAmount, fmt.Sprintf("%s all in, bet %d\n", player.Name, betAmount))
+			database.Record(game.Room, player.ID, database.ActionBet, fmt.Sprintf("all in %d", betAmount))
 		default:
 			database.BroadcastChat(player, fmt.Sprintf("%s [%s] say: %s\n", player.Name, player.Role, ans))
 			continue
@@ -117,3 +173,46 @@ func bet(player *database.Player, game *database.Texas) error {
 	}
 	return nextPlayer(player, game, stateBet)
 }
+
+// broadcastBet 广播玩家的下注操作，action 与玩家输入的指令一致
+func broadcastBet(player *database.Player, action string, amount uint, msg string) {
+	database.BroadcastEvent(player.RoomID, database.Event{
+		Code:   consts.CodeBetPlaced,
+		Msg:    msg,
+		Player: database.EventPlayerOf(player.ID),
+		Action: action,
+		Amount: amount,
+	})
+}
+
+// betHint 按下注模式提示玩家跟注的数量和可以加注的范围
+func betHint(game *database.Texas, texasPlayer *database.TexasPlayer) string {
+	minCall := game.MaxBetAmount - texasPlayer.Bets
+	minRaise, maxRaise, ok := game.RaiseRange(texasPlayer)
+	if !ok {
+		return fmt.Sprintf("To call: %d, raising is not allowed\n", minCall)
+	}
+	if minRaise == maxRaise {
+		return fmt.Sprintf("To call: %d, raise: %d\n", minCall, minRaise)
+	}
+	return fmt.Sprintf("To call: %d, raise: %d to %d\n", minCall, minRaise, maxRaise)
+}
+
+// trainingHint 训练模式下向玩家显示当前的牌型，以及按仍在牌局中的对手人数模拟的胜率；
+// 每条街只模拟一次，并按对手人数限制评估的牌型数，避免拖慢整桌的下注
+func trainingHint(game *database.Texas, texasPlayer *database.TexasPlayer) string {
+	opponents := 0
+	for _, p := range game.Players {
+		if p.ID != texasPlayer.ID && !p.Folded && !p.Out {
+			opponents++
+		}
+	}
+	h := rule.TexasRules.Evaluate(texasPlayer.Hand, game.Board)
+	key := len(game.Board)*16 + opponents + 1
+	if texasPlayer.EquityKey != key {
+		trials := consts.TexasEquityEvaluations / (opponents + 1)
+		texasPlayer.Equity = rule.TexasRules.Equity(texasPlayer.Hand, game.Board, opponents, trials)
+		texasPlayer.EquityKey = key
+	}
+	return fmt.Sprintf("[Training] %s, win probability against %d opponent(s): %.1f%%\n", h.Name(), opponents, texasPlayer.Equity*100)
+}
@@ -1,6 +1,8 @@
 package texas
 
 import (
+	"time"
+
 	"github.com/ratel-online/core/util/poker"
 	"github.com/ratel-online/server/database"
 )
@@ -22,23 +24,28 @@ func createGame(room *database.Room) (database.RoomGame, error) {
 	for playerId := range roomPlayers {
 		player := database.GetPlayer(playerId)
 		players = append(players, &database.TexasPlayer{
-			ID:    playerId,
-			Name:  player.Name,
-			State: make(chan int, 1),
-			Hand:  base[index*2 : (index+1)*2],
+			ID:      playerId,
+			Name:    player.Name,
+			State:   make(chan int, 1),
+			Hand:    base[index*2 : (index+1)*2],
+			Stacked: room.Stack > 0,
+			Chips:   room.StartingChips(playerId, room.Stack),
 		})
 		index++
 	}
 	game := &database.Texas{
-		Room:         room,
-		Players:      players,
-		Pot:          0,
-		BB:           0,
-		SB:           1,
-		Pool:         base[len(players)*2:],
-		MaxBetAmount: 20,
-		Round:        "start",
+		Room:      room,
+		Players:   players,
+		Pot:       0,
+		BB:        0,
+		SB:        1,
+		Pool:      base[len(players)*2:],
+		Round:     "start",
+		Stack:     room.Stack,
+		Hands:     1,
+		StartTime: time.Now(),
 	}
+	game.UpdateBlinds()
 	return game, nextRound(game)
 }
 
@@ -47,40 +54,55 @@ func resetGame(room *database.Room) (database.RoomGame, error) {
 	base.Shuffle(len(base), 1)
 	game := room.Game.(*database.Texas)
 
-	texasPlayers := make(map[int64]*database.TexasPlayer)
-	for _, texasPlayer := range game.Players {
-		texasPlayers[texasPlayer.ID] = texasPlayer
-	}
-
-	index := 0
+	// 保持上一手的座位顺序，新加入的玩家坐在最后
 	roomPlayers := database.RoomPlayers(room.ID)
+	seated := make(map[int64]bool)
 	players := make([]*database.TexasPlayer, 0)
+	for _, texasPlayer := range game.Players {
+		if !roomPlayers[texasPlayer.ID] {
+			continue
+		}
+		seated[texasPlayer.ID] = true
+		if texasPlayer.Stacked && texasPlayer.Chips == 0 {
+			texasPlayer.Out = true
+		}
+		texasPlayer.Reset()
+		players = append(players, texasPlayer)
+	}
 	for playerId := range roomPlayers {
-		if texasPlayer, ok := texasPlayers[playerId]; ok {
-			texasPlayer.Reset()
-			texasPlayer.Hand = base[index*2 : (index+1)*2]
-			players = append(players, texasPlayer)
-		} else {
-			player := database.GetPlayer(playerId)
-			players = append(players, &database.TexasPlayer{
-				ID:    playerId,
-				Name:  player.Name,
-				State: make(chan int, 1),
-				Hand:  base[index*2 : (index+1)*2],
-			})
+		if seated[playerId] {
+			continue
+		}
+		player := database.GetPlayer(playerId)
+		players = append(players, &database.TexasPlayer{
+			ID:      playerId,
+			Name:    player.Name,
+			State:   make(chan int, 1),
+			Stacked: game.Stack > 0,
+			Chips:   room.StartingChips(playerId, game.Stack),
+		})
+	}
+	folded := 0
+	for i, texasPlayer := range players {
+		texasPlayer.Hand = base[i*2 : (i+1)*2]
+		if texasPlayer.Out {
+			folded++
 		}
-		index++
 	}
 	newGame := &database.Texas{
-		Room:         room,
-		Players:      players,
-		Pot:          0,
-		BB:           (game.BB + 1) % len(players),
-		SB:           (game.BB + 2) % len(players),
-		Pool:         base[len(players)*2:],
-		MaxBetAmount: 20,
-		Round:        "start",
+		Room:      room,
+		Players:   players,
+		Pot:       0,
+		Pool:      base[len(players)*2:],
+		Round:     "start",
+		Folded:    folded,
+		Stack:     game.Stack,
+		Hands:     game.Hands + 1,
+		StartTime: game.StartTime,
 	}
+	newGame.BB = newGame.NextSeat(game.BB + 1)
+	newGame.SB = newGame.NextSeat(newGame.BB + 1)
+	newGame.UpdateBlinds()
 	return newGame, nextRound(newGame)
 }
 
@@ -3,11 +3,11 @@ package texas
 import (
 	"bytes"
 	"fmt"
-	"github.com/ratel-online/core/model"
-	"github.com/ratel-online/core/util/poker"
 	"github.com/ratel-online/server/bot"
 	"github.com/ratel-online/server/consts"
 	"github.com/ratel-online/server/database"
+	"github.com/ratel-online/server/rule"
+	"slices"
 )
 
 func nextRound(game *database.Texas) error {
@@ -29,71 +29,122 @@ func nextRound(game *database.Texas) error {
 
 func preFlopRound(game *database.Texas) error {
 	game.Round = "per-flop"
-	for id := range database.RoomPlayers(game.Room.ID) {
-		player := database.GetPlayer(id)
-		if player.Amount < 100 {
-			player.Amount += 2000
-			database.Broadcast(game.Room.ID, fmt.Sprintf("%s is too poor, system give him 2000\n", player.Name))
-			bot.SendGroupMessage(bot.GroupID, fmt.Sprintf("%s is too poor, system give him 2000", player.Name))
+	game.NewRound()
+	if !game.Stacked() {
+		for id := range database.RoomPlayers(game.Room.ID) {
+			player := database.GetPlayer(id)
+			if player.Amount < 100 {
+				player.Amount += 2000
+				database.SavePlayer(player)
+				database.Broadcast(game.Room.ID, fmt.Sprintf("%s is too poor, system give him 2000\n", player.Name))
+				bot.SendGroupMessage(bot.GroupID, fmt.Sprintf("%s is too poor, system give him 2000", player.Name))
+			}
 		}
 	}
 
-	game.Pot += 30
-	game.BBPlayer().Bet(20)
-	game.SBPlayer().Bet(10)
+	for _, texasPlayer := range game.Remaining() {
+		database.Record(game.Room, texasPlayer.ID, database.ActionDeal, texasPlayer.Hand.TexasString())
+	}
+	if game.Ante > 0 {
+		for _, texasPlayer := range game.Remaining() {
+			ante := blind(game, texasPlayer, game.Ante)
+			database.Record(game.Room, texasPlayer.ID, database.ActionBet, fmt.Sprintf("ante %d", ante))
+		}
+	}
+	sb := blind(game, game.SBPlayer(), game.SmallBlind)
+	database.Record(game.Room, game.SBPlayer().ID, database.ActionBet, fmt.Sprintf("small blind %d", sb))
+	bb := blind(game, game.BBPlayer(), game.BigBlind)
+	database.Record(game.Room, game.BBPlayer().ID, database.ActionBet, fmt.Sprintf("big blind %d", bb))
+	game.MaxBetAmount = game.Ante + game.BigBlind
+	// 大盲注算作这一轮的第一次下注
+	game.Raises = 1
 
 	for id := range database.RoomPlayers(game.Room.ID) {
 		player := database.GetPlayer(id)
 		texasPlayer := game.Player(id)
 
 		buf := bytes.Buffer{}
-		buf.WriteString(fmt.Sprintf("Game starting!\n"))
+		buf.WriteString(fmt.Sprintf("Game starting! Hand #%d, %s\n", game.Hands, blindsString(game)))
+		if texasPlayer != nil && texasPlayer.Out {
+			buf.WriteString("You have been eliminated, please wait for the next game.\n")
+			_ = player.WriteString(buf.String())
+			continue
+		}
 		if game.SBPlayer().ID != player.ID {
 			buf.WriteString(fmt.Sprintf("Your hand: %s\n", texasPlayer.Hand.TexasString()))
 		}
 		if game.BBPlayer().ID == player.ID {
-			buf.WriteString("You are big blind, bet 20 automatically.\n")
+			buf.WriteString(fmt.Sprintf("You are big blind, bet %d automatically.\n", bb))
 		} else {
-			buf.WriteString(fmt.Sprintf("Big blind: %s, Bet 20\n", game.Players[game.BB].Name))
+			buf.WriteString(fmt.Sprintf("Big blind: %s, Bet %d\n", game.Players[game.BB].Name, bb))
 		}
 		if game.SBPlayer().ID == player.ID {
-			buf.WriteString("You are small blind, bet 10 automatically.\n")
+			buf.WriteString(fmt.Sprintf("You are small blind, bet %d automatically.\n", sb))
 		} else {
-			buf.WriteString(fmt.Sprintf("Small blind: %s, Bet 10\n", game.Players[game.SB].Name))
+			buf.WriteString(fmt.Sprintf("Small blind: %s, Bet %d\n", game.Players[game.SB].Name, sb))
 			buf.WriteString(fmt.Sprintf("Pre-flop round, please wait for small blind %s to bet\n", game.Players[game.SB].Name))
 		}
-		_ = player.WriteString(buf.String())
+		_ = player.WriteEvent(database.Event{
+			Code:   consts.CodeHandDealt,
+			Msg:    buf.String(),
+			Game:   game.Room.Type,
+			Player: database.EventPlayerOf(player.ID),
+			Cards:  database.TexasCards(texasPlayer.Hand),
+		})
 	}
 	game.SBPlayer().State <- stateBet
 	return nil
 }
 
+// blind 强制下盲注或前注，筹码不足时全下，返回实际下注的数量
+func blind(game *database.Texas, player *database.TexasPlayer, amount uint) uint {
+	amount = min(amount, player.Amount())
+	if amount == 0 {
+		return 0
+	}
+	player.Bet(amount)
+	game.Pot += amount
+	if player.Amount() == 0 && !player.AllIn {
+		player.AllIn = true
+		game.AllIn++
+	}
+	return amount
+}
+
+// blindsString 当前盲注级别的描述
+func blindsString(game *database.Texas) string {
+	return fmt.Sprintf("%s, level %d, blinds %d/%d, ante %d", consts.TexasLimitNames[game.Room.BettingLimit], game.Level+1, game.SmallBlind, game.BigBlind, game.Ante)
+}
+
 func flopRound(game *database.Texas) error {
 	game.Round = "flop"
-	game.MaxBetPlayer = nil
+	game.NewRound()
 	game.Board = append(game.Board, game.Pool[1:4]...)
 	game.Pool = game.Pool[4:]
 	database.Broadcast(game.Room.ID, fmt.Sprintf("Flop round, board: %s\n", game.Board.TexasString()))
+	database.Record(game.Room, 0, database.ActionDeal, fmt.Sprintf("flop %s", game.Board.TexasString()))
 	game.SBPlayer().State <- stateBet
 	return nil
 }
 
 func turnRound(game *database.Texas) error {
 	game.Round = "turn"
-	game.MaxBetPlayer = nil
+	game.NewRound()
 	game.Board = append(game.Board, game.Pool[1:2]...)
 	game.Pool = game.Pool[2:]
 	database.Broadcast(game.Room.ID, fmt.Sprintf("Turn round, board: %s\n", game.Board.TexasString()))
+	database.Record(game.Room, 0, database.ActionDeal, fmt.Sprintf("turn %s", game.Board.TexasString()))
 	game.SBPlayer().State <- stateBet
 	return nil
 }
 
 func riverRound(game *database.Texas) error {
 	game.Round = "river"
-	game.MaxBetPlayer = nil
+	game.NewRound()
 	game.Board = append(game.Board, game.Pool[1:2]...)
 	game.Pool = game.Pool[2:]
 	database.Broadcast(game.Room.ID, fmt.Sprintf("River round, board: %s\n", game.Board.TexasString()))
+	database.Record(game.Room, 0, database.ActionDeal, fmt.Sprintf("river %s", game.Board.TexasString()))
 	game.SBPlayer().State <- stateBet
 	return nil
 }
@@ -103,71 +154,117 @@ func settlementRound(game *database.Texas) error {
 	buf.WriteString("Settlement round\n")
 	buf.WriteString(fmt.Sprintf("Board: %s\n", game.Board.TexasString()))
 
-	if game.Folded == len(game.Players)-1 {
-		var winner *database.TexasPlayer
-		for _, player := range game.Players {
-			if !player.Folded {
-				winner = player
-				break
-			}
+	contenders := make([]*database.TexasPlayer, 0)
+	for _, player := range game.Players {
+		if !player.Folded {
+			contenders = append(contenders, player)
 		}
-		if winner != nil {
-			winner.Add(game.Pot)
-			buf.WriteString(fmt.Sprintf("Winner: %s, got all pot: %d\n", winner.Name, game.Pot))
+	}
+	// 只剩一名玩家时不需要亮牌
+	hands := map[int64]*rule.TexasHand{}
+	if len(contenders) > 1 {
+		buf.WriteString("Players' hands:\n")
+		for _, player := range contenders {
+			h := rule.TexasRules.Evaluate(player.Hand, game.Board)
+			hands[player.ID] = &h
+			buf.WriteString(fmt.Sprintf("%s: %s, %s (%s)\n", player.Name, player.Hand.TexasString(), h.Name(), h.Cards.TexasString()))
+		}
+	}
+
+	winnerIds := make([]int64, 0)
+	won := map[int64]uint{}
+	for i, pot := range game.Pots() {
+		winners := bestPlayers(pot.Players, hands)
+		shares := game.Split(pot.Amount, winners)
+		if i == 0 {
+			buf.WriteString(fmt.Sprintf("Main pot: %d", pot.Amount))
 		} else {
-			buf.WriteString("All players folded\n")
+			buf.WriteString(fmt.Sprintf("Side pot %d: %d", i, pot.Amount))
 		}
-	} else {
-		buf.WriteString("Players' hands:\n")
-		var maxFaces *model.TexasFaces
-		var maxPlayers []int64
-		for _, player := range game.Players {
-			if player.Folded {
-				continue
-			}
-			faces, err := poker.ParseTexasFaces(player.Hand, game.Board)
-			if err != nil {
-				return err
-			}
-			buf.WriteString(fmt.Sprintf("%s: %s, type: %s, score: %d\n", player.Name, player.Hand.TexasString(), faces.Type, faces.Score))
-			if maxFaces == nil ||
-				maxFaces.Type < faces.Type ||
-				(maxFaces.Type == faces.Type && maxFaces.Score < faces.Score) {
-				maxFaces = faces
-				maxPlayers = []int64{player.ID}
-				continue
-			}
-			if maxFaces.Type == faces.Type && maxFaces.Score == faces.Score {
-				maxPlayers = append(maxPlayers, player.ID)
+		for _, winner := range winners {
+			winner.Add(shares[winner.ID])
+			won[winner.ID] += shares[winner.ID]
+			if !slices.Contains(winnerIds, winner.ID) {
+				winnerIds = append(winnerIds, winner.ID)
 			}
+			buf.WriteString(fmt.Sprintf(", %s won %d", winner.Name, shares[winner.ID]))
 		}
-		winners := make([]*database.TexasPlayer, 0)
-		for _, id := range maxPlayers {
-			winners = append(winners, game.Player(id))
+		buf.WriteString("\n")
+	}
+	// 使用玩家余额的牌局每手结算后立即保存
+	if !game.Stacked() {
+		for _, player := range game.Players {
+			database.SavePlayer(database.GetPlayer(player.ID))
 		}
-		if len(winners) == 1 {
-			buf.WriteString(fmt.Sprintf("Winner: %s, got all pot: %d\n", winners[0].Name, game.Pot))
-		} else {
-			buf.WriteString("Winners: ")
-			for i, winner := range winners {
-				if i != 0 {
-					buf.WriteString(", ")
-				}
-				buf.WriteString(winner.Name)
+	}
+	// 之前已经出局的玩家没有参与这一手，中途换人的座位在换人时已经记录了换下玩家的战绩
+	results := make([]database.GameResult, 0, len(game.Players))
+	for _, player := range game.Players {
+		if !player.Out && !player.Left {
+			results = append(results, database.GameResult{
+				PlayerID: player.ID,
+				Won:      slices.Contains(winnerIds, player.ID),
+				Chips:    int64(won[player.ID]) - int64(player.Bets),
+			})
+		}
+	}
+	database.RecordStats(game.Room.Type, results...)
+	if game.Stacked() {
+		for _, player := range game.Players {
+			if !player.Out && player.Chips == 0 {
+				player.Out = true
+				buf.WriteString(fmt.Sprintf("%s has been eliminated!\n", player.Name))
 			}
-			buf.WriteString(fmt.Sprintf(", half all pot: %d\n", game.Pot))
 		}
-		for _, winner := range winners {
-			winner.Add(game.Pot / uint(len(winners)))
+		if remaining := game.Remaining(); len(remaining) <= 1 {
+			if len(remaining) == 1 {
+				buf.WriteString(fmt.Sprintf("%s won the game with %d chips!\n", remaining[0].Name, remaining[0].Chips))
+			}
+			// 下一局重新发放起始筹码
+			game.Room.Game = nil
 		}
 	}
+	database.Record(game.Room, 0, database.ActionSettlement, buf.String())
 	buf.WriteString(fmt.Sprintf("Please room owner %s to start a new game\n", database.GetPlayer(game.Room.Creator).Name))
-	database.Broadcast(game.Room.ID, buf.String())
+	database.BroadcastEvent(game.Room.ID, database.Event{
+		Code:    consts.CodeGameOver,
+		Msg:     buf.String(),
+		Cards:   database.TexasCards(game.Board),
+		Winners: database.EventPlayers(winnerIds...),
+	})
 
 	room := game.Room
+	database.FinishJournal(room)
 	room.State = consts.RoomStateWaiting
+	chips := make(map[int64]uint, len(game.Players))
+	for _, player := range game.Players {
+		chips[player.ID] = player.Chips
+	}
+	database.Settle(room, winnerIds, chips)
 	for _, player := range game.Players {
 		player.State <- stateWaiting
 	}
 	return nil
 }
+
+// bestPlayers 返回有资格的玩家中牌力最大的玩家，牌力相同时并列
+func bestPlayers(players []*database.TexasPlayer, hands map[int64]*rule.TexasHand) []*database.TexasPlayer {
+	var best *rule.TexasHand
+	winners := make([]*database.TexasPlayer, 0)
+	for _, player := range players {
+		h := hands[player.ID]
+		if h == nil {
+			winners = append(winners, player)
+			continue
+		}
+		if best == nil || h.Compare(*best) > 0 {
+			best = h
+			winners = []*database.TexasPlayer{player}
+			continue
+		}
+		if h.Compare(*best) == 0 {
+			winners = append(winners, player)
+		}
+	}
+	return winners
+}
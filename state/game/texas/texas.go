@@ -20,6 +20,7 @@ func (g *Texas) Next(player *database.Player) (consts.StateID, error) {
 	if room == nil {
 		return 0, player.WriteError(consts.ErrorsExist)
 	}
+	defer room.Track()()
 	game := room.Game.(*database.Texas)
 
 	loopCount := 0
@@ -41,6 +42,12 @@ func (g *Texas) Next(player *database.Player) (consts.StateID, error) {
 			if !ok {
 				return 0, consts.ErrorsChanClosed
 			}
+			if room.Closed() {
+				return consts.StateWaiting, nil
+			}
+			if state == stateBet && database.HandOver(room, player, texasPlayer.State, state) {
+				return consts.StateWaiting, nil
+			}
 			switch state {
 			case stateBet:
 				err := bet(player, game)
@@ -32,6 +32,7 @@ func (g *Undercover) Next(player *database.Player) (consts.StateID, error) {
 	if room == nil {
 		return 0, player.WriteError(consts.ErrorsExist)
 	}
+	defer room.Track()()
 
 	// 检查房间状态，如果已经回到等待状态则直接返回
 	if room.State == consts.RoomStateWaiting {
@@ -44,7 +45,16 @@ func (g *Undercover) Next(player *database.Player) (consts.StateID, error) {
 	buf := bytes.Buffer{}
 	buf.WriteString(fmt.Sprintf("\n========== 谁是卧底 - 第%d轮 ==========\n", game.Round))
 	buf.WriteString(g.GetPlayerStatus(room, player.ID))
-	_ = player.WriteString(buf.String())
+	dealt := database.Event{
+		Code:   consts.CodeHandDealt,
+		Msg:    buf.String(),
+		Game:   room.Type,
+		Player: database.EventPlayerOf(player.ID),
+	}
+	if word, ok := game.Words[player.ID]; ok && game.Alive[player.ID] {
+		dealt.Cards = []string{word}
+	}
+	_ = player.WriteEvent(dealt)
 
 	loopCount := 0
 	for {
@@ -68,6 +78,12 @@ func (g *Undercover) Next(player *database.Player) (consts.StateID, error) {
 			log.Infof("[Undercover.Next] Player %d state channel closed, returning to waiting\n", player.ID)
 			return consts.StateWaiting, nil
 		}
+		if room.Closed() {
+			return consts.StateWaiting, nil
+		}
+		if state != undercoverStateGameEnd && database.HandOver(room, player, game.States[player.ID], state) {
+			return consts.StateWaiting, nil
+		}
 		switch state {
 		case undercoverStateDescribe:
 			err := g.handleDescribe(player, game)
@@ -117,7 +133,14 @@ func (g *Undercover) handleDescribe(player *database.Player, game *database.Unde
 	buf := bytes.Buffer{}
 	buf.WriteString(fmt.Sprintf("\n>>> 轮到你了！你的词是：【%s】\n", word))
 	buf.WriteString("请输入你对这个词的描述（输入 's' 或 '结束' 结束发言）：\n")
-	_ = player.WriteString(buf.String())
+	_ = player.WriteEvent(database.Event{
+		Code:    consts.CodeTurnStarted,
+		Msg:     buf.String(),
+		Player:  database.EventPlayerOf(player.ID),
+		Action:  "describe",
+		Cards:   []string{word},
+		Timeout: 60,
+	})
 
 	for {
 		ans, err := player.AskForString(60 * time.Second)
@@ -225,6 +248,7 @@ func (g *Undercover) handleReveal(player *database.Player, game *database.Underc
 		_ = player.WriteString(fmt.Sprintf("🎉 恭喜你猜对了！平民词是：【%s】\n", normalWord))
 		database.Broadcast(roomID, fmt.Sprintf("\n>>> [%d号] %s 爆词成功！猜对了平民词：【%s】\n>>> 卧底获胜！\n",
 			playerNumber, player.Name, normalWord))
+		database.Record(game.Room, player.ID, database.ActionSkill, fmt.Sprintf("爆词成功：%s", ans))
 
 		game.Lock()
 		game.GameOver = true
@@ -243,6 +267,7 @@ func (g *Undercover) handleReveal(player *database.Player, game *database.Underc
 		_ = player.WriteString("你猜错了，身份暴露，死亡！\n")
 		database.Broadcast(roomID, fmt.Sprintf("\n>>> [%d号] %s 爆词失败！猜的词是：【%s】（错误）\n>>> 卧底身份暴露，被淘汰！\n",
 			playerNumber, player.Name, ans))
+		database.Record(game.Room, player.ID, database.ActionSkill, fmt.Sprintf("爆词失败：%s", ans))
 
 		game.Lock()
 		game.Alive[player.ID] = false
@@ -526,9 +551,15 @@ func (g *Undercover) handleVote(player *database.Player, game *database.Undercov
 		if targetPlayer != nil {
 			targetName = targetPlayer.Name
 		}
-		database.Broadcast(game.Room.ID, fmt.Sprintf("[%d号] %s 投票给了 [%d号] %s\n",
-			voterNumber, player.Name,
-			targetNumbers[targetID], targetName))
+		database.BroadcastEvent(game.Room.ID, database.Event{
+			Code: consts.CodeVoteCast,
+			Msg: fmt.Sprintf("[%d号] %s 投票给了 [%d号] %s\n",
+				voterNumber, player.Name,
+				targetNumbers[targetID], targetName),
+			Player: database.EventPlayerOf(player.ID),
+			Target: database.EventPlayerOf(targetID),
+			Action: database.ActionVote,
+		})
 
 		if shouldCount {
 			g.countVotes(game)
@@ -667,6 +698,7 @@ func (g *Undercover) countVotes(game *database.Undercover) {
 	game.Unlock()
 
 	database.Broadcast(roomID, result.String())
+	database.Record(game.Room, 0, database.ActionVote, result.String()+followMsg)
 	if followMsg != "" {
 		database.Broadcast(roomID, followMsg)
 	}
@@ -752,7 +784,36 @@ func (g *Undercover) broadcastAllWords(game *database.Undercover) {
 				game.PlayerNumbers[id], player.Name, role, word, status))
 		}
 	}
-	database.Broadcast(game.Room.ID, buf.String())
+	database.BroadcastEvent(game.Room.ID, database.Event{
+		Code:    consts.CodeGameOver,
+		Msg:     buf.String(),
+		Cards:   []string{game.NormalWord, game.UndercoverWord},
+		Winners: database.EventPlayers(g.winners(game)...),
+	})
+	database.Record(game.Room, 0, database.ActionSettlement, buf.String())
+	results := database.Results(game.PlayerIDs, g.winners(game)...)
+	for i := range results {
+		results[i].Survived = game.Alive[results[i].PlayerID]
+	}
+	database.RecordStats(game.Room.Type, results...)
+	database.Settle(game.Room, g.winners(game), nil)
+}
+
+// winners 获胜的一方：卧底爆词成功或存活到最后时卧底（包括空白词）获胜，否则平民获胜
+func (g *Undercover) winners(game *database.Undercover) []int64 {
+	undercoverWins := game.RevealWinner
+	for _, id := range game.PlayerIDs {
+		if game.Alive[id] && (game.IsUndercover[id] || game.IsBlankWord[id]) {
+			undercoverWins = true
+		}
+	}
+	winners := make([]int64, 0)
+	for _, id := range game.PlayerIDs {
+		if (game.IsUndercover[id] || game.IsBlankWord[id]) == undercoverWins {
+			winners = append(winners, id)
+		}
+	}
+	return winners
 }
 
 // handleGameEnd 处理游戏结束
@@ -765,6 +826,7 @@ func (g *Undercover) handleGameEnd(player *database.Player, game *database.Under
 	room.Lock()
 	if room.Game != nil {
 		// 只执行一次清理
+		database.FinishJournal(room)
 		room.Game = nil
 		room.State = consts.RoomStateWaiting
 		database.Broadcast(room.ID, "\n游戏已结束，等待房主重新开始...\n")
@@ -969,6 +1031,13 @@ func InitUndercoverGame(room *database.Room) (*database.Undercover, error) {
 		}
 	}
 	database.Broadcast(room.ID, buf.String())
+	for _, id := range playerIDs {
+		word := words[id]
+		if isBlankWord[id] {
+			word = "（空白）"
+		}
+		database.Record(room, id, database.ActionDeal, fmt.Sprintf("[%d号] %s", playerNumbers[id], word))
+	}
 
 	// 通知第一个玩家开始描述
 	firstPlayerID := playerIDs[0]
@@ -1026,27 +1095,39 @@ func (g *Undercover) voteOptions(game *database.Undercover, playerID int64) ([]i
 
 func (g *Undercover) recordVote(game *database.Undercover, voterID, targetID int64) (bool, bool) {
 	game.Lock()
-	defer game.Unlock()
+	accepted, counting, detail := g.addVoteLocked(game, voterID, targetID)
+	game.Unlock()
+	// 生成观众画面时需要获取对局的锁，解锁之后再记录
+	if accepted {
+		database.Record(game.Room, voterID, database.ActionVote, detail)
+	}
+	return accepted, counting
+}
 
+func (g *Undercover) addVoteLocked(game *database.Undercover, voterID, targetID int64) (bool, bool, string) {
 	if game.GameOver || !game.Alive[voterID] {
-		return false, false
+		return false, false, ""
 	}
 	if _, ok := game.Votes[voterID]; ok {
-		return false, false
+		return false, false, ""
 	}
 	if !g.isEligibleVoterLocked(game, voterID) {
-		return false, false
+		return false, false, ""
 	}
 	if targetID != 0 && !contains(g.voteTargetsForPlayerLocked(game, voterID), targetID) {
-		return false, false
+		return false, false, ""
 	}
 
 	game.Votes[voterID] = targetID
+	detail := "弃权"
+	if target := database.GetPlayer(targetID); target != nil {
+		detail = fmt.Sprintf("[%d号] %s", game.PlayerNumbers[targetID], target.Name)
+	}
 	if g.allVotesInLocked(game) && !game.VoteCounting {
 		game.VoteCounting = true
-		return true, true
+		return true, true, detail
 	}
-	return true, false
+	return true, false, detail
 }
 
 func (g *Undercover) tryStartVoteCounting(game *database.Undercover) bool {
@@ -1156,6 +1237,7 @@ func (g *Undercover) recordDescription(game *database.Undercover, playerID int64
 	game.Lock()
 	game.Descriptions[playerID] = description
 	game.Unlock()
+	database.Record(game.Room, playerID, database.ActionPlay, description)
 }
 
 // sendStateSignals 向多个玩家发送状态信号
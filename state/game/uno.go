@@ -18,6 +18,7 @@ func (g *Uno) Next(player *database.Player) (consts.StateID, error) {
 	if room == nil {
 		return 0, player.WriteError(consts.ErrorsExist)
 	}
+	defer room.Track()()
 	game := room.Game.(*database.UnoGame)
 	buf := bytes.Buffer{}
 	buf.WriteString(fmt.Sprintf(
@@ -26,8 +27,14 @@ func (g *Uno) Next(player *database.Player) (consts.StateID, error) {
 		color.Yellow.Paint("N"),
 		color.Blue.Paint("O"),
 	))
-	buf.WriteString(fmt.Sprintf("Your Cards: %s\n", game.Game.GetPlayerCards(int(player.ID))))
-	_ = player.WriteString(buf.String())
+	buf.WriteString(fmt.Sprintf("Your Cards: %s\n", game.Game.GetPlayerCards(game.Seat(player.ID))))
+	_ = player.WriteEvent(database.Event{
+		Code:   consts.CodeHandDealt,
+		Msg:    buf.String(),
+		Game:   room.Type,
+		Player: database.EventPlayerOf(player.ID),
+		Cards:  database.UnoCards(game.Game.GetPlayerCards(game.Seat(player.ID))...),
+	})
 	loopCount := 0
 	for {
 		loopCount++
@@ -39,12 +46,20 @@ func (g *Uno) Next(player *database.Player) (consts.StateID, error) {
 			return consts.StateWaiting, nil
 		}
 		log.Infof("[Uno.Next] Player %d waiting for state, loop count: %d\n", player.ID, loopCount)
-		state := <-game.States[int(player.ID)]
+		seat := game.States[game.Seat(player.ID)]
+		state := <-seat
+		if room.Closed() {
+			return consts.StateWaiting, nil
+		}
+		if state != stateWaiting && database.HandOver(room, player, seat, state) {
+			return consts.StateWaiting, nil
+		}
 		switch state {
 		case stateFirstCard:
 			if msg := game.Game.PlayFirstCard(); msg != "" {
 				database.Broadcast(room.ID, msg)
 			}
+			database.Record(room, 0, database.ActionPlay, fmt.Sprintf("first card %s", game.Game.Pile().Top()))
 			pc := game.Game.Players().Next()
 			game.States[pc.ID()] <- statePlay
 		case statePlay:
@@ -67,38 +82,69 @@ func (g *Uno) Exit(player *database.Player) consts.StateID {
 
 func handlePlayUno(room *database.Room, player *database.Player, game *database.UnoGame) error {
 	p := game.Game.Current()
-	if p.ID() != int(player.ID) {
+	if p.ID() != game.Seat(player.ID) {
 		game.States[p.ID()] <- statePlay
 		return nil
 	}
+	game.HandleCalls()
 	if !game.HavePlay(player) {
 		pc := game.Game.Players().Next()
 		game.States[pc.ID()] <- statePlay
 	}
+	if game.Penalty > 0 && !handlePenalty(room, player, game) {
+		pc := game.Game.Players().Next()
+		game.States[pc.ID()] <- statePlay
+		return nil
+	}
+	if game.Penalty == 0 && room.EnableDrawUntil && !game.Playable(p.ID()) {
+		drawn := game.DrawUntilPlayable(p.ID())
+		database.Broadcast(room.ID, fmt.Sprintf("%s has no matching card and draws %d card(s)\n", p.Name(), drawn))
+		database.Record(room, player.ID, database.ActionDraw, fmt.Sprintf("%d cards", drawn))
+	}
+	previous := game.Game.Pile().Top()
 	gameState := game.Game.ExtractState(p)
 	card, err := p.Play(gameState, game.Game.Deck())
 	if err != nil || card == nil {
-		event.PlayerPassed.Emit(event.PlayerPassedPayload{
-			PlayerName: p.Name(),
-		})
+		if game.Penalty > 0 {
+			takePenalty(room, player, game)
+		} else {
+			database.Record(room, player.ID, database.ActionPass, "")
+			event.PlayerPassed.Emit(event.PlayerPassedPayload{
+				PlayerName: p.Name(),
+			})
+		}
 		pc := game.Game.Players().Next()
 		game.States[pc.ID()] <- statePlay
 		return err
 	}
 	game.Game.Pile().Add(card)
+	database.Record(room, player.ID, database.ActionPlay, card.String())
 	event.CardPlayed.Emit(event.CardPlayedPayload{
 		PlayerName: p.Name(),
 		Card:       card,
 	})
-	if msg := game.Game.PerformCardActions(card); msg != "" {
+	if msg := game.PerformCardActions(p.ID(), card, previous); msg != "" {
 		database.Broadcast(room.ID, msg)
 	}
+	if player.IsRobot() && len(p.Hand()) == 1 {
+		_ = game.SayUno(player)
+	}
 	if p.NoCards() || game.NeedExit() {
-		database.Broadcast(room.ID, fmt.Sprintf("%s wins! \n", p.Name()))
+		score := game.Score(player.ID)
+		database.BroadcastEvent(room.ID, database.Event{
+			Code:    consts.CodeGameOver,
+			Msg:     fmt.Sprintf("%s wins! \n%s", p.Name(), score),
+			Player:  database.EventPlayerOf(player.ID),
+			Winners: database.EventPlayers(player.ID),
+		})
+		database.Record(room, player.ID, database.ActionSettlement, "wins\n"+score)
+		database.RecordStats(room.Type, database.Results(game.Players, player.ID)...)
+		database.FinishJournal(room)
 		room.Game = nil
 		room.State = consts.RoomStateWaiting
-		for _, playerId := range game.Players {
-			game.States[playerId] <- stateWaiting
+		database.Settle(room, []int64{player.ID}, nil)
+		for _, state := range game.States {
+			state <- stateWaiting
 		}
 		return nil
 	}
@@ -107,23 +153,72 @@ func handlePlayUno(room *database.Room, player *database.Player, game *database.
 	return nil
 }
 
+// handlePenalty 轮到要罚摸的玩家：上家打出 +4 时可以先质疑，之后手中有能叠加的牌时返回 true 继续出牌，
+// 否则摸牌并结束回合
+func handlePenalty(room *database.Room, player *database.Player, game *database.UnoGame) bool {
+	seat := game.Seat(player.ID)
+	if challenge := game.Challenge; challenge != nil {
+		game.Challenge = nil
+		if game.SeatOf(seat).Challenge(game.Penalty) {
+			offender := game.SeatOf(challenge.Seat)
+			if !challenge.Illegal {
+				drawn := game.Penalty + consts.UnoChallengePenalty
+				game.Penalty = 0
+				game.Draw(seat, drawn)
+				database.Broadcast(room.ID, fmt.Sprintf("%s challenged %s and failed, draws %d card(s)!\n", player.Name, offender.Name, drawn))
+				database.Record(room, player.ID, database.ActionChallenge, fmt.Sprintf("failed, draws %d", drawn))
+				return false
+			}
+			game.Penalty -= 4
+			game.Draw(challenge.Seat, 4)
+			database.Broadcast(room.ID, fmt.Sprintf("%s challenged %s successfully, %s draws 4 cards!\n", player.Name, offender.Name, offender.Name))
+			database.Record(room, player.ID, database.ActionChallenge, fmt.Sprintf("succeeded, %s draws 4", offender.Name))
+			if game.Penalty == 0 {
+				return true
+			}
+		}
+	}
+	if len(game.Stackable(game.Game.GetPlayerCards(seat))) > 0 {
+		return true
+	}
+	takePenalty(room, player, game)
+	return false
+}
+
+// takePenalty 摸下累计的罚摸张数，本回合结束
+func takePenalty(room *database.Room, player *database.Player, game *database.UnoGame) {
+	drawn := game.Penalty
+	game.Penalty = 0
+	game.Challenge = nil
+	game.Draw(game.Seat(player.ID), drawn)
+	database.Broadcast(room.ID, fmt.Sprintf("%s draws %d card(s)!\n", player.Name, drawn))
+	database.Record(room, player.ID, database.ActionDraw, fmt.Sprintf("%d cards", drawn))
+}
+
 func InitUnoGame(room *database.Room) (*database.UnoGame, error) {
 	players := make([]int, 0)
 	roomPlayers := database.RoomPlayers(room.ID)
 	unoPlayers := make([]game.Player, 0)
+	seats := make([]*database.UnoPlayer, 0)
 	states := map[int]chan int{}
 	for playerId := range roomPlayers {
 		p := database.GetPlayer(playerId)
 		players = append(players, int(p.ID))
-		unoPlayers = append(unoPlayers, database.NewUnoPlayer(p))
-		states[int(playerId)] = make(chan int, 1)
+		seat := database.NewUnoPlayer(p)
+		unoPlayers = append(unoPlayers, seat)
+		seats = append(seats, seat)
+		states[seat.Seat] = make(chan int, 1)
 	}
 	unoGame := game.New(unoPlayers)
 	unoGame.DealStartingCards()
+	for _, id := range players {
+		database.Record(room, int64(id), database.ActionDeal, fmt.Sprintf("%s", unoGame.GetPlayerCards(id)))
+	}
 	states[unoGame.Current().ID()] <- stateFirstCard
 	return &database.UnoGame{
 		Room:    room,
 		Players: players,
+		Seats:   seats,
 		States:  states,
 		Game:    unoGame,
 	}, nil
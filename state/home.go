@@ -1,29 +1,47 @@
 package state
 
 import (
-	"bytes"
+	"strconv"
+	"strings"
+
 	"github.com/ratel-online/server/consts"
 	"github.com/ratel-online/server/database"
+	"github.com/ratel-online/server/render"
+	"github.com/spf13/cast"
 )
 
 type home struct{}
 
-func (*home) Next(player *database.Player) (consts.StateID, error) {
-	buf := bytes.Buffer{}
-	buf.WriteString("1.Join\n")
-	buf.WriteString("2.New\n")
-	err := player.WriteString(buf.String())
+func (s *home) Next(player *database.Player) (consts.StateID, error) {
+	err := render.HomeOptions(player)
 	if err != nil {
 		return 0, player.WriteError(err)
 	}
-	selected, err := player.AskForInt()
+	signal, err := player.AskForString()
 	if err != nil {
 		return 0, player.WriteError(err)
 	}
+	segments := strings.Fields(strings.TrimSpace(signal))
+	if len(segments) > 0 && segments[0] == "stats" {
+		return 0, s.stats(player, strings.Join(segments[1:], " "))
+	}
+	if len(segments) == 2 && segments[0] == "top" {
+		return 0, s.top(player, cast.ToInt(segments[1]))
+	}
+	selected, err := strconv.Atoi(strings.TrimSpace(signal))
+	if err != nil {
+		return 0, player.WriteError(consts.ErrorsInputInvalid)
+	}
 	if selected == 1 {
 		return consts.StateJoin, nil
 	} else if selected == 2 {
 		return consts.StateCreate, nil
+	} else if selected == 3 {
+		return consts.StateReplay, nil
+	} else if selected == 4 {
+		return consts.StateMatch, nil
+	} else if selected == 5 {
+		return consts.StateTournament, nil
 	}
 	return 0, player.WriteError(consts.ErrorsInputInvalid)
 }
@@ -31,3 +49,20 @@ func (*home) Next(player *database.Player) (consts.StateID, error) {
 func (*home) Exit(player *database.Player) consts.StateID {
 	return 0
 }
+
+// stats 查看自己或其他玩家的战绩
+func (*home) stats(player *database.Player, name string) error {
+	stats := database.GetStats(player, name)
+	if stats == nil {
+		return player.WriteError(consts.ErrorsPlayerNotFound)
+	}
+	return render.Stats(player, stats)
+}
+
+// top 查看某种游戏的排行榜
+func (*home) top(player *database.Player, gameType int) error {
+	if _, ok := consts.GameTypes[gameType]; !ok {
+		return player.WriteError(consts.ErrorsGameTypeInvalid)
+	}
+	return render.Leaderboard(player, gameType, database.TopStats(gameType, consts.LeaderboardSize))
+}
@@ -1,27 +1,16 @@
 package state
 
 import (
-	"bytes"
-	"fmt"
 	"github.com/ratel-online/server/consts"
 	"github.com/ratel-online/server/database"
+	"github.com/ratel-online/server/render"
 	"strconv"
 )
 
 type join struct{}
 
 func (s *join) Next(player *database.Player) (consts.StateID, error) {
-	buf := bytes.Buffer{}
-	rooms := database.GetRooms()
-	buf.WriteString(fmt.Sprintf("%-10s%-10s%-10s%-10s\n", "ID", "Type", "Players", "State"))
-	for _, room := range rooms {
-		pwdFlag := ""
-		if room.Password != "" {
-			pwdFlag = "*"
-		}
-		buf.WriteString(fmt.Sprintf("%-10d%-10s%-10d%-10s\n", room.ID, pwdFlag+consts.GameTypes[room.Type], room.Players, consts.RoomStates[room.State]))
-	}
-	err := player.WriteString(buf.String())
+	err := render.RoomList(player)
 	if err != nil {
 		return 0, player.WriteError(err)
 	}
@@ -56,10 +45,9 @@ func (s *join) Next(player *database.Player) (consts.StateID, error) {
 	if err != nil {
 		return 0, player.WriteError(err)
 	}
-	if room.State != consts.RoomStateRunning {
-		database.Broadcast(roomId, fmt.Sprintf("%s [%s] joined room! room current has %d players\n", player.Name, player.Role, room.Players))
-	} else {
-		_ = player.WriteString("You have joined a running game, please wait for the game to finish.\n")
+	render.Join(player, room)
+	if room.State == consts.RoomStateRunning {
+		_ = player.WriteString("You have joined a running game as a spectator, input v to view the game.\n")
 	}
 	return consts.StateWaiting, nil
 }
@@ -0,0 +1,100 @@
+package state
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ratel-online/core/log"
+	"github.com/ratel-online/server/consts"
+	"github.com/ratel-online/server/database"
+	"github.com/ratel-online/server/render"
+)
+
+type match struct{}
+
+func (s *match) Next(player *database.Player) (consts.StateID, error) {
+	if database.Draining() {
+		_ = player.WriteError(consts.ErrorsServerDraining)
+		return consts.StateHome, nil
+	}
+	gameType, err := askForGameType(player)
+	if err != nil {
+		return 0, err
+	}
+	stake := 0
+	if gameType == consts.GameTypeTexas {
+		stake, err = askForStake(player)
+		if err != nil {
+			return 0, err
+		}
+	}
+	room, err := database.Enqueue(player, gameType, stake)
+	if err != nil {
+		return 0, player.WriteError(err)
+	}
+	if room != nil {
+		return s.start(player, room)
+	}
+
+	player.StartTransaction()
+	defer player.StopTransaction()
+	loopCount := 0
+	for {
+		loopCount++
+		if loopCount%100 == 0 {
+			log.Infof("[match] Player %d loop count: %d, type: %d, stake: %d\n", player.ID, loopCount, gameType, stake)
+		}
+		signal, err := player.AskForStringWithoutTransaction(time.Second)
+		if err != nil && err != consts.ErrorsTimeout {
+			if !database.Dequeue(player.ID) && player.RoomID != 0 {
+				return consts.StateWaiting, nil
+			}
+			return 0, err
+		}
+		// 由凑齐人数的玩家创建房间并开局，其余玩家入座后进入等待状态
+		if player.RoomID != 0 {
+			return consts.StateWaiting, nil
+		}
+		if isExit(signal) {
+			if !database.Dequeue(player.ID) && player.RoomID != 0 {
+				return consts.StateWaiting, nil
+			}
+			return consts.StateHome, nil
+		}
+	}
+}
+
+func (*match) Exit(player *database.Player) consts.StateID {
+	database.Dequeue(player.ID)
+	return consts.StateHome
+}
+
+// start 匹配成功，以默认配置开局；开局失败时解散房间，匹配到的玩家都回到大厅
+func (*match) start(player *database.Player, room *database.Room) (consts.StateID, error) {
+	database.Broadcast(room.ID, fmt.Sprintf("Match found! room %d, %d players, game starting...\n", room.ID, room.Players))
+	if err := startGame(player, room); err != nil {
+		log.Error(err)
+		_ = database.DissolveRoom(room.ID, fmt.Sprintf("Failed to start the matched game: %v, back to the lobby.\n", err))
+		return consts.StateHome, nil
+	}
+	return consts.StateWaiting, nil
+}
+
+// 询问德州扑克的盲注级别，返回 consts.TexasStakes 中的下标
+func askForStake(player *database.Player) (int, error) {
+	err := render.StakeOptions(player)
+	if err != nil {
+		return 0, player.WriteError(err)
+	}
+	selected, err := player.AskForInt()
+	if err != nil {
+		if err == consts.ErrorsExist {
+			return 0, err
+		}
+		return 0, player.WriteError(consts.ErrorsStakeInvalid)
+	}
+	if selected < 1 || selected > len(consts.TexasStakes) {
+		return 0, player.WriteError(consts.ErrorsStakeInvalid)
+	}
+	return selected - 1, nil
+}
@@ -0,0 +1,72 @@
+package state
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ratel-online/server/consts"
+	"github.com/ratel-online/server/database"
+)
+
+type replay struct{}
+
+func (s *replay) Next(player *database.Player) (consts.StateID, error) {
+	buf := bytes.Buffer{}
+	buf.WriteString(fmt.Sprintf("%-10s%-16s%-20s%s\n", "ID", "Type", "Time", "Players"))
+	for _, journal := range database.RecentJournals(consts.ReplayListSize) {
+		buf.WriteString(fmt.Sprintf("%-10d%-16s%-20s%s\n", journal.ID, consts.GameTypes[journal.Type], journal.StartTime.Format("2006-01-02 15:04"), strings.Join(journal.Players, ", ")))
+	}
+	buf.WriteString("Please input game id to replay:\n")
+	err := player.WriteString(buf.String())
+	if err != nil {
+		return 0, player.WriteError(err)
+	}
+	signal, err := player.AskForString()
+	if err != nil {
+		return 0, player.WriteError(err)
+	}
+	if isLs(signal) {
+		return consts.StateReplay, nil
+	}
+	gameId, err := strconv.ParseInt(strings.TrimSpace(signal), 10, 64)
+	if err != nil {
+		return 0, player.WriteError(consts.ErrorsGameNotFound)
+	}
+	journal := database.GetJournal(gameId)
+	if journal == nil {
+		return 0, player.WriteError(consts.ErrorsGameNotFound)
+	}
+	return consts.StateReplay, s.play(player, journal)
+}
+
+// play 逐步回放对局，a 显示剩余全部事件，e 退出回放
+func (*replay) play(player *database.Player, journal *database.Journal) error {
+	buf := bytes.Buffer{}
+	buf.WriteString(fmt.Sprintf("Game %d, %s, room %d\n", journal.ID, consts.GameTypes[journal.Type], journal.RoomID))
+	buf.WriteString(fmt.Sprintf("Players: %s\n", strings.Join(journal.Players, ", ")))
+	buf.WriteString(fmt.Sprintf("Time: %s - %s\n", journal.StartTime.Format("2006-01-02 15:04:05"), journal.EndTime.Format("15:04:05")))
+	buf.WriteString("Input anything for next step, 'a' to show all, 'e' to exit.\n")
+	_ = player.WriteString(buf.String())
+	all := false
+	for i, event := range journal.Events {
+		_ = player.WriteString(fmt.Sprintf("#%d/%d %s\n", i+1, len(journal.Events), strings.TrimSpace(event.String())))
+		if all || i == len(journal.Events)-1 {
+			continue
+		}
+		ans, err := player.AskForString()
+		if err != nil {
+			if err == consts.ErrorsExist {
+				return nil
+			}
+			return err
+		}
+		all = strings.ToLower(strings.TrimSpace(ans)) == "a"
+	}
+	return player.WriteString("Replay finished.\n")
+}
+
+func (*replay) Exit(player *database.Player) consts.StateID {
+	return consts.StateHome
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/ratel-online/core/util/async"
 	"github.com/ratel-online/server/consts"
 	"github.com/ratel-online/server/database"
+	"github.com/ratel-online/server/metrics"
 	"github.com/ratel-online/server/state/game"
 )
 
@@ -26,6 +27,12 @@ func init() {
 	register(consts.StateTexasGame, &texas.Texas{})
 	register(consts.StateLiarGame, &game.Liar{})
 	register(consts.StateUndercoverGame, &game.Undercover{})
+	register(consts.StateReplay, &replay{})
+	register(consts.StateMatch, &match{})
+	register(consts.StateTournament, &tournament{})
+	database.SetRobotRunner(func(robot *database.Player) {
+		go Run(robot)
+	})
 }
 
 func register(id consts.StateID, state State) {
@@ -37,10 +44,14 @@ type State interface {
 	Exit(player *database.Player) consts.StateID
 }
 
+// Run 运行玩家的状态机，机器人从等待房间开始，回到大厅时退出
 func Run(player *database.Player) {
-	player.State(consts.StateWelcome)
+	if !player.IsRobot() {
+		player.State(consts.StateWelcome)
+	}
 	defer func() {
 		if err := recover(); err != nil {
+			metrics.StateErrors.Inc("panic")
 			async.PrintStackTrace(err)
 		}
 		log.Infof("player %s state machine break up.\n", player)
@@ -51,14 +62,19 @@ func Run(player *database.Player) {
 		if loopCount%100 == 0 {
 			log.Infof("[State.Run] Player %d loop count: %d, current state: %d\n", player.ID, loopCount, player.GetState())
 		}
+		if player.IsRobot() && player.GetState() == consts.StateHome {
+			break
+		}
 		state := states[player.GetState()]
 		stateId, err := state.Next(player)
 		if err != nil {
 			if err1, ok := err.(consts.Error); ok {
 				if err1.Exit {
+					metrics.StateErrors.Inc("exit")
 					stateId = state.Exit(player)
 				}
 			} else {
+				metrics.StateErrors.Inc("fatal")
 				log.Error(err)
 				state.Exit(player)
 				break
@@ -0,0 +1,112 @@
+package state
+
+import (
+	"strings"
+	"time"
+
+	"github.com/ratel-online/core/log"
+	"github.com/ratel-online/server/consts"
+	"github.com/ratel-online/server/database"
+	"github.com/ratel-online/server/render"
+	"github.com/spf13/cast"
+)
+
+func init() {
+	database.SetTableStarter(startTable)
+}
+
+type tournament struct{}
+
+func (s *tournament) Next(player *database.Player) (consts.StateID, error) {
+	_ = player.WriteString("Tournament lobby, commands: ls, new <game type>, join <id>, quit <id>, start <id>, v <id>, e\n")
+	_ = render.TournamentList(player)
+
+	player.StartTransaction()
+	defer player.StopTransaction()
+	loopCount := 0
+	for {
+		loopCount++
+		if loopCount%100 == 0 {
+			log.Infof("[tournament] Player %d loop count: %d\n", player.ID, loopCount)
+		}
+		signal, err := player.AskForStringWithoutTransaction(time.Second)
+		if err != nil && err != consts.ErrorsTimeout {
+			return 0, err
+		}
+		// 开赛或进入下一轮时玩家被安排到牌桌
+		if player.RoomID != 0 {
+			return consts.StateWaiting, nil
+		}
+		signal = strings.TrimSpace(strings.ToLower(signal))
+		if signal == "" {
+			continue
+		}
+		if isExit(signal) {
+			database.Unregister(player.ID)
+			return consts.StateHome, nil
+		}
+		if isLs(signal) {
+			_ = render.TournamentList(player)
+			continue
+		}
+		segments := strings.Fields(signal)
+		if len(segments) != 2 {
+			_ = player.WriteError(consts.ErrorsInputInvalid)
+			continue
+		}
+		if segments[0] == "new" {
+			t, err := database.CreateTournament(player, cast.ToInt(segments[1]))
+			if err != nil {
+				_ = player.WriteError(err)
+				continue
+			}
+			_ = render.Standings(player, t)
+			continue
+		}
+		t := database.GetTournament(cast.ToInt64(segments[1]))
+		if t == nil {
+			_ = player.WriteError(consts.ErrorsTournamentNotFound)
+			continue
+		}
+		switch segments[0] {
+		case "join":
+			err = t.Register(player.ID)
+		case "quit":
+			t.Unregister(player.ID)
+		case "start":
+			err = t.Start(player.ID)
+		case "v":
+		default:
+			err = consts.ErrorsInputInvalid
+		}
+		if err != nil {
+			_ = player.WriteError(err)
+			continue
+		}
+		if segments[0] != "start" {
+			_ = render.Standings(player, t)
+		}
+	}
+}
+
+func (*tournament) Exit(player *database.Player) consts.StateID {
+	database.Unregister(player.ID)
+	return consts.StateHome
+}
+
+// startTable 锦标赛牌桌补齐机器人后以房主的身份开局
+func startTable(room *database.Room, robots int) error {
+	for i := 0; i < robots; i++ {
+		robot, err := database.AddRobot(room.ID)
+		if err != nil {
+			return err
+		}
+		go Run(robot)
+		render.Join(robot, room)
+	}
+	creator := database.GetPlayer(room.Creator)
+	if creator == nil {
+		return consts.ErrorsPlayerNotFound
+	}
+	return startGame(creator, room)
+}
@@ -1,7 +1,6 @@
 package state
 
 import (
-	"bytes"
 	"fmt"
 	"strings"
 	"time"
@@ -12,6 +11,8 @@ import (
 	"github.com/ratel-online/core/log"
 	"github.com/ratel-online/server/consts"
 	"github.com/ratel-online/server/database"
+	"github.com/ratel-online/server/metrics"
+	"github.com/ratel-online/server/render"
 	"github.com/ratel-online/server/rule"
 	"github.com/ratel-online/server/state/game"
 )
@@ -29,6 +30,16 @@ func (s *waiting) Next(player *database.Player) (consts.StateID, error) {
 	if err != nil {
 		return 0, err
 	}
+	// 锦标赛换桌或出局，玩家已经被移出了这个房间
+	if player.RoomID != room.ID {
+		if player.RoomID != 0 {
+			return consts.StateWaiting, nil
+		}
+		if database.PlayerTournament(player.ID) != nil {
+			return consts.StateTournament, nil
+		}
+		return consts.StateHome, nil
+	}
 	if access {
 		switch room.Type {
 		default:
@@ -55,10 +66,11 @@ func (s *waiting) Exit(player *database.Player) consts.StateID {
 	if room != nil {
 		isOwner := room.Creator == player.ID
 		database.LeaveRoom(room.ID, player.ID)
-		database.Broadcast(room.ID, fmt.Sprintf("%s exited room! room current has %d players\n", player.Name, room.Players))
+		render.Exit(player, room)
 		if isOwner {
-			newOwner := database.GetPlayer(room.Creator)
-			database.Broadcast(room.ID, fmt.Sprintf("%s become new owner\n", newOwner.Name))
+			if newOwner := database.GetPlayer(room.Creator); newOwner != nil {
+				render.OwnerChange(newOwner, room)
+			}
 		}
 		s.Backfill(room)
 	}
@@ -71,16 +83,38 @@ func (*waiting) Backfill(room *database.Room) {
 	}
 	newPlayer := database.Backfill(room.ID)
 	if newPlayer != nil {
-		database.Broadcast(room.ID, fmt.Sprintf("%s has joined room! room current has %d players\n", newPlayer.Name, room.Players))
+		render.Join(newPlayer, room)
 	}
 }
 
 func (*waiting) Kicking(player *database.Player) {
 	room := database.GetRoom(player.RoomID)
 	if room != nil {
-		database.Broadcast(room.ID, fmt.Sprintf("%s has been kicked!\n", player.Name))
 		database.Kicking(room.ID, player.ID)
-		database.Broadcast(room.ID, fmt.Sprintf("room current has %d players\n", room.Players))
+		render.Kicked(player, room)
+	}
+}
+
+// Robot 房主添加或移除机器人
+func (*waiting) Robot(player *database.Player, room *database.Room, op string) {
+	switch op {
+	case "add":
+		robot, err := database.AddRobot(room.ID)
+		if err != nil {
+			_ = player.WriteError(err)
+			return
+		}
+		go Run(robot)
+		render.Join(robot, room)
+	case "del":
+		robot, err := database.RemoveRobot(room.ID)
+		if err != nil {
+			_ = player.WriteError(err)
+			return
+		}
+		render.Exit(robot, room)
+	default:
+		_ = player.WriteError(consts.ErrorsInputInvalid)
 	}
 }
 
@@ -89,6 +123,7 @@ func (s *waiting) waitingForStart(player *database.Player, room *database.Room)
 	//对局类别
 	player.StartTransaction()
 	defer player.StopTransaction()
+	watched := ""
 	loopCount := 0
 	for {
 		loopCount++
@@ -100,14 +135,20 @@ func (s *waiting) waitingForStart(player *database.Player, room *database.Room)
 			return access, err
 		}
 
+		if player.RoomID != room.ID {
+			return false, nil
+		}
 		if !database.IsValidPlayer(room.ID, player.ID) {
 			return false, consts.ErrorsPlayerNotInRoom
 		}
 
-		if room.State == consts.RoomStateRunning && player.Role == database.RolePlayer {
+		if room.State == consts.RoomStateRunning && player.Role != database.RoleSpectator {
 			access = true
 			break
 		}
+		if room.State == consts.RoomStateRunning {
+			watched = spectate(player, room, watched)
+		}
 		signal = strings.TrimSpace(strings.ToLower(signal))
 		if signal == "" {
 			continue
@@ -115,23 +156,20 @@ func (s *waiting) waitingForStart(player *database.Player, room *database.Room)
 
 		segments := strings.Split(signal, " ")
 		if len(segments) == 1 {
-			if segments[0] == "ls" || segments[0] == "v" {
-				viewRoomPlayers(room, player)
+			if segments[0] == "v" && room.State == consts.RoomStateRunning {
+				if view := database.Spectate(room); view != nil {
+					_ = render.Spectate(player, view)
+				}
+				continue
+			} else if segments[0] == "ls" || segments[0] == "v" {
+				_ = render.RoomPlayers(player, room)
 				continue
 			} else if segments[0] == "start" || signal == "s" {
 				if room.Creator == player.ID {
-					if room.Players <= 1 {
-						_ = player.WriteError(consts.ErrorsGamePlayersInsufficient)
+					if err = checkStart(room); err != nil {
+						_ = player.WriteError(err)
 						continue
 					}
-					if room.Type == consts.GameTypeRunFast && room.Players != 3 {
-				_ = player.WriteError(consts.ErrorsGamePlayersInvalid)
-				continue
-			}
-			if room.Type == consts.GameTypeUndercover && room.Players < 3 {
-				_ = player.WriteString("谁是卧底游戏至少需要3名玩家！\n")
-				continue
-			}
 					err = startGame(player, room)
 					if err != nil {
 						return access, err
@@ -139,6 +177,27 @@ func (s *waiting) waitingForStart(player *database.Player, room *database.Room)
 					access = true
 					break
 				}
+			} else if segments[0] == "rematch" && player.Role != database.RoleSpectator && room.State != consts.RoomStateRunning {
+				accepted, total, ready := database.Rematch(room, player.ID)
+				database.Broadcast(room.ID, fmt.Sprintf("%s accepted rematch (%d/%d)\n", player.Name, accepted, total))
+				if !ready {
+					continue
+				}
+				if err = checkStart(room); err != nil {
+					_ = player.WriteError(err)
+					continue
+				}
+				err = startGame(player, room)
+				if err != nil {
+					return access, err
+				}
+				access = true
+				break
+			}
+		} else if len(segments) == 2 && segments[0] == "robot" {
+			if room.Creator == player.ID {
+				s.Robot(player, room, segments[1])
+				continue
 			}
 		} else if len(segments) == 2 {
 			if segments[0] == "kicking" || segments[0] == "kill" || segments[0] == "k" {
@@ -165,7 +224,15 @@ func (s *waiting) waitingForStart(player *database.Player, room *database.Room)
 		}
 
 		if room.EnableChat {
-			if room.State == consts.RoomStateRunning {
+			if room.State == consts.RoomStateRunning && player.Role == database.RoleSpectator {
+				// 对局中观众的聊天默认只发给其他观众，开启 sc 后玩家也能看到
+				msg := fmt.Sprintf("%s [%s] say: %s\n", player.Name, player.Role, signal)
+				if room.EnableSpectatorChat {
+					database.BroadcastChat(player, msg)
+				} else {
+					database.BroadcastSpectatorChat(player, msg)
+				}
+			} else if room.State == consts.RoomStateRunning {
 				_ = player.WriteString(fmt.Sprintf("%s\n", consts.ErrorsChatUnopenedDuringGame.Error()))
 			} else {
 				database.BroadcastChat(player, fmt.Sprintf("%s [%s] say: %s\n", player.Name, player.Role, signal))
@@ -177,9 +244,44 @@ func (s *waiting) waitingForStart(player *database.Player, room *database.Room)
 	return access, nil
 }
 
+// spectate 对局开始或延迟公开的手牌变化时向观众推送对局，返回已推送内容的标识
+func spectate(player *database.Player, room *database.Room, watched string) string {
+	view := database.Spectate(room)
+	if view == nil {
+		return watched
+	}
+	key := fmt.Sprintf("%p%v", room.Game, view.Hands)
+	if key != watched {
+		_ = render.Spectate(player, view)
+	}
+	return key
+}
+
+// checkStart 开局前检查服务状态和房间人数
+func checkStart(room *database.Room) error {
+	if database.Draining() {
+		return consts.ErrorsServerDraining
+	}
+	if room.Players <= 1 {
+		return consts.ErrorsGamePlayersInsufficient
+	}
+	if room.Type == consts.GameTypeRunFast && room.Players != 3 {
+		return consts.ErrorsGamePlayersInvalid
+	}
+	if room.Type == consts.GameTypeUndercover && room.Players < 3 {
+		return consts.ErrorsUndercoverPlayers
+	}
+	return nil
+}
+
 func startGame(player *database.Player, room *database.Room) (err error) {
 	room.Lock()
 	defer room.Unlock()
+	if room.State == consts.RoomStateRunning {
+		return nil
+	}
+	room.Rematch = nil
+	database.StartJournal(room)
 	switch room.Type {
 	default:
 		room.Game, err = game.InitGame(room)
@@ -197,80 +299,12 @@ func startGame(player *database.Player, room *database.Room) (err error) {
 		room.Game, err = game.InitUndercoverGame(room)
 	}
 	if err != nil {
+		room.Journal = nil
 		_ = player.WriteError(err)
 		return err
 	}
+	database.Snapshot(room)
 	room.State = consts.RoomStateRunning
+	metrics.GamesStarted.Inc(consts.GameTypes[room.Type])
 	return nil
 }
-
-func viewRoomPlayers(room *database.Room, currPlayer *database.Player) {
-	buf := bytes.Buffer{}
-	buf.WriteString(fmt.Sprintf("Room ID: %d\n", room.ID))
-	buf.WriteString("Players:\n")
-	for playerId := range database.RoomPlayers(room.ID) {
-		player := database.GetPlayer(playerId)
-		if room.EnableShowIP {
-			buf.WriteString(fmt.Sprintf("%s [%s], score: %d, id: %d, ip: %s\n", player.Name, player.Role, player.Amount, player.ID, maskIP(player.IP)))
-		} else {
-			buf.WriteString(fmt.Sprintf("%s [%s], score: %d, id: %d\n", player.Name, player.Role, player.Amount, player.ID))
-		}
-	}
-
-	buf.WriteString("\nSpectators:\n")
-	for spectatorId := range database.RoomSpectators(room.ID) {
-		spectator := database.GetPlayer(spectatorId)
-		if room.EnableShowIP {
-			buf.WriteString(fmt.Sprintf("%s [spectator], score: %d, id: %d, ip: %s\n", spectator.Name, spectator.Amount, spectator.ID, maskIP(spectator.IP)))
-		} else {
-			buf.WriteString(fmt.Sprintf("%s [spectator], score: %d, id: %d\n", spectator.Name, spectator.Amount, spectator.ID))
-		}
-	}
-
-	buf.WriteString("\nSettings:\n")
-	switch room.Type {
-	case consts.GameTypeUno, consts.GameTypeMahjong:
-		buf.WriteString(fmt.Sprintf("%-5s%-5v\n", "ip:", sprintPropsState(room.EnableShowIP)))
-	case consts.GameTypeTexas:
-		buf.WriteString(fmt.Sprintf("%-5s%-5v\n", "pn:", room.MaxPlayers))
-		buf.WriteString(fmt.Sprintf("%-5s%-5v\n", "ip:", sprintPropsState(room.EnableShowIP)))
-	case consts.GameTypeLiar:
-		buf.WriteString(fmt.Sprintf("%-5s%-5v\n", "jt:", sprintPropsState(room.EnableJokerAsTarget)))
-		buf.WriteString(fmt.Sprintf("%-5s%-5v\n", "ip:", sprintPropsState(room.EnableShowIP)))
-	case consts.GameTypeUndercover:
-		buf.WriteString(fmt.Sprintf("%-5s%-5v\n", "pn:", room.MaxPlayers))
-		buf.WriteString(fmt.Sprintf("%-5s%-5v\n", "ucn:", room.UndercoverNum))
-		buf.WriteString(fmt.Sprintf("%-5s%-5v\n", "bwm:", sprintPropsState(room.BlankWordMode)))
-		buf.WriteString(fmt.Sprintf("%-5s%-5v\n", "ip:", sprintPropsState(room.EnableShowIP)))
-	default:
-		buf.WriteString(fmt.Sprintf("%-5s%-5v\n", "lz:", sprintPropsState(room.EnableLaiZi)))
-		buf.WriteString(fmt.Sprintf("%-5s%-5v, %-5s%-5v\n", "ds:", sprintPropsState(room.EnableDontShuffle), "sk:", sprintPropsState(room.EnableSkill)))
-		buf.WriteString(fmt.Sprintf("%-5s%-5v, %-5s%-5v\n", "pn:", room.MaxPlayers, "ct:", sprintPropsState(room.EnableChat)))
-		buf.WriteString(fmt.Sprintf("%-5s%-5v\n", "ip:", sprintPropsState(room.EnableShowIP)))
-		pwd := room.Password
-		if pwd != "" {
-			if room.Creator != currPlayer.ID {
-				pwd = "********"
-			}
-		} else {
-			pwd = "off"
-		}
-		buf.WriteString(fmt.Sprintf("%-5s%-20v\n", "pwd", pwd))
-	}
-	_ = currPlayer.WriteString(buf.String())
-}
-
-func sprintPropsState(on bool) string {
-	if on {
-		return "on"
-	}
-	return "off"
-}
-
-func maskIP(ip string) string {
-	parts := strings.Split(ip, ".")
-	if len(parts) == 4 {
-		return parts[0] + "." + parts[1] + ".*.*"
-	}
-	return "*.*.*.*"
-}